@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 
@@ -10,6 +11,8 @@ import (
 	"github.com/escalopa/vego/internal/db"
 	"github.com/escalopa/vego/internal/room"
 	"github.com/escalopa/vego/internal/service"
+	"github.com/escalopa/vego/internal/storage"
+	"github.com/escalopa/vego/internal/turn"
 )
 
 var configPath = flag.String("config", "config.yml", "path to config file")
@@ -26,10 +29,62 @@ func main() {
 	}
 	defer func() { _ = database.Close() }()
 
-	hubInstance := room.NewHub()
-	userTokenProvider := auth.NewUserProvider(cfg.JWT.User)
-	roomTokenProvider := auth.NewRoomProvider(cfg.JWT.Room)
-	oauthProvider := auth.NewOAuthProvider(cfg.OAuth)
+	recordingStorage, err := storage.NewLocal(cfg.Recording.Dir)
+	if err != nil {
+		log.Fatalf("init recording storage: %v", err)
+	}
+
+	if cfg.TURN.Enabled {
+		turnServer, err := turn.NewServer(cfg.TURN)
+		if err != nil {
+			log.Fatalf("init turn server: %v", err)
+		}
+		defer func() { _ = turnServer.Close() }()
+	}
+
+	hubInstance := room.NewHub(room.Config{
+		MaxParticipants:    cfg.Room.MaxParticipants,
+		MaxUserConnections: cfg.Room.MaxUserConnections,
+		MaxActiveRooms:     cfg.Room.MaxActiveRooms,
+		IdleGracePeriod:    cfg.Room.IdleGracePeriod,
+
+		MaxMessageSize:  cfg.Room.MaxMessageSize,
+		ChatSlowMode:    cfg.Room.ChatSlowMode,
+		ChatRate:        room.RateLimit(cfg.Room.RateLimits.Chat),
+		SignalingRate:   room.RateLimit(cfg.Room.RateLimits.Signaling),
+		MaxViolations:   cfg.Room.MaxViolations,
+		ViolationWindow: cfg.Room.ViolationWindow,
+	})
+	jwtKeys, err := auth.NewKeySet(cfg.JWT.Keys, cfg.JWT.Issuer)
+	if err != nil {
+		log.Fatalf("init jwt keys: %v", err)
+	}
+	userTokenProvider := auth.NewUserProvider(cfg.JWT.User, jwtKeys)
+	roomTokenProvider := auth.NewRoomProvider(cfg.JWT.Room, jwtKeys)
+	oauthProvider, err := auth.NewOAuthProvider(context.Background(), cfg.OAuth)
+	if err != nil {
+		log.Fatalf("init oauth provider: %v", err)
+	}
+	iceProvider := turn.NewProvider(cfg.ICE, cfg.TURN, cfg.JWT.Room.TokenTTL)
+
+	srv := service.New(
+		service.Config{
+			RefreshTokenTTL: cfg.JWT.User.RefreshTokenTTL,
+			OAuthStateTTL:   cfg.OAuth.StateTTL,
+			OAuthAutoLink:   cfg.OAuth.AutoLink,
+
+			AccessTokenMaxTTL: cfg.AccessToken.MaxTTL,
+
+			LocalMinPasswordLength: cfg.Local.MinPasswordLength,
+
+			RecordingRetention:     cfg.Recording.Retention,
+			RecordingPurgeInterval: cfg.Recording.PurgeInterval,
+			RecordingMaxUploadSize: cfg.Recording.MaxUploadSize,
+			RecordingUploadTTL:     cfg.Recording.UploadTTL,
+		},
+		database, recordingStorage, hubInstance, oauthProvider, userTokenProvider, roomTokenProvider, iceProvider,
+	)
+	go srv.RunRecordingsPurge(context.Background())
 
 	s := app.New(
 		app.Config{
@@ -37,9 +92,17 @@ func main() {
 			AllowOrigins:    cfg.App.AllowOrigins,
 			AccessTokenTTL:  cfg.JWT.User.AccessTokenTTL,
 			RefreshTokenTTL: cfg.JWT.User.RefreshTokenTTL,
-		}, service.New(
-			database, hubInstance, oauthProvider, userTokenProvider, roomTokenProvider,
-		),
+			OAuthStateTTL:   cfg.OAuth.StateTTL,
+
+			MinProtocolVersion: cfg.Room.MinProtocolVersion,
+
+			InternalToken: cfg.App.InternalToken,
+
+			LocalEnabled:        cfg.Local.Enabled,
+			LocalRegistration:   cfg.Local.Registration,
+			LocalPasswordChange: cfg.Local.PasswordChange,
+			LocalAdminReset:     cfg.Local.AdminReset,
+		}, srv,
 	)
 
 	if err := s.Run(cfg.App.Addr); err != nil {
@@ -0,0 +1,73 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/gin-gonic/gin"
+)
+
+type accessTokenBody struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// TTL in seconds
+	TTL int64 `json:"ttl"`
+}
+
+func (a *App) listAccessTokens(c *gin.Context) {
+	user := a.user(c)
+	tokens, err := a.srv.ListAccessTokens(c.Request.Context(), user.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "temporary cannot list access tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"access_tokens": tokens})
+}
+
+// createAccessToken responds with the token, it cannot be retrieved again
+func (a *App) createAccessToken(c *gin.Context) {
+	var body accessTokenBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "corrupted request body"})
+		return
+	}
+
+	if body.Name == "" || len(body.Name) > 64 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "access token name must have 1 to 64 characters"})
+		return
+	}
+
+	user := a.user(c)
+	token, secret, err := a.srv.CreateAccessToken(c.Request.Context(), user.UserID, body.Name, body.Scopes, time.Duration(body.TTL)*time.Second)
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrAccessTokenInvalidScope):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid access token scopes", "scopes": domain.Scopes})
+		case errors.Is(err, domain.ErrAccessTokenInvalidTTL):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid access token ttl"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "temporary cannot create access token"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"access_token": token, "token": secret})
+}
+
+func (a *App) revokeAccessToken(c *gin.Context) {
+	user := a.user(c)
+	err := a.srv.RevokeAccessToken(c.Request.Context(), user.UserID, c.Param("token_id"))
+	if err != nil {
+		if errors.Is(err, domain.ErrDBAccessTokenNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "access token not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "temporary cannot revoke access token"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
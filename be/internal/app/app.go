@@ -3,11 +3,15 @@ package app
 import (
 	"context"
 	"errors"
+	"io"
 	"net/http"
 	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/escalopa/vego/internal/domain"
+	"github.com/escalopa/vego/internal/sse"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -15,12 +19,52 @@ import (
 )
 
 type service interface {
-	GetOAuthRedirectURL(provider string) (string, error)
-	RegisterUser(ctx context.Context, provider string, code string) (*domain.Token, error)
-	AuthenticateUser(ctx context.Context, token *domain.Token) (*domain.User, *domain.Token, error)
-	CreateRoomToken(userID int64, roomID string) (string, error)
-	AuthenticateWS(ctx context.Context, token string, roomID string) (*domain.User, error)
-	HandleWS(user *domain.User, roomID string, conn *websocket.Conn)
+	GetJWKS() []domain.JWK
+	GetOAuthProviders() []domain.OAuthProvider
+	GetOAuthRedirectURL(ctx context.Context, provider string) (string, string, error)
+	RegisterUser(ctx context.Context, provider string, code string, state string, browserState string, device domain.Device) (*domain.Token, error)
+	AuthenticateUser(ctx context.Context, token *domain.Token, device domain.Device) (*domain.User, string, *domain.Token, error)
+	CreateRoom(ctx context.Context, userID int64) (string, error)
+	CreateRoomToken(ctx context.Context, userID int64, roomID string, sessionID string) (string, error)
+	CreateICEServers(userID int64) ([]domain.ICEServer, error)
+	AuthenticateWS(ctx context.Context, token string, roomID string) (*domain.User, string, error)
+	AuthenticateRoom(ctx context.Context, token string, roomID string) (*domain.User, string, error)
+	HandleConn(user *domain.User, roomID string, client domain.Client, conn domain.Conn)
+	GetEventSchema() map[string]any
+	GetSubprotocols() []string
+	GetRoomStats() domain.RoomStats
+
+	Logout(ctx context.Context, userID int64, sessionID string) error
+	ListSessions(ctx context.Context, userID int64) ([]domain.Session, error)
+	RevokeSession(ctx context.Context, userID int64, sessionID string) error
+	RevokeOtherSessions(ctx context.Context, userID int64, currentSessionID string) error
+
+	CreateAccessToken(ctx context.Context, userID int64, name string, scopes []string, ttl time.Duration) (*domain.AccessToken, string, error)
+	ListAccessTokens(ctx context.Context, userID int64) ([]domain.AccessToken, error)
+	RevokeAccessToken(ctx context.Context, userID int64, tokenID string) error
+	AuthenticateAccessToken(ctx context.Context, token string) (*domain.User, []string, error)
+
+	RegisterLocalUser(ctx context.Context, username string, password string, user *domain.User, device domain.Device) (*domain.Token, error)
+	CreateLocalUser(ctx context.Context, username string, password string, user *domain.User) (int64, error)
+	LoginLocalUser(ctx context.Context, username string, password string, device domain.Device) (*domain.Token, error)
+	ChangePassword(ctx context.Context, userID int64, sessionID string, currentPassword string, password string) error
+	ResetPassword(ctx context.Context, username string, password string) error
+
+	ListIdentities(ctx context.Context, userID int64) ([]domain.Identity, error)
+	LinkIdentity(ctx context.Context, userID int64, provider string) (string, string, error)
+	UnlinkIdentity(ctx context.Context, userID int64, provider string) error
+
+	SetRoomRetention(ctx context.Context, userID int64, roomID string, retention time.Duration) error
+	ListRecordings(ctx context.Context, userID int64) ([]domain.Recording, error)
+	GetRecording(ctx context.Context, userID int64, recordingID string) (*domain.Recording, error)
+	OpenRecording(ctx context.Context, userID int64, recordingID string) (*domain.Recording, io.ReadSeekCloser, error)
+	DeleteRecording(ctx context.Context, userID int64, recordingID string) error
+
+	CreateUpload(ctx context.Context, userID int64, roomID string, contentType string, length int64) (*domain.Upload, error)
+	GetUpload(ctx context.Context, userID int64, uploadID string) (*domain.Upload, error)
+	ListUploads(ctx context.Context, userID int64) ([]domain.Upload, error)
+	WriteUpload(ctx context.Context, userID int64, uploadID string, offset int64, chunk io.Reader, size int64) (*domain.Upload, *domain.Recording, error)
+	DeleteUpload(ctx context.Context, userID int64, uploadID string) error
 }
 
 type Config struct {
@@ -29,6 +73,17 @@ type Config struct {
 
 	AccessTokenTTL  time.Duration
 	RefreshTokenTTL time.Duration
+	OAuthStateTTL   time.Duration
+
+	MinProtocolVersion int // clients declaring an older room protocol version must upgrade
+
+	InternalToken string // bearer token of the internal api, empty disables it
+
+	// local users log in with a username and password, the other features need it enabled
+	LocalEnabled        bool
+	LocalRegistration   bool
+	LocalPasswordChange bool
+	LocalAdminReset     bool // operators create users and reset passwords through the internal api
 }
 
 type App struct {
@@ -37,13 +92,15 @@ type App struct {
 	r   *gin.Engine
 	srv service
 	upg *websocket.Upgrader
+	sse *sse.Registry
 }
 
 func New(cfg Config, srv service) *App {
 	kors := cors.New(cors.Config{
 		AllowOrigins:     cfg.AllowOrigins,
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Accept", "Authorization", "Content-Type"},
+		AllowMethods:     []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Accept", "Authorization", "Content-Type", "Range", "Upload-Offset"},
+		ExposeHeaders:    []string{"Accept-Ranges", "Content-Length", "Content-Range", "Location", "Upload-Offset", "Upload-Length"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	})
@@ -51,6 +108,8 @@ func New(cfg Config, srv service) *App {
 	upgrader := &websocket.Upgrader{
 		ReadBufferSize:  1024 * 1024, // 1MB
 		WriteBufferSize: 1024 * 1024, // 1MB
+		// messages are encoded in json unless the client asks for a binary encoding
+		Subprotocols: srv.GetSubprotocols(),
 		CheckOrigin: func(r *http.Request) bool {
 			return slices.Contains(cfg.AllowOrigins, r.Header.Get("Origin"))
 		},
@@ -61,6 +120,7 @@ func New(cfg Config, srv service) *App {
 		cfg: cfg,
 		srv: srv,
 		upg: upgrader,
+		sse: sse.NewRegistry(),
 	}
 
 	a.r.Use(kors)
@@ -75,23 +135,80 @@ func (a *App) Run(address string) error {
 
 func (a *App) setup() {
 	a.r.GET("/api/health", a.health)
+	a.r.GET("/api/room/schema", a.roomSchema)
+	a.r.GET("/.well-known/jwks.json", a.jwks)
 
 	userRoutes := a.r.Group("/api/user")
 	userRoutes.Use(a.authMiddleware)
 	{
+		// personal access tokens read who they act for but do not manage the account
 		userRoutes.GET("/info", a.getUserInfo)
-		userRoutes.POST("/logout", a.logout)
+
+		sessionRoutes := userRoutes.Group("", a.requireSession)
+		sessionRoutes.POST("/logout", a.logout)
+		sessionRoutes.GET("/sessions", a.listSessions)
+		sessionRoutes.DELETE("/sessions", a.revokeOtherSessions)
+		sessionRoutes.DELETE("/sessions/:session_id", a.revokeSession)
+		sessionRoutes.GET("/identities", a.listIdentities)
+		sessionRoutes.POST("/identities/:provider", a.linkIdentity)
+		sessionRoutes.DELETE("/identities/:provider", a.unlinkIdentity)
+		sessionRoutes.GET("/tokens", a.listAccessTokens)
+		sessionRoutes.POST("/tokens", a.createAccessToken)
+		sessionRoutes.DELETE("/tokens/:token_id", a.revokeAccessToken)
+		if a.cfg.LocalEnabled && a.cfg.LocalPasswordChange {
+			sessionRoutes.PUT("/password", a.changePassword)
+		}
 	}
 
 	roomRoutes := a.r.Group("/api/room")
 	roomRoutes.Use(a.authMiddleware)
 	{
-		roomRoutes.POST("/join/:room_id", a.joinRoom)
-		roomRoutes.GET("/ws/:room_id", a.ws)
+		roomRoutes.POST("", a.requireScope(domain.ScopeRoomsWrite), a.createRoom)
+		roomRoutes.GET("/ice-servers", a.requireScope(domain.ScopeRoomsRead), a.iceServers)
+		roomRoutes.POST("/join/:room_id", a.requireSession, a.joinRoom) // the room token is bound to the session
+		roomRoutes.GET("/ws/:room_id", a.requireSession, a.ws)
+		roomRoutes.GET("/sse/:room_id", a.requireSession, a.sseStream)
+		roomRoutes.POST("/sse/:room_id/:stream_id", a.requireSession, a.ssePost)
+		roomRoutes.DELETE("/sse/:room_id/:stream_id", a.requireSession, a.sseLeave)
+		roomRoutes.PUT("/:room_id/retention", a.requireScope(domain.ScopeRoomsWrite), a.setRoomRetention)
+	}
+
+	recordingRoutes := a.r.Group("/api/recording")
+	recordingRoutes.Use(a.authMiddleware)
+	{
+		recordingRoutes.GET("", a.requireScope(domain.ScopeRecordingsRead), a.listRecordings)
+		recordingRoutes.GET("/:recording_id", a.requireScope(domain.ScopeRecordingsRead), a.getRecording)
+		recordingRoutes.GET("/:recording_id/stream", a.requireScope(domain.ScopeRecordingsRead), a.streamRecording)
+		recordingRoutes.DELETE("/:recording_id", a.requireScope(domain.ScopeRecordingsWrite), a.deleteRecording)
+
+		recordingRoutes.POST("/upload", a.requireScope(domain.ScopeRecordingsWrite), a.createUpload)
+		recordingRoutes.GET("/upload", a.requireScope(domain.ScopeRecordingsRead), a.listUploads)
+		recordingRoutes.HEAD("/upload/:upload_id", a.requireScope(domain.ScopeRecordingsRead), a.uploadStatus)
+		recordingRoutes.PATCH("/upload/:upload_id", a.requireScope(domain.ScopeRecordingsWrite), a.writeUpload)
+		recordingRoutes.DELETE("/upload/:upload_id", a.requireScope(domain.ScopeRecordingsWrite), a.deleteUpload)
+	}
+
+	internalRoutes := a.r.Group("/api/internal")
+	internalRoutes.Use(a.internalMiddleware)
+	{
+		internalRoutes.GET("/room/stats", a.roomStats)
+		if a.cfg.LocalEnabled && a.cfg.LocalAdminReset {
+			internalRoutes.POST("/local/users", a.createLocalUser)
+			internalRoutes.PUT("/local/users/:username/password", a.resetPassword)
+		}
+	}
+
+	if a.cfg.LocalEnabled {
+		localRoutes := a.r.Group("/api/local")
+		localRoutes.POST("/login", a.localLogin)
+		if a.cfg.LocalRegistration {
+			localRoutes.POST("/register", a.localRegister)
+		}
 	}
 
 	oauthRoutes := a.r.Group("/api/oauth")
 	{
+		oauthRoutes.GET("/providers", a.oauthProviders)
 		oauthRoutes.GET("/:provider", a.oauthRedirect)
 		oauthRoutes.POST("/:provider/callback", a.oauthCallback)
 	}
@@ -101,16 +218,45 @@ func (a *App) health(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
+func (a *App) roomSchema(c *gin.Context) {
+	c.JSON(http.StatusOK, a.srv.GetEventSchema())
+}
+
+// jwks publishes the public keys so other services can verify the tokens without a secret
+func (a *App) jwks(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": a.srv.GetJWKS()})
+}
+
 func (a *App) getUserInfo(c *gin.Context) {
 	user, _ := c.Get("user")
 	c.JSON(http.StatusOK, gin.H{"user": user})
 }
 
 func (a *App) logout(c *gin.Context) {
+	user := a.user(c)
+	if err := a.srv.Logout(c.Request.Context(), user.UserID, a.sessionID(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "temporary cannot logout user"})
+		return
+	}
+
 	a.setTokenCookie(c, nil)
 	c.JSON(http.StatusOK, gin.H{"message": "user logged out"})
 }
 
+// createRoom creates a room owned by the user, access tokens create rooms this way
+// for the participants to join with their sessions
+func (a *App) createRoom(c *gin.Context) {
+	user := a.user(c)
+	roomID, err := a.srv.CreateRoom(c.Request.Context(), user.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "temporary cannot create room"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"room_id": roomID})
+}
+
 func (a *App) joinRoom(c *gin.Context) {
 	roomID := c.Param("room_id")
 	if _, err := uuid.Parse(roomID); err != nil {
@@ -119,41 +265,143 @@ func (a *App) joinRoom(c *gin.Context) {
 	}
 
 	user := a.user(c)
-	token, err := a.srv.CreateRoomToken(user.UserID, roomID)
+	token, err := a.srv.CreateRoomToken(c.Request.Context(), user.UserID, roomID, a.sessionID(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "temporary cannot join room"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"token": token})
+	iceServers, err := a.srv.CreateICEServers(user.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "temporary cannot join room"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": token, "ice_servers": iceServers})
+}
+
+func (a *App) iceServers(c *gin.Context) {
+	user := a.user(c)
+	iceServers, err := a.srv.CreateICEServers(user.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "temporary cannot get ice servers"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ice_servers": iceServers})
 }
 
 func (a *App) ws(c *gin.Context) {
+	user, roomID, client, ok := a.authenticateRoom(c, true)
+	if !ok {
+		return
+	}
+
+	conn, err := a.upg.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "cannot upgrade connection"})
+		return
+	}
+
+	// browsers cannot read the response of a failed upgrade, so outdated
+	// clients are told to upgrade with a close frame instead
+	if client.ProtocolVersion() < a.cfg.MinProtocolVersion {
+		msg := websocket.FormatCloseMessage(closeUpgradeRequired, "upgrade-required")
+		_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		_ = conn.Close()
+		return
+	}
+
+	a.srv.HandleConn(user, roomID, client, conn)
+}
+
+// authenticateRoom validates the room token and reads the protocol declared
+// by the client of a signaling request, it replies with the error if any,
+// admit is set only by the requests opening a connection to the room
+func (a *App) authenticateRoom(c *gin.Context, admit bool) (*domain.User, string, domain.Client, bool) {
 	roomID := c.Param("room_id")
 	if _, err := uuid.Parse(roomID); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "corrupted room id (uuid expected)"})
-		return
+		return nil, "", domain.Client{}, false
 	}
 
 	token := c.Query("token")
 	if token == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "empty room token"})
-		return
+		return nil, "", domain.Client{}, false
 	}
 
-	user, err := a.srv.AuthenticateWS(c.Request.Context(), token, roomID)
+	client, err := parseClient(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "corrupted room token"})
-		return
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, "", domain.Client{}, false
 	}
 
-	conn, err := a.upg.Upgrade(c.Writer, c.Request, nil)
+	authenticate := a.srv.AuthenticateRoom
+	if admit {
+		authenticate = a.srv.AuthenticateWS
+	}
+
+	user, sessionID, err := authenticate(c.Request.Context(), token, roomID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "cannot upgrade connection"})
-		return
+		admissionError(c, err)
+		return nil, "", domain.Client{}, false
+	}
+	client.SessionID = sessionID
+
+	return user, roomID, client, true
+}
+
+// admissionError replies to the signaling requests refused before joining the room
+func admissionError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, domain.ErrRoomFull):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "code": domain.ErrCodeRoomFull})
+	case errors.Is(err, domain.ErrRoomTooManyConnections):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error(), "code": domain.ErrCodeTooManyConnections})
+	case errors.Is(err, domain.ErrRoomCapacity):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error(), "code": domain.ErrCodeCapacity})
+	default:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "corrupted room token"})
+	}
+}
+
+// closeUpgradeRequired is the websocket close code sent to clients speaking
+// a protocol version older than the minimum supported one
+const closeUpgradeRequired = 4426
+
+// parseClient reads the protocol declared by the client, the version and the
+// comma separated capabilities are optional for clients that predate them
+func parseClient(c *gin.Context) (domain.Client, error) {
+	client := domain.Client{
+		// inner id of a previous connection to resume after a connection loss
+		ResumeID: c.Query("resume"),
+	}
+
+	if v := c.Query("version"); v != "" {
+		version, err := strconv.Atoi(v)
+		if err != nil || version < 1 {
+			return domain.Client{}, errors.New("corrupted protocol version (positive integer expected)")
+		}
+		client.Version = version
+	}
+
+	for _, capability := range strings.Split(c.Query("capabilities"), ",") {
+		if capability = strings.TrimSpace(capability); capability != "" {
+			client.Capabilities = append(client.Capabilities, capability)
+		}
 	}
 
-	a.srv.HandleWS(user, roomID, conn)
+	return client, nil
+}
+
+// oauthProviders lists the login providers, local tells the clients whether to offer
+// a username and password login and a registration
+func (a *App) oauthProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"providers": a.srv.GetOAuthProviders(),
+		"local":     gin.H{"enabled": a.cfg.LocalEnabled, "registration": a.cfg.LocalEnabled && a.cfg.LocalRegistration},
+	})
 }
 
 func (a *App) oauthRedirect(c *gin.Context) {
@@ -163,7 +411,7 @@ func (a *App) oauthRedirect(c *gin.Context) {
 		return
 	}
 
-	url, err := a.srv.GetOAuthRedirectURL(provider)
+	url, state, err := a.srv.GetOAuthRedirectURL(c.Request.Context(), provider)
 	if err != nil {
 		if errors.Is(err, domain.ErrOAuthUnsupportedProvider) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported oauth provider"})
@@ -174,11 +422,13 @@ func (a *App) oauthRedirect(c *gin.Context) {
 		return
 	}
 
+	a.setOAuthStateCookie(c, state)
 	c.JSON(http.StatusOK, gin.H{"url": url})
 }
 
 type oauthCallbackBody struct {
-	Code string `json:"code"`
+	Code  string `json:"code"`
+	State string `json:"state"`
 }
 
 func (a *App) oauthCallback(c *gin.Context) {
@@ -199,12 +449,35 @@ func (a *App) oauthCallback(c *gin.Context) {
 		return
 	}
 
-	token, err := a.srv.RegisterUser(c.Request.Context(), provider, body.Code)
+	// the state is single use whatever the outcome of the callback
+	browserState, _ := c.Cookie(oauthStateKey)
+	a.setOAuthStateCookie(c, "")
+
+	token, err := a.srv.RegisterUser(c.Request.Context(), provider, body.Code, body.State, browserState, device(c))
 	if err != nil {
+		if errors.Is(err, domain.ErrOAuthInvalidState) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid oauth state"})
+			return
+		}
+		if errors.Is(err, domain.ErrOAuthEmailNotVerified) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "a verified email is required to log in"})
+			return
+		}
+		if errors.Is(err, domain.ErrIdentityProviderLinked) {
+			c.JSON(http.StatusConflict, gin.H{"error": "another account of the provider is already linked"})
+			return
+		}
+
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "temporary cannot register user"})
 		return
 	}
 
+	// the callback of a link keeps the current login of the user
+	if token == nil {
+		c.JSON(http.StatusOK, gin.H{"linked": true})
+		return
+	}
+
 	a.setTokenCookie(c, token)
 }
 
@@ -212,3 +485,7 @@ func (a *App) user(c *gin.Context) *domain.User {
 	data, _ := c.Get("user")
 	return data.(*domain.User)
 }
+
+func (a *App) sessionID(c *gin.Context) string {
+	return c.GetString("session_id")
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"log"
 	"net/http"
+	"slices"
+	"strings"
 
 	"github.com/escalopa/vego/internal/domain"
 	"github.com/gin-gonic/gin"
@@ -12,9 +14,16 @@ import (
 const (
 	accessTokenKey  = "X-Access-Token"
 	refreshTokenKey = "X-Refresh-Token"
+	oauthStateKey   = "X-OAuth-State"
 )
 
 func (a *App) authMiddleware(c *gin.Context) {
+	// automation authenticates with a personal access token instead of the cookies of a session
+	if bearer, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+		a.accessTokenAuth(c, bearer)
+		return
+	}
+
 	accessToken, err := c.Cookie(accessTokenKey)
 	if err != nil && !errors.Is(err, http.ErrNoCookie) {
 		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
@@ -33,7 +42,7 @@ func (a *App) authMiddleware(c *gin.Context) {
 	}
 
 	token := &domain.Token{Access: accessToken, Refresh: refreshToken}
-	user, token, err := a.srv.AuthenticateUser(c.Request.Context(), token)
+	user, sessionID, token, err := a.srv.AuthenticateUser(c.Request.Context(), token, device(c))
 	if err != nil {
 		log.Printf("db.AuthenticateUser: %v", err)
 		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "internal server error"})
@@ -46,6 +55,48 @@ func (a *App) authMiddleware(c *gin.Context) {
 	}
 
 	c.Set("user", user)
+	c.Set("session_id", sessionID)
+	c.Next()
+}
+
+// accessTokenAuth authenticates the request with the personal access token, the routes
+// then check its scopes with requireScope
+func (a *App) accessTokenAuth(c *gin.Context, token string) {
+	user, scopes, err := a.srv.AuthenticateAccessToken(c.Request.Context(), token)
+	if err != nil {
+		if errors.Is(err, domain.ErrTokenInvalid) || errors.Is(err, domain.ErrTokenExpired) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid access token"})
+			return
+		}
+		log.Printf("srv.AuthenticateAccessToken: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.Set("user", user)
+	c.Set("scopes", scopes)
+	c.Next()
+}
+
+// requireScope lets sessions through and personal access tokens granted the scope
+func (a *App) requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scopes, ok := c.Get("scopes")
+		if ok && !slices.Contains(scopes.([]string), scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "access token scope not granted: " + scope})
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireSession restricts the route to sessions, personal access tokens neither manage
+// the account nor connect to rooms
+func (a *App) requireSession(c *gin.Context) {
+	if _, ok := c.Get("scopes"); ok {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "not allowed with an access token"})
+		return
+	}
 	c.Next()
 }
 
@@ -87,3 +138,27 @@ func (a *App) setTokenCookie(c *gin.Context, token *domain.Token) {
 		true,
 	)
 }
+
+// setOAuthStateCookie binds the login started with the oauth provider to the browser,
+// an empty state deletes the cookie
+func (a *App) setOAuthStateCookie(c *gin.Context, state string) {
+	maxAge := int(a.cfg.OAuthStateTTL.Seconds())
+	if state == "" {
+		maxAge = -1
+	}
+
+	c.SetCookie(
+		oauthStateKey,
+		state,
+		maxAge,
+		"/api/oauth",
+		a.cfg.Domain,
+		true,
+		true,
+	)
+}
+
+// device returns the client of the request, the session it authenticates with is used from it
+func device(c *gin.Context) domain.Device {
+	return domain.Device{UserAgent: c.Request.UserAgent(), IP: c.ClientIP()}
+}
@@ -0,0 +1,55 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/gin-gonic/gin"
+)
+
+func (a *App) listIdentities(c *gin.Context) {
+	user := a.user(c)
+	identities, err := a.srv.ListIdentities(c.Request.Context(), user.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "temporary cannot list identities"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"identities": identities})
+}
+
+// linkIdentity starts a login with the provider, its callback links the provider to the user
+func (a *App) linkIdentity(c *gin.Context) {
+	user := a.user(c)
+	url, state, err := a.srv.LinkIdentity(c.Request.Context(), user.UserID, c.Param("provider"))
+	if err != nil {
+		if errors.Is(err, domain.ErrOAuthUnsupportedProvider) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported oauth provider"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "temporary cannot link identity"})
+		return
+	}
+
+	a.setOAuthStateCookie(c, state)
+	c.JSON(http.StatusOK, gin.H{"url": url})
+}
+
+func (a *App) unlinkIdentity(c *gin.Context) {
+	user := a.user(c)
+	err := a.srv.UnlinkIdentity(c.Request.Context(), user.UserID, c.Param("provider"))
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrDBIdentityNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "identity not found"})
+		case errors.Is(err, domain.ErrIdentityLastLogin):
+			c.JSON(http.StatusConflict, gin.H{"error": "the last identity cannot be unlinked"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "temporary cannot unlink identity"})
+		}
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,30 @@
+package app
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// internalMiddleware guards the api used by operators and monitoring,
+// it hides the api entirely when no internal token is configured
+func (a *App) internalMiddleware(c *gin.Context) {
+	if a.cfg.InternalToken == "" {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.cfg.InternalToken)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid internal token"})
+		return
+	}
+
+	c.Next()
+}
+
+func (a *App) roomStats(c *gin.Context) {
+	c.JSON(http.StatusOK, a.srv.GetRoomStats())
+}
@@ -0,0 +1,148 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"net/mail"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/gin-gonic/gin"
+)
+
+type localLoginBody struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type localUserBody struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Name     string `json:"name"` // the username when empty
+	Email    string `json:"email"`
+}
+
+type passwordBody struct {
+	CurrentPassword string `json:"current_password"`
+	Password        string `json:"password"`
+}
+
+func (a *App) localLogin(c *gin.Context) {
+	var body localLoginBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "corrupted request body"})
+		return
+	}
+
+	token, err := a.srv.LoginLocalUser(c.Request.Context(), body.Username, body.Password, device(c))
+	if err != nil {
+		a.localError(c, err, "temporary cannot login user")
+		return
+	}
+
+	a.setTokenCookie(c, token)
+}
+
+func (a *App) localRegister(c *gin.Context) {
+	user, body, ok := a.bindLocalUser(c)
+	if !ok {
+		return
+	}
+
+	token, err := a.srv.RegisterLocalUser(c.Request.Context(), body.Username, body.Password, user, device(c))
+	if err != nil {
+		a.localError(c, err, "temporary cannot register user")
+		return
+	}
+
+	a.setTokenCookie(c, token)
+}
+
+// changePassword replaces the password of the user, its other sessions are logged out
+func (a *App) changePassword(c *gin.Context) {
+	var body passwordBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "corrupted request body"})
+		return
+	}
+
+	user := a.user(c)
+	err := a.srv.ChangePassword(c.Request.Context(), user.UserID, a.sessionID(c), body.CurrentPassword, body.Password)
+	if err != nil {
+		if errors.Is(err, domain.ErrLocalInvalidCredentials) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "invalid current password"})
+			return
+		}
+		a.localError(c, err, "temporary cannot change password")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// createLocalUser creates a local user for an operator, when the users do not register themselves
+func (a *App) createLocalUser(c *gin.Context) {
+	user, body, ok := a.bindLocalUser(c)
+	if !ok {
+		return
+	}
+
+	userID, err := a.srv.CreateLocalUser(c.Request.Context(), body.Username, body.Password, user)
+	if err != nil {
+		a.localError(c, err, "temporary cannot create user")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"user_id": userID})
+}
+
+// resetPassword replaces the password of a local user for an operator, all its sessions are logged out
+func (a *App) resetPassword(c *gin.Context) {
+	var body passwordBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "corrupted request body"})
+		return
+	}
+
+	err := a.srv.ResetPassword(c.Request.Context(), c.Param("username"), body.Password)
+	if err != nil {
+		a.localError(c, err, "temporary cannot reset password")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (a *App) bindLocalUser(c *gin.Context) (*domain.User, *localUserBody, bool) {
+	var body localUserBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "corrupted request body"})
+		return nil, nil, false
+	}
+
+	addr, err := mail.ParseAddress(body.Email)
+	if err != nil || addr.Address != body.Email {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid email"})
+		return nil, nil, false
+	}
+
+	return &domain.User{Name: body.Name, Email: body.Email}, &body, true
+}
+
+func (a *App) localError(c *gin.Context, err error, msg string) {
+	switch {
+	case errors.Is(err, domain.ErrLocalInvalidCredentials):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid username or password"})
+	case errors.Is(err, domain.ErrLocalInvalidUsername):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username must have 3 to 32 letters, digits, dots, underscores or dashes"})
+	case errors.Is(err, domain.ErrLocalWeakPassword):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "password too short or too long"})
+	case errors.Is(err, domain.ErrLocalTooManyAttempts):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many attempts, try again later"})
+	case errors.Is(err, domain.ErrDBLocalUserExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "username or email already taken"})
+	case errors.Is(err, domain.ErrDBLocalUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "local user not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+	}
+}
@@ -0,0 +1,105 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type roomRetentionBody struct {
+	// Retention in seconds, 0 resets the room to the default retention
+	Retention int64 `json:"retention"`
+}
+
+func (a *App) setRoomRetention(c *gin.Context) {
+	roomID := c.Param("room_id")
+	if _, err := uuid.Parse(roomID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "corrupted room id"})
+		return
+	}
+
+	var body roomRetentionBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "corrupted request body"})
+		return
+	}
+
+	if body.Retention < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "negative retention"})
+		return
+	}
+
+	user := a.user(c)
+	err := a.srv.SetRoomRetention(c.Request.Context(), user.UserID, roomID, time.Duration(body.Retention)*time.Second)
+	if err != nil {
+		a.recordingError(c, err, "temporary cannot set room retention")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "room retention updated"})
+}
+
+func (a *App) listRecordings(c *gin.Context) {
+	user := a.user(c)
+	recs, err := a.srv.ListRecordings(c.Request.Context(), user.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "temporary cannot list recordings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recordings": recs})
+}
+
+func (a *App) getRecording(c *gin.Context) {
+	user := a.user(c)
+	rec, err := a.srv.GetRecording(c.Request.Context(), user.UserID, c.Param("recording_id"))
+	if err != nil {
+		a.recordingError(c, err, "temporary cannot get recording")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recording": rec})
+}
+
+// streamRecording serves the recording content, range requests are handled by http.ServeContent
+func (a *App) streamRecording(c *gin.Context) {
+	user := a.user(c)
+	rec, content, err := a.srv.OpenRecording(c.Request.Context(), user.UserID, c.Param("recording_id"))
+	if err != nil {
+		a.recordingError(c, err, "temporary cannot stream recording")
+		return
+	}
+	defer func() { _ = content.Close() }()
+
+	c.Header("Content-Type", rec.ContentType)
+	http.ServeContent(c.Writer, c.Request, rec.RecordingID, rec.CreatedAt, content)
+}
+
+func (a *App) deleteRecording(c *gin.Context) {
+	user := a.user(c)
+	err := a.srv.DeleteRecording(c.Request.Context(), user.UserID, c.Param("recording_id"))
+	if err != nil {
+		a.recordingError(c, err, "temporary cannot delete recording")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "recording deleted"})
+}
+
+func (a *App) recordingError(c *gin.Context, err error, msg string) {
+	switch {
+	case errors.Is(err, domain.ErrDBRecordingNotFound),
+		errors.Is(err, domain.ErrDBRoomNotFound),
+		errors.Is(err, domain.ErrStorageFileNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, domain.ErrRecordingAccessDenied),
+		errors.Is(err, domain.ErrRoomAccessDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+	}
+}
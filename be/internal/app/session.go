@@ -0,0 +1,64 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/gin-gonic/gin"
+)
+
+// sessionResponse is a session of the user, current marks the session of the request
+type sessionResponse struct {
+	domain.Session
+	Current bool `json:"current"`
+}
+
+func (a *App) listSessions(c *gin.Context) {
+	user := a.user(c)
+	sessions, err := a.srv.ListSessions(c.Request.Context(), user.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "temporary cannot list sessions"})
+		return
+	}
+
+	res := make([]sessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		res = append(res, sessionResponse{Session: session, Current: session.SessionID == a.sessionID(c)})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": res})
+}
+
+func (a *App) revokeSession(c *gin.Context) {
+	user := a.user(c)
+	sessionID := c.Param("session_id")
+
+	err := a.srv.RevokeSession(c.Request.Context(), user.UserID, sessionID)
+	if err != nil {
+		if errors.Is(err, domain.ErrDBSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "temporary cannot revoke session"})
+		return
+	}
+
+	// revoking the current session logs the user out
+	if sessionID == a.sessionID(c) {
+		a.setTokenCookie(c, nil)
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// revokeOtherSessions logs the user out everywhere but from the current session
+func (a *App) revokeOtherSessions(c *gin.Context) {
+	user := a.user(c)
+	if err := a.srv.RevokeOtherSessions(c.Request.Context(), user.UserID, a.sessionID(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "temporary cannot revoke sessions"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,102 @@
+package app
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/escalopa/vego/internal/sse"
+	"github.com/gin-gonic/gin"
+)
+
+const maxStreamMessageSize = 1024 * 1024 // 1MB, same as the websocket read buffer
+
+// sseStream is the signaling fallback for clients behind proxies blocking websocket upgrades,
+// the room events are streamed to the client which posts its own events to the stream
+func (a *App) sseStream(c *gin.Context) {
+	user, roomID, client, ok := a.authenticateRoom(c, true)
+	if !ok {
+		return
+	}
+
+	if client.ProtocolVersion() < a.cfg.MinProtocolVersion {
+		c.JSON(http.StatusUpgradeRequired, gin.H{"error": "upgrade-required"})
+		return
+	}
+
+	conn, err := a.sse.Open(c.Request.Context(), c.Writer, user.UserID, roomID)
+	if err != nil {
+		return // the stream already started, the client sees it ending
+	}
+	// the room must not write to the stream once the handler returns
+	defer func() { _ = conn.Close() }()
+
+	a.srv.HandleConn(user, roomID, client, conn)
+}
+
+func (a *App) ssePost(c *gin.Context) {
+	conn, ok := a.getStream(c)
+	if !ok {
+		return
+	}
+
+	message, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxStreamMessageSize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "message too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot read message"})
+		return
+	}
+
+	if err := conn.Post(c.Request.Context(), message); err != nil {
+		a.streamError(c, err, "temporary cannot post message")
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+// sseLeave leaves the room, dropping the stream instead lets the client resume
+func (a *App) sseLeave(c *gin.Context) {
+	conn, ok := a.getStream(c)
+	if !ok {
+		return
+	}
+
+	conn.Leave()
+
+	c.Status(http.StatusNoContent)
+}
+
+func (a *App) getStream(c *gin.Context) (*sse.Conn, bool) {
+	// the stream was admitted when opened, posts and leaves must not count against the room limits
+	user, roomID, _, ok := a.authenticateRoom(c, false)
+	if !ok {
+		return nil, false
+	}
+
+	conn, err := a.sse.Get(c.Param("stream_id"), user.UserID, roomID)
+	if err != nil {
+		a.streamError(c, err, "temporary cannot get stream")
+		return nil, false
+	}
+
+	return conn, true
+}
+
+func (a *App) streamError(c *gin.Context, err error, msg string) {
+	switch {
+	case errors.Is(err, domain.ErrStreamNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, domain.ErrStreamClosed):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	case errors.Is(err, domain.ErrStreamMessageTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+	}
+}
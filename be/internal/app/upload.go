@@ -0,0 +1,147 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// resumable upload protocol (tus-like)
+//
+//	POST   /api/recording/upload            create an upload, returns its id and location
+//	HEAD   /api/recording/upload/:upload_id return the received offset in Upload-Offset
+//	PATCH  /api/recording/upload/:upload_id append a chunk at Upload-Offset
+//	DELETE /api/recording/upload/:upload_id abort the upload
+//
+// once the last byte is received the upload becomes a recording with the same id
+
+const (
+	uploadOffsetHeader = "Upload-Offset"
+	uploadLengthHeader = "Upload-Length"
+
+	uploadChunkContentType = "application/offset+octet-stream"
+)
+
+type createUploadBody struct {
+	RoomID      string `json:"room_id"`
+	ContentType string `json:"content_type"`
+	Length      int64  `json:"length"`
+}
+
+func (a *App) createUpload(c *gin.Context) {
+	var body createUploadBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "corrupted request body"})
+		return
+	}
+
+	if _, err := uuid.Parse(body.RoomID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "corrupted room id"})
+		return
+	}
+
+	if body.ContentType == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "empty content type"})
+		return
+	}
+
+	user := a.user(c)
+	upload, err := a.srv.CreateUpload(c.Request.Context(), user.UserID, body.RoomID, body.ContentType, body.Length)
+	if err != nil {
+		a.uploadError(c, err, "temporary cannot create upload")
+		return
+	}
+
+	c.Header("Location", "/api/recording/upload/"+upload.UploadID)
+	c.JSON(http.StatusCreated, gin.H{"upload": upload})
+}
+
+func (a *App) listUploads(c *gin.Context) {
+	user := a.user(c)
+	uploads, err := a.srv.ListUploads(c.Request.Context(), user.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "temporary cannot list uploads"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"uploads": uploads})
+}
+
+func (a *App) uploadStatus(c *gin.Context) {
+	user := a.user(c)
+	upload, err := a.srv.GetUpload(c.Request.Context(), user.UserID, c.Param("upload_id"))
+	if err != nil {
+		a.uploadError(c, err, "temporary cannot get upload")
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	setUploadHeaders(c, upload)
+	c.Status(http.StatusOK)
+}
+
+func (a *App) writeUpload(c *gin.Context) {
+	if c.ContentType() != uploadChunkContentType {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "unexpected content type"})
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader(uploadOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "corrupted upload offset"})
+		return
+	}
+
+	user := a.user(c)
+	upload, rec, err := a.srv.WriteUpload(c.Request.Context(), user.UserID, c.Param("upload_id"), offset, c.Request.Body, c.Request.ContentLength)
+	if err != nil {
+		if upload != nil {
+			setUploadHeaders(c, upload) // let the client know where to resume from
+		}
+		a.uploadError(c, err, "temporary cannot write upload")
+		return
+	}
+
+	setUploadHeaders(c, upload)
+	if rec != nil {
+		c.Header("Location", "/api/recording/"+rec.RecordingID)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (a *App) deleteUpload(c *gin.Context) {
+	user := a.user(c)
+	err := a.srv.DeleteUpload(c.Request.Context(), user.UserID, c.Param("upload_id"))
+	if err != nil {
+		a.uploadError(c, err, "temporary cannot delete upload")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func setUploadHeaders(c *gin.Context, upload *domain.Upload) {
+	c.Header(uploadOffsetHeader, strconv.FormatInt(upload.Offset, 10))
+	c.Header(uploadLengthHeader, strconv.FormatInt(upload.Length, 10))
+}
+
+func (a *App) uploadError(c *gin.Context, err error, msg string) {
+	switch {
+	case errors.Is(err, domain.ErrDBUploadNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, domain.ErrUploadAccessDenied),
+		errors.Is(err, domain.ErrRoomAccessDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, domain.ErrUploadInvalidLength),
+		errors.Is(err, domain.ErrUploadChunkTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+	case errors.Is(err, domain.ErrUploadOffsetMismatch):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+	}
+}
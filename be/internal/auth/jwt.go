@@ -2,7 +2,6 @@ package auth
 
 import (
 	"errors"
-	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
@@ -15,45 +14,52 @@ var getCurrentTime = time.Now
 
 type (
 	RoomProvider struct {
+		keys      *KeySet
 		secretKey []byte
 		tokenTTL  time.Duration
 	}
 
 	roomClaims struct {
-		UserID int64  `json:"user_id"`
-		RoomID string `json:"room_id"`
+		UserID    int64  `json:"user_id"`
+		RoomID    string `json:"room_id"`
+		SessionID string `json:"sid"`
 		jwt.RegisteredClaims
 	}
 )
 
-func NewRoomProvider(cfg config.JWTRoom) *RoomProvider {
+// audiences of the tokens signed with the key set, a token of one provider is not valid for the other
+const (
+	audienceRoom = "vego-room"
+	audienceUser = "vego-user"
+)
+
+func NewRoomProvider(cfg config.JWTRoom, keys *KeySet) *RoomProvider {
 	return &RoomProvider{
+		keys:      keys,
 		secretKey: []byte(cfg.SecretKey),
 		tokenTTL:  cfg.TokenTTL,
 	}
 }
 
-func (rp *RoomProvider) CreateToken(userID int64, roomID string) (string, error) {
+// CreateToken creates the token of a room connection opened from the session of the user
+func (rp *RoomProvider) CreateToken(userID int64, roomID string, sessionID string) (string, error) {
 	now := time.Now()
 	claims := roomClaims{
-		UserID: userID,
-		RoomID: roomID,
+		UserID:    userID,
+		RoomID:    roomID,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    rp.keys.issuer,
+			Audience:  jwt.ClaimStrings{audienceRoom},
 			ExpiresAt: jwt.NewNumericDate(now.Add(rp.tokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(rp.secretKey)
+	return rp.keys.sign(claims, rp.secretKey)
 }
 
 func (rp *RoomProvider) VerifyToken(tokenStr string) (*domain.RoomTokenPayload, error) {
-	token, err := jwt.ParseWithClaims(tokenStr, &roomClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return rp.secretKey, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenStr, &roomClaims{}, rp.keys.keyFunc(rp.secretKey))
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
 			return nil, domain.ErrTokenExpired
@@ -62,47 +68,72 @@ func (rp *RoomProvider) VerifyToken(tokenStr string) (*domain.RoomTokenPayload,
 	}
 
 	claims, ok := token.Claims.(*roomClaims)
-	if !ok || !token.Valid {
+	if !ok || !token.Valid || !rp.keys.verifyClaims(token, &claims.RegisteredClaims, audienceRoom) {
 		return nil, domain.ErrTokenInvalid
 	}
 
 	payload := &domain.RoomTokenPayload{
-		UserID: claims.UserID,
-		RoomID: claims.RoomID,
+		UserID:    claims.UserID,
+		RoomID:    claims.RoomID,
+		SessionID: claims.SessionID,
 	}
 	return payload, nil
 }
 
 type (
 	UserProvider struct {
+		keys            *KeySet
 		secretKey       []byte
 		accessTokenTTL  time.Duration
 		refreshTokenTTL time.Duration
 	}
 
 	userClaims struct {
-		UserID int64  `json:"user_id"`
-		Email  string `json:"email"`
+		UserID    int64     `json:"user_id"`
+		Email     string    `json:"email"`
+		SessionID string    `json:"sid"`
+		TokenType tokenType `json:"typ"`
 		jwt.RegisteredClaims
 	}
+
+	// tokenType prevents using a refresh token as an access token and vice versa
+	tokenType string
 )
 
-func NewUserProvider(cfg config.JWTUser) *UserProvider {
+const (
+	tokenTypeAccess  tokenType = "access"
+	tokenTypeRefresh tokenType = "refresh"
+)
+
+func NewUserProvider(cfg config.JWTUser, keys *KeySet) *UserProvider {
 	return &UserProvider{
+		keys:            keys,
 		secretKey:       []byte(cfg.SecretKey),
 		accessTokenTTL:  cfg.AccessTokenTTL,
 		refreshTokenTTL: cfg.RefreshTokenTTL,
 	}
 }
 
-func (up *UserProvider) CreateToken(userID int64, email string) (*domain.Token, error) {
+// CreateToken creates the tokens of the session, tokenID is the jti of the refresh token
+func (up *UserProvider) CreateToken(userID int64, email string, sessionID string, tokenID string) (*domain.Token, error) {
 	now := time.Now()
-	accessToken, err := up.createToken(userID, email, up.accessTokenTTL, now)
+	accessToken, err := up.createToken(userClaims{
+		UserID:    userID,
+		Email:     email,
+		SessionID: sessionID,
+		TokenType: tokenTypeAccess,
+	}, up.accessTokenTTL, now)
 	if err != nil {
 		return nil, err
 	}
 
-	refreshToken, err := up.createToken(userID, email, up.refreshTokenTTL, now)
+	refreshToken, err := up.createToken(userClaims{
+		UserID:           userID,
+		Email:            email,
+		SessionID:        sessionID,
+		TokenType:        tokenTypeRefresh,
+		RegisteredClaims: jwt.RegisteredClaims{ID: tokenID},
+	}, up.refreshTokenTTL, now)
 	if err != nil {
 		return nil, err
 	}
@@ -115,30 +146,35 @@ func (up *UserProvider) CreateToken(userID int64, email string) (*domain.Token,
 	return token, nil
 }
 
-func (up *UserProvider) createToken(userID int64, email string, ttl time.Duration, now time.Time) (string, error) {
-	claims := userClaims{
-		UserID: userID,
-		Email:  email,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
-			IssuedAt:  jwt.NewNumericDate(now),
-		},
-	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(up.secretKey)
+func (up *UserProvider) createToken(claims userClaims, ttl time.Duration, now time.Time) (string, error) {
+	claims.Issuer = up.keys.issuer
+	claims.Audience = jwt.ClaimStrings{audienceUser}
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	return up.keys.sign(claims, up.secretKey)
+}
+
+// PublicKeys returns the public keys verifying the user and room tokens
+func (up *UserProvider) PublicKeys() []domain.JWK {
+	return up.keys.PublicKeys()
 }
 
+// VerifyToken verifies an access token
 func (up *UserProvider) VerifyToken(tokenStr string) (*domain.UserTokenPayload, error) {
+	return up.verifyToken(tokenStr, tokenTypeAccess)
+}
+
+// VerifyRefreshToken verifies a refresh token
+func (up *UserProvider) VerifyRefreshToken(tokenStr string) (*domain.UserTokenPayload, error) {
+	return up.verifyToken(tokenStr, tokenTypeRefresh)
+}
+
+func (up *UserProvider) verifyToken(tokenStr string, typ tokenType) (*domain.UserTokenPayload, error) {
 	if tokenStr == "" {
 		return nil, domain.ErrTokenExpired // treat empty token as expired
 	}
 
-	token, err := jwt.ParseWithClaims(tokenStr, &userClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return up.secretKey, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenStr, &userClaims{}, up.keys.keyFunc(up.secretKey))
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
 			return nil, domain.ErrTokenExpired
@@ -146,14 +182,18 @@ func (up *UserProvider) VerifyToken(tokenStr string) (*domain.UserTokenPayload,
 		return nil, domain.ErrTokenInvalid
 	}
 
+	// tokens issued before sessions have neither a type nor a session
 	claims, ok := token.Claims.(*userClaims)
-	if !ok || !token.Valid {
+	if !ok || !token.Valid || claims.TokenType != typ || claims.SessionID == "" ||
+		!up.keys.verifyClaims(token, &claims.RegisteredClaims, audienceUser) {
 		return nil, domain.ErrTokenInvalid
 	}
 
 	payload := &domain.UserTokenPayload{
-		UserID: claims.UserID,
-		Email:  claims.Email,
+		UserID:    claims.UserID,
+		Email:     claims.Email,
+		SessionID: claims.SessionID,
+		TokenID:   claims.ID,
 	}
 	return payload, nil
 }
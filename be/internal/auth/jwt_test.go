@@ -14,6 +14,9 @@ const (
 	testUserID = int64(1)
 	testEmail  = "test@example.com"
 	testRoomID = "room1"
+
+	testSessionID = "session1"
+	testTokenID   = "token1"
 )
 
 var (
@@ -31,7 +34,7 @@ func TestRoomProvider(t *testing.T) {
 		SecretKey: "test-secret",
 		TokenTTL:  time.Minute,
 	}
-	p := NewRoomProvider(cfg)
+	p := NewRoomProvider(cfg, &KeySet{})
 
 	tests := []struct {
 		name      string
@@ -67,7 +70,7 @@ func TestRoomProvider(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 
-			token, err := p.CreateToken(tt.userID, tt.roomID)
+			token, err := p.CreateToken(tt.userID, tt.roomID, testSessionID)
 			require.NoError(t, err)
 
 			token = tt.modify(token)
@@ -78,6 +81,7 @@ func TestRoomProvider(t *testing.T) {
 				require.NotNil(t, payload)
 				require.Equal(t, tt.userID, payload.UserID)
 				require.Equal(t, tt.roomID, payload.RoomID)
+				require.Equal(t, testSessionID, payload.SessionID)
 			} else {
 				require.ErrorIs(t, err, tt.expectErr)
 				require.Nil(t, payload)
@@ -94,34 +98,41 @@ func TestAuthProvider(t *testing.T) {
 		AccessTokenTTL:  time.Minute,
 		RefreshTokenTTL: time.Hour,
 	}
-	p := NewUserProvider(cfg)
+	p := NewUserProvider(cfg, &KeySet{})
 
 	tests := []struct {
 		name      string
 		userID    int64
 		email     string
-		modify    func(token string) string
+		modify    func(token *domain.Token) string
 		expectErr error
 	}{
 		{
 			name:      "valid_access_token",
 			userID:    testUserID,
 			email:     testEmail,
-			modify:    func(token string) string { return token },
+			modify:    func(token *domain.Token) string { return token.Access },
 			expectErr: nil,
 		},
 		{
 			name:      "expired_access_token",
 			userID:    testUserID,
 			email:     testEmail,
-			modify:    func(_ string) string { return string(expiredToken) },
+			modify:    func(_ *domain.Token) string { return string(expiredToken) },
 			expectErr: domain.ErrTokenExpired,
 		},
 		{
 			name:      "invalid_access_token",
 			userID:    testUserID,
 			email:     testEmail,
-			modify:    func(token string) string { return token + "invalid" },
+			modify:    func(token *domain.Token) string { return token.Access + "invalid" },
+			expectErr: domain.ErrTokenInvalid,
+		},
+		{
+			name:      "refresh_token_as_access_token",
+			userID:    testUserID,
+			email:     testEmail,
+			modify:    func(token *domain.Token) string { return token.Refresh },
 			expectErr: domain.ErrTokenInvalid,
 		},
 	}
@@ -130,17 +141,18 @@ func TestAuthProvider(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 
-			token, err := p.CreateToken(tt.userID, tt.email)
+			token, err := p.CreateToken(tt.userID, tt.email, testSessionID, testTokenID)
 			require.NoError(t, err)
 
-			token.Access = tt.modify(token.Access)
-			payload, err := p.VerifyToken(token.Access)
+			payload, err := p.VerifyToken(tt.modify(token))
 
 			if tt.expectErr == nil {
 				require.NoError(t, err)
 				require.NotNil(t, payload)
 				require.Equal(t, tt.userID, payload.UserID)
 				require.Equal(t, tt.email, payload.Email)
+				require.Equal(t, testSessionID, payload.SessionID)
+				require.Empty(t, payload.TokenID)
 			} else {
 				require.ErrorIs(t, err, tt.expectErr)
 				require.Nil(t, payload)
@@ -148,3 +160,32 @@ func TestAuthProvider(t *testing.T) {
 		})
 	}
 }
+
+func TestAuthProvider_RefreshToken(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.JWTUser{
+		SecretKey:       "test-secret",
+		AccessTokenTTL:  time.Minute,
+		RefreshTokenTTL: time.Hour,
+	}
+	p := NewUserProvider(cfg, &KeySet{})
+
+	token, err := p.CreateToken(testUserID, testEmail, testSessionID, testTokenID)
+	require.NoError(t, err)
+
+	payload, err := p.VerifyRefreshToken(token.Refresh)
+	require.NoError(t, err)
+	require.Equal(t, &domain.UserTokenPayload{
+		UserID:    testUserID,
+		Email:     testEmail,
+		SessionID: testSessionID,
+		TokenID:   testTokenID,
+	}, payload)
+
+	_, err = p.VerifyRefreshToken(token.Access)
+	require.ErrorIs(t, err, domain.ErrTokenInvalid)
+
+	_, err = p.VerifyRefreshToken(token.Refresh + "invalid")
+	require.ErrorIs(t, err, domain.ErrTokenInvalid)
+}
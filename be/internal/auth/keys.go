@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"cmp"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/escalopa/vego/internal/config"
+	"github.com/escalopa/vego/internal/domain"
+)
+
+type (
+	// KeySet holds the keys signing the tokens of the user and room providers,
+	// the first key signs and the others only verify tokens signed before a rotation
+	KeySet struct {
+		issuer  string
+		signing *signingKey
+		keys    map[string]*signingKey // by kid
+		order   []string
+	}
+
+	signingKey struct {
+		id      string
+		method  jwt.SigningMethod
+		private crypto.Signer
+	}
+)
+
+// defaultIssuer is the iss of the tokens when no issuer is set
+const defaultIssuer = "vego"
+
+// NewKeySet loads the keys, no keys leave the providers signing with their HS256 secret keys,
+// the tokens of both providers are issued by issuer
+func NewKeySet(cfg []config.JWTKey, issuer string) (*KeySet, error) {
+	ks := &KeySet{issuer: cmp.Or(issuer, defaultIssuer), keys: make(map[string]*signingKey, len(cfg))}
+	for _, keyCfg := range cfg {
+		if keyCfg.ID == "" {
+			return nil, errors.New("jwt key without id")
+		}
+		if _, ok := ks.keys[keyCfg.ID]; ok {
+			return nil, fmt.Errorf("duplicate jwt key %s", keyCfg.ID)
+		}
+
+		data, err := os.ReadFile(keyCfg.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read jwt key %s: %w", keyCfg.ID, err)
+		}
+		key, err := parseSigningKey(keyCfg.ID, data)
+		if err != nil {
+			return nil, fmt.Errorf("parse jwt key %s: %w", keyCfg.ID, err)
+		}
+
+		if ks.signing == nil {
+			ks.signing = key
+		}
+		ks.keys[key.id] = key
+		ks.order = append(ks.order, key.id)
+	}
+	return ks, nil
+}
+
+// parseSigningKey parses a PEM encoded PKCS#8 or PKCS#1 key, the signing method follows the key type
+func parseSigningKey(id string, data []byte) (*signingKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.New("not a PKCS#8 or PKCS#1 private key")
+		}
+	}
+
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		return &signingKey{id: id, method: jwt.SigningMethodRS256, private: private}, nil
+	case ed25519.PrivateKey:
+		return &signingKey{id: id, method: jwt.SigningMethodEdDSA, private: private}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", private)
+	}
+}
+
+// sign signs the claims with the signing key, or with secret while there are no keys
+func (ks *KeySet) sign(claims jwt.Claims, secret []byte) (string, error) {
+	if ks.signing == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	}
+
+	token := jwt.NewWithClaims(ks.signing.method, claims)
+	token.Header["kid"] = ks.signing.id
+	return token.SignedString(ks.signing.private)
+}
+
+// keyFunc returns the key verifying a token, tokens without kid are the HS256 ones signed
+// with secret and are accepted while it is set
+func (ks *KeySet) keyFunc(secret []byte) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok || len(secret) == 0 {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return secret, nil
+		}
+
+		key, ok := ks.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key: %s", kid)
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.private.Public(), nil
+	}
+}
+
+// verifyClaims checks the issuer of the tokens, tokens issued before the issuer was set have none.
+// The audience of the tokens signed with the keys is checked as well as the keys are shared
+// by the providers, HS256 tokens have a secret per provider and no audience
+func (ks *KeySet) verifyClaims(token *jwt.Token, claims *jwt.RegisteredClaims, audience string) bool {
+	if !claims.VerifyIssuer(ks.issuer, false) {
+		return false
+	}
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		return true
+	}
+	return claims.VerifyAudience(audience, true)
+}
+
+// PublicKeys returns the public keys verifying the tokens, in the order of the config
+func (ks *KeySet) PublicKeys() []domain.JWK {
+	keys := make([]domain.JWK, 0, len(ks.order))
+	for _, id := range ks.order {
+		key := ks.keys[id]
+		jwk := domain.JWK{KeyID: key.id, Use: "sig", Algorithm: key.method.Alg()}
+		switch public := key.private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		keys = append(keys, jwk)
+	}
+	return keys
+}
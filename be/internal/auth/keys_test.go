@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/escalopa/vego/internal/config"
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/stretchr/testify/require"
+)
+
+// writeKey writes a PEM encoded PKCS#8 key of the kind, rsa or ed25519, and returns its config
+func writeKey(t *testing.T, id string, kind string) config.JWTKey {
+	t.Helper()
+
+	var private any
+	switch kind {
+	case "rsa":
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		private = key
+	case "ed25519":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		private = key
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	require.NoError(t, err)
+
+	file := filepath.Join(t.TempDir(), id+".pem")
+	require.NoError(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+	return config.JWTKey{ID: id, PrivateKeyFile: file}
+}
+
+func TestKeySet(t *testing.T) {
+	t.Parallel()
+
+	rsaKey := writeKey(t, "rsa1", "rsa")
+	edKey := writeKey(t, "ed1", "ed25519")
+
+	userCfg := config.JWTUser{SecretKey: "user-secret", AccessTokenTTL: time.Minute, RefreshTokenTTL: time.Hour}
+	roomCfg := config.JWTRoom{SecretKey: "room-secret", TokenTTL: time.Minute}
+
+	tests := []struct {
+		name       string
+		signKeys   []config.JWTKey
+		verifyKeys []config.JWTKey
+		secret     string // secret key of the verifying provider
+		wantErr    error
+	}{
+		{"rs256", []config.JWTKey{rsaKey}, []config.JWTKey{rsaKey}, userCfg.SecretKey, nil},
+		{"eddsa", []config.JWTKey{edKey}, []config.JWTKey{edKey}, userCfg.SecretKey, nil},
+		{"rotated_key", []config.JWTKey{rsaKey}, []config.JWTKey{edKey, rsaKey}, userCfg.SecretKey, nil},
+		{"retired_key", []config.JWTKey{rsaKey}, []config.JWTKey{edKey}, userCfg.SecretKey, domain.ErrTokenInvalid},
+		{"secret_before_keys", nil, []config.JWTKey{rsaKey}, userCfg.SecretKey, nil},
+		{"secret_removed", nil, []config.JWTKey{rsaKey}, "", domain.ErrTokenInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			signKeys, err := NewKeySet(tt.signKeys, "")
+			require.NoError(t, err)
+			verifyKeys, err := NewKeySet(tt.verifyKeys, "")
+			require.NoError(t, err)
+
+			token, err := NewUserProvider(userCfg, signKeys).CreateToken(testUserID, testEmail, testSessionID, testTokenID)
+			require.NoError(t, err)
+
+			verifyCfg := userCfg
+			verifyCfg.SecretKey = tt.secret
+			payload, err := NewUserProvider(verifyCfg, verifyKeys).VerifyToken(token.Access)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, testSessionID, payload.SessionID)
+		})
+	}
+
+	t.Run("other_audience", func(t *testing.T) {
+		t.Parallel()
+
+		keys, err := NewKeySet([]config.JWTKey{edKey}, "")
+		require.NoError(t, err)
+
+		roomToken, err := NewRoomProvider(roomCfg, keys).CreateToken(testUserID, testRoomID, testSessionID)
+		require.NoError(t, err)
+		userToken, err := NewUserProvider(userCfg, keys).CreateToken(testUserID, testEmail, testSessionID, testTokenID)
+		require.NoError(t, err)
+
+		_, err = NewRoomProvider(roomCfg, keys).VerifyToken(userToken.Access)
+		require.ErrorIs(t, err, domain.ErrTokenInvalid)
+		_, err = NewUserProvider(userCfg, keys).VerifyToken(roomToken)
+		require.ErrorIs(t, err, domain.ErrTokenInvalid)
+	})
+
+	t.Run("issuer", func(t *testing.T) {
+		t.Parallel()
+
+		keys, err := NewKeySet([]config.JWTKey{edKey}, "https://vego.example.com")
+		require.NoError(t, err)
+		otherKeys, err := NewKeySet([]config.JWTKey{edKey}, "https://other.example.com")
+		require.NoError(t, err)
+
+		token, err := NewUserProvider(userCfg, keys).CreateToken(testUserID, testEmail, testSessionID, testTokenID)
+		require.NoError(t, err)
+		_, err = NewUserProvider(userCfg, keys).VerifyToken(token.Access)
+		require.NoError(t, err)
+		_, err = NewUserProvider(userCfg, otherKeys).VerifyToken(token.Access)
+		require.ErrorIs(t, err, domain.ErrTokenInvalid)
+
+		// tokens issued before the issuer was set have none
+		legacy, err := NewUserProvider(userCfg, &KeySet{}).CreateToken(testUserID, testEmail, testSessionID, testTokenID)
+		require.NoError(t, err)
+		_, err = NewUserProvider(userCfg, keys).VerifyToken(legacy.Access)
+		require.NoError(t, err)
+	})
+}
+
+func TestNewKeySet(t *testing.T) {
+	t.Parallel()
+
+	notKey := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(notKey, []byte("not a key"), 0o600))
+	rsaKey := writeKey(t, "rsa1", "rsa")
+
+	tests := []struct {
+		name string
+		keys []config.JWTKey
+	}{
+		{"missing_id", []config.JWTKey{{PrivateKeyFile: rsaKey.PrivateKeyFile}}},
+		{"duplicate_id", []config.JWTKey{rsaKey, rsaKey}},
+		{"missing_file", []config.JWTKey{{ID: "key1", PrivateKeyFile: filepath.Join(t.TempDir(), "missing.pem")}}},
+		{"not_pem", []config.JWTKey{{ID: "key1", PrivateKeyFile: notKey}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewKeySet(tt.keys, "")
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestKeySet_PublicKeys(t *testing.T) {
+	t.Parallel()
+
+	rsaKey := writeKey(t, "rsa1", "rsa")
+	edKey := writeKey(t, "ed1", "ed25519")
+
+	keys, err := NewKeySet([]config.JWTKey{edKey, rsaKey}, "")
+	require.NoError(t, err)
+
+	jwks := keys.PublicKeys()
+	require.Len(t, jwks, 2)
+
+	require.Equal(t, domain.JWK{KeyType: "OKP", KeyID: "ed1", Use: "sig", Algorithm: "EdDSA", Curve: "Ed25519", X: jwks[0].X}, jwks[0])
+	x, err := base64.RawURLEncoding.DecodeString(jwks[0].X)
+	require.NoError(t, err)
+	require.Equal(t, keys.keys["ed1"].private.Public(), ed25519.PublicKey(x))
+
+	require.Equal(t, "RSA", jwks[1].KeyType)
+	require.Equal(t, "rsa1", jwks[1].KeyID)
+	require.Equal(t, "RS256", jwks[1].Algorithm)
+	n, err := base64.RawURLEncoding.DecodeString(jwks[1].N)
+	require.NoError(t, err)
+	e, err := base64.RawURLEncoding.DecodeString(jwks[1].E)
+	require.NoError(t, err)
+	public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	require.True(t, public.Equal(keys.keys["rsa1"].private.Public()))
+}
@@ -1,112 +1,181 @@
 package auth
 
 import (
+	"cmp"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"slices"
+	"strings"
 
 	"github.com/escalopa/vego/internal/config"
 	"github.com/escalopa/vego/internal/domain"
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/github"
-	"golang.org/x/oauth2/google"
-	"golang.org/x/oauth2/yandex"
 )
 
 type OAuthProvider struct {
 	providers map[string]*provider
 }
 
-func NewOAuthProvider(cfg config.OAuthConfig) *OAuthProvider {
+// NewOAuthProvider creates the enabled providers of the config, the endpoints of the OpenID
+// Connect providers are discovered from their issuers, unreachable issuers are skipped
+func NewOAuthProvider(ctx context.Context, cfg config.OAuthConfig) (*OAuthProvider, error) {
 	op := &OAuthProvider{providers: make(map[string]*provider)}
 
-	op.providers[googleProvider] = &provider{
-		config: &oauth2.Config{
-			ClientID:     cfg.Google.ClientID,
-			ClientSecret: cfg.Google.ClientSecret,
-			RedirectURL:  cfg.Google.RedirectURL,
-			Scopes:       []string{"email", "profile"},
-			Endpoint:     google.Endpoint,
-		},
-		endpoint: cfg.Google.UserEndpoint,
-		payload:  func() payload { return &googlePayload{} },
+	for name, providerCfg := range cfg.Providers {
+		if providerCfg.Disabled || providerCfg.ClientID == "" {
+			continue
+		}
+		if name == domain.LocalProvider {
+			return nil, fmt.Errorf("oauth provider %s: name reserved for the local users", name)
+		}
+
+		p, err := newProvider(ctx, providerCfg)
+		if errors.Is(err, errOIDCDiscovery) {
+			log.Printf("oauth provider %s: %v, provider skipped", name, err)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("oauth provider %s: %w", name, err)
+		}
+
+		p.info = domain.OAuthProvider{
+			Name:        name,
+			Kind:        providerCfg.Kind,
+			DisplayName: cmp.Or(providerCfg.DisplayName, p.info.DisplayName, name),
+		}
+		op.providers[name] = p
 	}
 
-	op.providers[githubProvider] = &provider{
-		config: &oauth2.Config{
-			ClientID:     cfg.GitHub.ClientID,
-			ClientSecret: cfg.GitHub.ClientSecret,
-			RedirectURL:  cfg.GitHub.RedirectURL,
-			Scopes:       []string{"user:email"},
-			Endpoint:     github.Endpoint,
-		},
-		endpoint: cfg.GitHub.UserEndpoint,
-		payload:  func() payload { return &githubPayload{} },
-	}
+	return op, nil
+}
 
-	op.providers[yandexProvider] = &provider{
-		config: &oauth2.Config{
-			ClientID:     cfg.Yandex.ClientID,
-			ClientSecret: cfg.Yandex.ClientSecret,
-			RedirectURL:  cfg.Yandex.RedirectURL,
-			Scopes:       []string{"login:info", "login:email", "login:avatar"},
-			Endpoint:     yandex.Endpoint,
-		},
-		endpoint: cfg.Yandex.UserEndpoint,
-		payload:  func() payload { return &yandexPayload{} },
+// Providers returns the enabled providers ordered by name
+func (op *OAuthProvider) Providers() []domain.OAuthProvider {
+	providers := make([]domain.OAuthProvider, 0, len(op.providers))
+	for _, p := range op.providers {
+		providers = append(providers, p.info)
 	}
 
-	return op
+	slices.SortFunc(providers, func(a, b domain.OAuthProvider) int { return strings.Compare(a.Name, b.Name) })
+	return providers
 }
 
-func (op *OAuthProvider) GetRedirectURL(provider string) (string, error) {
+// GetRedirectURL starts a login with the provider, the returned state holds the random
+// state and pkce verifier the callback of the login is checked against
+func (op *OAuthProvider) GetRedirectURL(provider string) (string, *domain.OAuthState, error) {
 	p, exists := op.providers[provider]
 	if !exists {
-		return "", domain.ErrOAuthUnsupportedProvider
+		return "", nil, domain.ErrOAuthUnsupportedProvider
+	}
+
+	state := &domain.OAuthState{
+		State:    randomString(),
+		Provider: provider,
+		Verifier: randomString(),
 	}
 
-	return p.config.AuthCodeURL(provider), nil
+	url := p.config.AuthCodeURL(state.State,
+		oauth2.SetAuthURLParam("code_challenge", codeChallenge(state.Verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	)
+
+	return url, state, nil
 }
 
-func (op *OAuthProvider) HandleCallback(ctx context.Context, provider string, code string) (*domain.User, error) {
+// HandleCallback exchanges the code with the pkce verifier of its login and returns the user
+// and its identity at the provider
+func (op *OAuthProvider) HandleCallback(ctx context.Context, provider string, code string, verifier string) (*domain.User, *domain.Identity, error) {
 	p, exists := op.providers[provider]
 	if !exists {
-		return nil, domain.ErrOAuthUnsupportedProvider
+		return nil, nil, domain.ErrOAuthUnsupportedProvider
 	}
 
 	// get unique token for user's data retrieval
-	token, err := p.config.Exchange(ctx, code)
+	token, err := p.config.Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", verifier))
 	if err != nil {
 		log.Printf("oauthConfig.Exchange err: %v", err)
-		return nil, domain.ErrOAuthExchange
+		return nil, nil, domain.ErrOAuthExchange
+	}
+
+	fetch := p.fetchUser
+	if p.idToken != nil {
+		fetch = p.verifyIDToken
 	}
 
-	// fetch user's data
+	user, identity, err := fetch(ctx, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	identity.Provider = provider
+	return user, identity, nil
+}
+
+// fetchUser gets the user from the user info endpoint of the provider, users without
+// an email are rejected and so are users without a verified email of the trusted providers
+func (p *provider) fetchUser(ctx context.Context, token *oauth2.Token) (*domain.User, *domain.Identity, error) {
 	client := p.config.Client(ctx, token)
-	resp, err := client.Get(p.endpoint)
+
+	dst := p.payload()
+	if err := getJSON(client, p.endpoint, dst); err != nil {
+		return nil, nil, err
+	}
+
+	if f, ok := dst.(emailFetcher); ok {
+		if err := f.fetchEmail(client, p.endpoint); err != nil {
+			return nil, nil, err
+		}
+	}
+	if r, ok := dst.(idTokenReader); ok {
+		r.readIDToken(token)
+	}
+
+	user := dst.ToUser()
+	if user.Email == "" || p.verifiesEmail && !dst.EmailVerified() {
+		return nil, nil, domain.ErrOAuthEmailNotVerified
+	}
+
+	verified := dst.EmailVerified() || p.trustedEmail
+	return user, &domain.Identity{Subject: dst.Subject(), Email: user.Email, EmailVerified: verified}, nil
+}
+
+// getJSON decodes the response of the endpoint into dst
+func getJSON(client *http.Client, endpoint string, dst any) error {
+	resp, err := client.Get(endpoint)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer func(b io.ReadCloser) { _ = b.Close() }(resp.Body)
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("fetch user info: %s", resp.Status)
+		return fmt.Errorf("fetch %s: %s", endpoint, resp.Status)
 	}
 
-	// parse user's data
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	dst := p.payload()
-	err = json.Unmarshal(body, dst)
-	if err != nil {
-		return nil, err
-	}
+	return json.Unmarshal(body, dst)
+}
+
+// randomString returns 32 random bytes encoded in base64 url, long enough for a pkce verifier
+func randomString() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b) // never returns an error
+	return base64.RawURLEncoding.EncodeToString(b)
+}
 
-	return dst.ToUser(), nil
+// codeChallenge returns the S256 pkce challenge of the verifier
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
 }
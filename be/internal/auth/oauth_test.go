@@ -0,0 +1,311 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/escalopa/vego/internal/config"
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestOAuthProvider_PKCE(t *testing.T) {
+	t.Parallel()
+
+	var verifier string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		verifier = r.FormValue("code_verifier")
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "token", "token_type": "bearer"})
+	})
+	mux.HandleFunc("/user", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(googlePayload{ID: "1", Name: "User", Email: "user@example.com", VerifiedEmail: true})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	op := &OAuthProvider{providers: map[string]*provider{
+		googleProvider: {
+			config: &oauth2.Config{
+				ClientID: "client",
+				Endpoint: oauth2.Endpoint{AuthURL: srv.URL + "/auth", TokenURL: srv.URL + "/token"},
+			},
+			endpoint: srv.URL + "/user",
+			payload:  func() payload { return &googlePayload{} },
+		},
+	}}
+
+	_, _, err := op.GetRedirectURL("unknown")
+	require.ErrorIs(t, err, domain.ErrOAuthUnsupportedProvider)
+
+	redirectURL, state, err := op.GetRedirectURL(googleProvider)
+	require.NoError(t, err)
+	require.Equal(t, googleProvider, state.Provider)
+	require.NotEmpty(t, state.State)
+	require.GreaterOrEqual(t, len(state.Verifier), 43) // minimum length of rfc 7636
+
+	u, err := url.Parse(redirectURL)
+	require.NoError(t, err)
+	require.Equal(t, state.State, u.Query().Get("state"))
+	sum := sha256.Sum256([]byte(state.Verifier))
+	require.Equal(t, base64.RawURLEncoding.EncodeToString(sum[:]), u.Query().Get("code_challenge"))
+	require.Equal(t, "S256", u.Query().Get("code_challenge_method"))
+
+	// every login gets its own state
+	_, other, err := op.GetRedirectURL(googleProvider)
+	require.NoError(t, err)
+	require.NotEqual(t, state.State, other.State)
+	require.NotEqual(t, state.Verifier, other.Verifier)
+
+	user, identity, err := op.HandleCallback(context.Background(), googleProvider, "code", state.Verifier)
+	require.NoError(t, err)
+	require.Equal(t, "user@example.com", user.Email)
+	require.Equal(t, &domain.Identity{Provider: googleProvider, Subject: "1", Email: "user@example.com", EmailVerified: true}, identity)
+	require.Equal(t, state.Verifier, verifier)
+}
+
+func TestNewOAuthProvider(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		cfg     config.OAuthConfig
+		want    []domain.OAuthProvider
+		wantErr bool
+	}{
+		{
+			name: "enabled_providers",
+			cfg: config.OAuthConfig{Providers: map[string]config.OAuthProviderConfig{
+				"google":  {Kind: googleProvider, ClientID: "client"},
+				"gitlab":  {Kind: gitlabProvider, ClientID: "client", BaseURL: "https://gitlab.example.com/"},
+				"entra":   {Kind: entraProvider, ClientID: "client", DisplayName: "Work account"},
+				"yandex":  {Kind: yandexProvider, ClientID: "client", Disabled: true},
+				"discord": {Kind: discordProvider}, // unconfigured
+			}},
+			want: []domain.OAuthProvider{
+				{Name: "entra", Kind: entraProvider, DisplayName: "Work account"},
+				{Name: "gitlab", Kind: gitlabProvider, DisplayName: "GitLab"},
+				{Name: "google", Kind: googleProvider, DisplayName: "Google"},
+			},
+		},
+		{
+			name: "no_providers",
+			cfg:  config.OAuthConfig{},
+			want: []domain.OAuthProvider{},
+		},
+		{
+			name: "reserved_name",
+			cfg: config.OAuthConfig{Providers: map[string]config.OAuthProviderConfig{
+				"local": {Kind: googleProvider, ClientID: "client"},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "unsupported_kind",
+			cfg: config.OAuthConfig{Providers: map[string]config.OAuthProviderConfig{
+				"google": {Kind: "unknown", ClientID: "client"},
+			}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			op, err := NewOAuthProvider(context.Background(), tt.cfg)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, op.Providers())
+
+			// unconfigured and disabled providers cannot be logged in with
+			_, _, err = op.GetRedirectURL("yandex")
+			require.ErrorIs(t, err, domain.ErrOAuthUnsupportedProvider)
+		})
+	}
+
+	// the endpoints of self-hosted gitlab are under its base url
+	op, err := NewOAuthProvider(context.Background(), config.OAuthConfig{Providers: map[string]config.OAuthProviderConfig{
+		"gitlab": {Kind: gitlabProvider, ClientID: "client", BaseURL: "https://gitlab.example.com/"},
+	}})
+	require.NoError(t, err)
+	require.Equal(t, "https://gitlab.example.com/oauth/authorize", op.providers["gitlab"].config.Endpoint.AuthURL)
+	require.Equal(t, "https://gitlab.example.com/api/v4/user", op.providers["gitlab"].endpoint)
+}
+
+func TestPayload_ToUser(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		payload payload
+		want    *domain.User
+	}{
+		{
+			name:    "gitlab_without_name",
+			payload: &gitlabPayload{Username: "user", Email: "user@example.com", AvatarURL: "https://gitlab.com/avatar.png"},
+			want:    &domain.User{Name: "user", Email: "user@example.com", Avatar: "https://gitlab.com/avatar.png"},
+		},
+		{
+			name:    "entra_without_mailbox",
+			payload: &entraPayload{DisplayName: "User", UserPrincipalName: "user@example.onmicrosoft.com"},
+			want:    &domain.User{Name: "User", Email: "user@example.onmicrosoft.com"},
+		},
+		{
+			name:    "discord",
+			payload: &discordPayload{ID: "1", Username: "user", GlobalName: "User", Email: "user@example.com", Avatar: "hash"},
+			want:    &domain.User{Name: "User", Email: "user@example.com", Avatar: "https://cdn.discordapp.com/avatars/1/hash.png"},
+		},
+		{
+			name:    "discord_default_avatar",
+			payload: &discordPayload{ID: "1", Username: "user", Email: "user@example.com"},
+			want:    &domain.User{Name: "user", Email: "user@example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tt.want, tt.payload.ToUser())
+		})
+	}
+}
+
+func TestOAuthProvider_FetchUser(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		kind     string
+		trusted  bool   // trusted_email of the provider
+		user     string // body of the user endpoint
+		emails   string // body of the emails endpoint
+		idToken  jwt.MapClaims
+		wantUser *domain.User
+		wantSub  string
+		wantErr  error
+
+		wantVerified bool
+	}{
+		{
+			name:     "github_private_email",
+			kind:     githubProvider,
+			user:     `{"id": 42, "login": "user", "name": null, "email": null, "avatar_url": "https://github.com/avatar.png"}`,
+			emails:   `[{"email": "old@example.com", "primary": false, "verified": true}, {"email": "user@example.com", "primary": true, "verified": true}]`,
+			wantUser: &domain.User{Name: "user", Email: "user@example.com", Avatar: "https://github.com/avatar.png"},
+			wantSub:  "42",
+
+			wantVerified: true,
+		},
+		{
+			name:    "github_unverified_primary_email",
+			kind:    githubProvider,
+			user:    `{"login": "user", "email": "user@example.com"}`,
+			emails:  `[{"email": "user@example.com", "primary": true, "verified": false}]`,
+			wantErr: domain.ErrOAuthEmailNotVerified,
+		},
+		{
+			name:     "yandex_without_avatar",
+			kind:     yandexProvider,
+			user:     `{"id": "7", "login": "user", "default_email": "user@yandex.ru", "default_avatar_id": "0/0-0", "is_avatar_empty": true}`,
+			wantUser: &domain.User{Name: "user", Email: "user@yandex.ru"},
+			wantSub:  "7",
+		},
+		{
+			name:     "yandex_trusted_email",
+			kind:     yandexProvider,
+			trusted:  true,
+			user:     `{"id": "7", "login": "user", "default_email": "user@yandex.ru"}`,
+			wantUser: &domain.User{Name: "user", Email: "user@yandex.ru"},
+			wantSub:  "7",
+
+			wantVerified: true,
+		},
+		{
+			name:     "entra_verified_domain",
+			kind:     entraProvider,
+			user:     `{"id": "e1", "displayName": "User", "mail": "user@example.com", "userPrincipalName": "user@tenant.onmicrosoft.com"}`,
+			idToken:  jwt.MapClaims{"email": "User@example.com", "xms_edov": true},
+			wantUser: &domain.User{Name: "User", Email: "user@example.com"},
+			wantSub:  "e1",
+
+			wantVerified: true,
+		},
+		{
+			name:     "entra_unverified_domain",
+			kind:     entraProvider,
+			user:     `{"id": "e1", "displayName": "User", "mail": "user@example.com"}`,
+			idToken:  jwt.MapClaims{"email": "user@example.com"},
+			wantUser: &domain.User{Name: "User", Email: "user@example.com"},
+			wantSub:  "e1",
+		},
+		{
+			name:     "entra_principal_name",
+			kind:     entraProvider,
+			user:     `{"id": "e1", "displayName": "User", "userPrincipalName": "user@example.com"}`,
+			idToken:  jwt.MapClaims{"email": "user@example.com", "xms_edov": true},
+			wantUser: &domain.User{Name: "User", Email: "user@example.com"},
+			wantSub:  "e1",
+		},
+		{
+			name:    "google_unverified_email",
+			kind:    googleProvider,
+			user:    `{"name": "User", "email": "user@example.com", "verified_email": false}`,
+			wantErr: domain.ErrOAuthEmailNotVerified,
+		},
+		{
+			name:    "discord_without_email",
+			kind:    discordProvider,
+			user:    `{"id": "1", "username": "user", "verified": true}`,
+			wantErr: domain.ErrOAuthEmailNotVerified,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("/token", func(w http.ResponseWriter, _ *http.Request) {
+				body := map[string]any{"access_token": "token", "token_type": "bearer"}
+				if tt.idToken != nil {
+					idToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, tt.idToken).SignedString([]byte("key"))
+					require.NoError(t, err)
+					body["id_token"] = idToken
+				}
+				w.Header().Set("Content-Type", "application/json")
+				_ = json.NewEncoder(w).Encode(body)
+			})
+			mux.HandleFunc("/user", func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write([]byte(tt.user)) })
+			mux.HandleFunc("/user/emails", func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write([]byte(tt.emails)) })
+			srv := httptest.NewServer(mux)
+			t.Cleanup(srv.Close)
+
+			p, err := newProvider(context.Background(), config.OAuthProviderConfig{Kind: tt.kind, ClientID: "client", UserEndpoint: srv.URL + "/user", TrustedEmail: tt.trusted})
+			require.NoError(t, err)
+			p.config.Endpoint = oauth2.Endpoint{TokenURL: srv.URL + "/token"}
+			op := &OAuthProvider{providers: map[string]*provider{tt.kind: p}}
+
+			user, identity, err := op.HandleCallback(context.Background(), tt.kind, "code", "verifier")
+			require.Equal(t, tt.wantErr, err)
+			require.Equal(t, tt.wantUser, user)
+			if tt.wantErr == nil {
+				require.Equal(t, tt.wantSub, identity.Subject)
+				require.Equal(t, tt.wantVerified, identity.EmailVerified)
+			}
+		})
+	}
+}
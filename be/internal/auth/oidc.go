@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/escalopa/vego/internal/config"
+	"github.com/escalopa/vego/internal/domain"
+	"golang.org/x/oauth2"
+)
+
+// errOIDCDiscovery is returned when the issuer cannot be discovered, the provider is skipped
+// rather than failing the server as the issuer may only be down for a while
+var errOIDCDiscovery = errors.New("discover oidc issuer")
+
+// newOIDCProvider discovers the endpoints and the signing keys of the issuer
+func newOIDCProvider(ctx context.Context, cfg config.OAuthProviderConfig) (*provider, error) {
+	issuer, err := oidc.NewProvider(ctx, cfg.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errOIDCDiscovery, err)
+	}
+
+	scopes := []string{oidc.ScopeOpenID}
+	for _, scope := range cfg.Scopes {
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	claims := cfg.Claims
+	if claims.Name == "" {
+		claims.Name = "name"
+	}
+	if claims.Email == "" {
+		claims.Email = "email"
+	}
+	if claims.Avatar == "" {
+		claims.Avatar = "picture"
+	}
+
+	return &provider{
+		config: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Scopes:       scopes,
+			Endpoint:     issuer.Endpoint(),
+		},
+		idToken: issuer.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+		claims:  claims,
+
+		trustedEmail: cfg.TrustedEmail,
+	}, nil
+}
+
+// verifyIDToken checks the id token of the exchange against the keys of the issuer and maps
+// its claims to the user, the nonce is not used as the code is bound to the login by pkce
+func (p *provider) verifyIDToken(ctx context.Context, token *oauth2.Token) (*domain.User, *domain.Identity, error) {
+	raw, ok := token.Extra("id_token").(string)
+	if !ok || raw == "" {
+		log.Printf("oidc.verifyIDToken: missing id token")
+		return nil, nil, domain.ErrOAuthGetUserInfo
+	}
+
+	idToken, err := p.idToken.Verify(ctx, raw)
+	if err != nil {
+		log.Printf("oidc.Verify err: %v", err)
+		return nil, nil, domain.ErrOAuthGetUserInfo
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		log.Printf("oidc.Claims err: %v", err)
+		return nil, nil, domain.ErrOAuthGetUserInfo
+	}
+
+	claim := func(name string) string {
+		value, _ := claims[name].(string)
+		return value
+	}
+
+	// the emails of issuers not sending email_verified are only displayed unless trusted
+	verified, ok := claims["email_verified"].(bool)
+	if ok && !verified || claim(p.claims.Email) == "" {
+		return nil, nil, domain.ErrOAuthEmailNotVerified
+	}
+
+	user := &domain.User{
+		Name:   claim(p.claims.Name),
+		Email:  claim(p.claims.Email),
+		Avatar: claim(p.claims.Avatar),
+	}
+	return user, &domain.Identity{Subject: idToken.Subject, Email: user.Email, EmailVerified: verified || p.trustedEmail}, nil
+}
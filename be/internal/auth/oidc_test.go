@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/escalopa/vego/internal/config"
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// stubIssuer is an OpenID Connect issuer answering the token exchange with the id token of claims
+type stubIssuer struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims
+}
+
+func newStubIssuer(t *testing.T) *stubIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	s := &stubIssuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                s.URL,
+			"authorization_endpoint":                s.URL + "/auth",
+			"token_endpoint":                        s.URL + "/token",
+			"jwks_uri":                              s.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "key1",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, _ *http.Request) {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, s.claims)
+		token.Header["kid"] = "key1"
+		idToken, err := token.SignedString(s.key)
+		require.NoError(t, err)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "token",
+			"token_type":   "bearer",
+			"id_token":     idToken,
+		})
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func TestOAuthProvider_OIDC(t *testing.T) {
+	t.Parallel()
+
+	issuer := newStubIssuer(t)
+
+	cfg := config.OAuthConfig{
+		Providers: map[string]config.OAuthProviderConfig{
+			"keycloak": {
+				Kind:      oidcProvider,
+				IssuerURL: issuer.URL,
+				ClientID:  "vego",
+				Scopes:    []string{"openid", "email"},
+				Claims:    config.OIDCClaimsConfig{Name: "preferred_username"},
+			},
+		},
+	}
+	op, err := NewOAuthProvider(context.Background(), cfg)
+	require.NoError(t, err)
+
+	p := op.providers["keycloak"]
+	require.Equal(t, domain.OAuthProvider{Name: "keycloak", Kind: oidcProvider, DisplayName: "keycloak"}, p.info)
+	require.Equal(t, issuer.URL+"/auth", p.config.Endpoint.AuthURL)
+	require.Equal(t, []string{"openid", "email"}, p.config.Scopes)
+
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":                issuer.URL,
+			"aud":                "vego",
+			"sub":                "user1",
+			"exp":                time.Now().Add(time.Minute).Unix(),
+			"iat":                time.Now().Unix(),
+			"preferred_username": "user",
+			"name":               "User Name",
+			"email":              "user@example.com",
+			"picture":            "https://example.com/avatar.png",
+		}
+	}
+
+	tests := []struct {
+		name     string
+		claims   func(claims jwt.MapClaims)
+		wantUser *domain.User
+		wantErr  error
+	}{
+		{
+			name:     "valid_id_token",
+			claims:   func(jwt.MapClaims) {},
+			wantUser: &domain.User{Name: "user", Email: "user@example.com", Avatar: "https://example.com/avatar.png"},
+		},
+		{
+			name:     "verified_email",
+			claims:   func(claims jwt.MapClaims) { claims["email_verified"] = true },
+			wantUser: &domain.User{Name: "user", Email: "user@example.com", Avatar: "https://example.com/avatar.png"},
+		},
+		{
+			name:    "unverified_email",
+			claims:  func(claims jwt.MapClaims) { claims["email_verified"] = false },
+			wantErr: domain.ErrOAuthEmailNotVerified,
+		},
+		{
+			name:    "expired_id_token",
+			claims:  func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Minute).Unix() },
+			wantErr: domain.ErrOAuthGetUserInfo,
+		},
+		{
+			name:    "other_audience",
+			claims:  func(claims jwt.MapClaims) { claims["aud"] = "other" },
+			wantErr: domain.ErrOAuthGetUserInfo,
+		},
+		{
+			name:    "other_issuer",
+			claims:  func(claims jwt.MapClaims) { claims["iss"] = "https://other.example.com" },
+			wantErr: domain.ErrOAuthGetUserInfo,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims()
+			tt.claims(claims)
+			issuer.claims = claims
+
+			user, identity, err := op.HandleCallback(context.Background(), "keycloak", "code", "verifier")
+			require.Equal(t, tt.wantErr, err)
+			require.Equal(t, tt.wantUser, user)
+			if tt.wantErr == nil {
+				// emails without email_verified are only displayed
+				verified := claims["email_verified"] == true
+				require.Equal(t, &domain.Identity{Provider: "keycloak", Subject: "user1", Email: "user@example.com", EmailVerified: verified}, identity)
+			}
+		})
+	}
+}
+
+func TestOAuthProvider_OIDCDiscovery(t *testing.T) {
+	t.Parallel()
+
+	issuer := newStubIssuer(t)
+
+	cfg := config.OAuthConfig{
+		Providers: map[string]config.OAuthProviderConfig{
+			"keycloak": {Kind: oidcProvider, IssuerURL: issuer.URL + "/unknown", ClientID: "vego"},
+		},
+	}
+	// the server starts without the provider of an unreachable issuer
+	op, err := NewOAuthProvider(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Empty(t, op.Providers())
+}
@@ -1,45 +1,201 @@
 package auth
 
 import (
+	"cmp"
+	"context"
 	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/escalopa/vego/internal/config"
 	"github.com/escalopa/vego/internal/domain"
+	"github.com/golang-jwt/jwt/v4"
 	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
+	"golang.org/x/oauth2/github"
+	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/microsoft"
+	"golang.org/x/oauth2/yandex"
 )
 
 const (
-	googleProvider = "google"
-	githubProvider = "github"
-	yandexProvider = "yandex"
+	googleProvider  = "google"
+	githubProvider  = "github"
+	yandexProvider  = "yandex"
+	gitlabProvider  = "gitlab"
+	entraProvider   = "entra"
+	discordProvider = "discord"
+	oidcProvider    = "oidc"
 )
 
 type payload interface {
 	ToUser() *domain.User
+	Subject() string     // id of the user at the provider
+	EmailVerified() bool // whether the provider verified the email of the user
+}
+
+// emailFetcher is a payload whose email is fetched from another endpoint than the user's
+type emailFetcher interface {
+	fetchEmail(client *http.Client, userEndpoint string) error
+}
+
+// idTokenReader is a payload whose email is verified by the claims of the id token of the exchange
+type idTokenReader interface {
+	readIDToken(token *oauth2.Token)
 }
 
 type provider struct {
+	info     domain.OAuthProvider
 	config   *oauth2.Config
 	endpoint string
 	payload  func() payload
+
+	// verifiesEmail is set for the providers reporting whether the email is verified,
+	// logins with an unverified email are refused, the emails of the others are only displayed
+	verifiesEmail bool
+	trustedEmail  bool // the operator trusts the emails of the provider as verified
+
+	// openid connect providers read the user from the id token instead of the endpoint
+	idToken *oidc.IDTokenVerifier
+	claims  config.OIDCClaimsConfig
+}
+
+// newProvider creates the provider of the kind, the scopes and the user endpoint of the kind
+// are used unless set in the config
+func newProvider(ctx context.Context, cfg config.OAuthProviderConfig) (*provider, error) {
+	if cfg.Kind == oidcProvider {
+		return newOIDCProvider(ctx, cfg)
+	}
+
+	p := &provider{
+		config: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+		},
+		trustedEmail: cfg.TrustedEmail,
+	}
+
+	switch cfg.Kind {
+	case googleProvider:
+		p.info.DisplayName = "Google"
+		p.config.Endpoint = google.Endpoint
+		p.config.Scopes = []string{"email", "profile"}
+		p.endpoint = "https://www.googleapis.com/oauth2/v2/userinfo"
+		p.payload = func() payload { return &googlePayload{} }
+		p.verifiesEmail = true
+	case githubProvider:
+		p.info.DisplayName = "GitHub"
+		p.config.Endpoint = github.Endpoint
+		p.config.Scopes = []string{"user:email"}
+		p.endpoint = "https://api.github.com/user"
+		p.payload = func() payload { return &githubPayload{} }
+		p.verifiesEmail = true
+	case yandexProvider:
+		p.info.DisplayName = "Yandex"
+		p.config.Endpoint = yandex.Endpoint
+		p.config.Scopes = []string{"login:info", "login:email", "login:avatar"}
+		p.endpoint = "https://login.yandex.ru/info?format=json"
+		p.payload = func() payload { return &yandexPayload{} }
+	case gitlabProvider:
+		baseURL := strings.TrimSuffix(cmp.Or(cfg.BaseURL, "https://gitlab.com"), "/")
+		p.info.DisplayName = "GitLab"
+		p.config.Endpoint = oauth2.Endpoint{AuthURL: baseURL + "/oauth/authorize", TokenURL: baseURL + "/oauth/token"}
+		p.config.Scopes = []string{"read_user"}
+		p.endpoint = baseURL + "/api/v4/user"
+		p.payload = func() payload { return &gitlabPayload{} }
+		p.verifiesEmail = true
+	case entraProvider:
+		p.info.DisplayName = "Microsoft"
+		p.config.Endpoint = microsoft.AzureADEndpoint(cfg.Tenant)
+		p.config.Scopes = []string{"openid", "email", "profile", "User.Read"}
+		p.endpoint = "https://graph.microsoft.com/v1.0/me"
+		p.payload = func() payload { return &entraPayload{} }
+	case discordProvider:
+		p.info.DisplayName = "Discord"
+		p.config.Endpoint = endpoints.Discord
+		p.config.Scopes = []string{"identify", "email"}
+		p.endpoint = "https://discord.com/api/users/@me"
+		p.payload = func() payload { return &discordPayload{} }
+		p.verifiesEmail = true
+	default:
+		return nil, fmt.Errorf("unsupported kind %q", cfg.Kind)
+	}
+
+	if len(cfg.Scopes) > 0 {
+		p.config.Scopes = cfg.Scopes
+	}
+	if cfg.UserEndpoint != "" {
+		p.endpoint = cfg.UserEndpoint
+	}
+
+	return p, nil
 }
 
 type (
 	googlePayload struct {
-		Name    string `json:"name"`
-		Email   string `json:"email"`
-		Picture string `json:"picture"`
+		ID            string `json:"id"`
+		Name          string `json:"name"`
+		Email         string `json:"email"`
+		VerifiedEmail bool   `json:"verified_email"`
+		Picture       string `json:"picture"`
 	}
 
 	githubPayload struct {
+		ID        int64  `json:"id"`
+		Login     string `json:"login"`
 		Name      string `json:"name"`
-		Email     string `json:"email"`
+		Email     string `json:"email"` // public email, null when kept private
 		AvatarURL string `json:"avatar_url"`
+
+		verified bool // set once the primary email is fetched
+	}
+
+	githubEmail struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
 	}
 
 	yandexPayload struct {
+		ID              string `json:"id"`
+		Login           string `json:"login"`
+		DisplayName     string `json:"display_name"`
 		RealName        string `json:"real_name"`
 		DefaultEmail    string `json:"default_email"`
 		DefaultAvatarID string `json:"default_avatar_id"`
+		IsAvatarEmpty   bool   `json:"is_avatar_empty"`
+	}
+
+	gitlabPayload struct {
+		ID          int64  `json:"id"`
+		Name        string `json:"name"`
+		Username    string `json:"username"`
+		Email       string `json:"email"`
+		ConfirmedAt string `json:"confirmed_at"` // null until the email is confirmed
+		AvatarURL   string `json:"avatar_url"`
+	}
+
+	// entraPayload is the user of the microsoft graph
+	entraPayload struct {
+		ID                string `json:"id"`
+		DisplayName       string `json:"displayName"`
+		Mail              string `json:"mail"`
+		UserPrincipalName string `json:"userPrincipalName"`
+
+		verified bool // set when the id token proves the domain of mail is owned by the tenant
+	}
+
+	discordPayload struct {
+		ID         string `json:"id"`
+		Username   string `json:"username"`
+		GlobalName string `json:"global_name"`
+		Email      string `json:"email"`
+		Verified   bool   `json:"verified"`
+		Avatar     string `json:"avatar"` // hash of the avatar, empty for the default one
 	}
 )
 
@@ -51,18 +207,137 @@ func (p *googlePayload) ToUser() *domain.User {
 	}
 }
 
+func (p *googlePayload) Subject() string {
+	return p.ID
+}
+
+func (p *googlePayload) EmailVerified() bool {
+	return p.VerifiedEmail
+}
+
 func (p *githubPayload) ToUser() *domain.User {
 	return &domain.User{
-		Name:   p.Name,
+		Name:   cmp.Or(p.Name, p.Login),
 		Email:  p.Email,
 		Avatar: p.AvatarURL,
 	}
 }
 
+func (p *githubPayload) Subject() string {
+	return strconv.FormatInt(p.ID, 10)
+}
+
+func (p *githubPayload) EmailVerified() bool {
+	return p.verified
+}
+
+// fetchEmail replaces the public email of the user by the verified primary one, the public
+// email is null for users keeping their email private
+func (p *githubPayload) fetchEmail(client *http.Client, userEndpoint string) error {
+	var emails []githubEmail
+	if err := getJSON(client, userEndpoint+"/emails", &emails); err != nil {
+		return err
+	}
+
+	p.Email, p.verified = "", false
+	for _, email := range emails {
+		if email.Primary && email.Verified {
+			p.Email, p.verified = email.Email, true
+			break
+		}
+	}
+
+	return nil
+}
+
 func (p *yandexPayload) ToUser() *domain.User {
+	user := &domain.User{
+		Name:  cmp.Or(p.RealName, p.DisplayName, p.Login),
+		Email: p.DefaultEmail,
+	}
+	if !p.IsAvatarEmpty && p.DefaultAvatarID != "" {
+		user.Avatar = fmt.Sprintf("https://avatars.yandex.net/get-yapic/%s/islands-200", p.DefaultAvatarID)
+	}
+	return user
+}
+
+func (p *yandexPayload) Subject() string {
+	return p.ID
+}
+
+// EmailVerified is false as yandex does not report whether the default email is verified
+func (p *yandexPayload) EmailVerified() bool {
+	return false
+}
+
+func (p *gitlabPayload) ToUser() *domain.User {
 	return &domain.User{
-		Name:   p.RealName,
-		Email:  p.DefaultEmail,
-		Avatar: fmt.Sprintf("https://avatars.yandex.net/get-yapic/%s/islands-200", p.DefaultAvatarID),
+		Name:   cmp.Or(p.Name, p.Username),
+		Email:  p.Email,
+		Avatar: p.AvatarURL,
 	}
 }
+
+func (p *gitlabPayload) Subject() string {
+	return strconv.FormatInt(p.ID, 10)
+}
+
+func (p *gitlabPayload) EmailVerified() bool {
+	return p.ConfirmedAt != ""
+}
+
+func (p *entraPayload) ToUser() *domain.User {
+	return &domain.User{
+		Name:  p.DisplayName,
+		Email: cmp.Or(p.Mail, p.UserPrincipalName), // mail is empty for accounts without a mailbox
+	}
+}
+
+func (p *entraPayload) Subject() string {
+	return p.ID
+}
+
+// EmailVerified is true only for the mail the id token verifies, the emails of entra accounts
+// are set by the admins of their tenant and userPrincipalName is not an email at all
+func (p *entraPayload) EmailVerified() bool {
+	return p.verified
+}
+
+// readIDToken verifies mail with the optional xms_edov claim of the id token, the claim is true when
+// the domain of the email is verified by the tenant. The token is received from the token endpoint
+// over tls so its signature is not checked
+func (p *entraPayload) readIDToken(token *oauth2.Token) {
+	raw, _ := token.Extra("id_token").(string)
+	if raw == "" || p.Mail == "" {
+		return
+	}
+
+	var claims jwt.MapClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(raw, &claims); err != nil {
+		log.Printf("entra.readIDToken: %v", err)
+		return
+	}
+
+	email, _ := claims["email"].(string)
+	edov, _ := claims["xms_edov"].(bool)
+	p.verified = edov && strings.EqualFold(email, p.Mail)
+}
+
+func (p *discordPayload) ToUser() *domain.User {
+	user := &domain.User{
+		Name:  cmp.Or(p.GlobalName, p.Username),
+		Email: p.Email,
+	}
+	if p.Avatar != "" {
+		user.Avatar = fmt.Sprintf("https://cdn.discordapp.com/avatars/%s/%s.png", p.ID, p.Avatar)
+	}
+	return user
+}
+
+func (p *discordPayload) Subject() string {
+	return p.ID
+}
+
+func (p *discordPayload) EmailVerified() bool {
+	return p.Verified
+}
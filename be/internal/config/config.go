@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"log"
 	"path"
 	"time"
@@ -13,12 +14,45 @@ type Config struct {
 	DB    DBConfig    `mapstructure:"DB" json:"db" yaml:"db"`
 	JWT   JWTConfig   `mapstructure:"JWT" json:"jwt" yaml:"jwt"`
 	OAuth OAuthConfig `mapstructure:"OAUTH" json:"oauth" yaml:"oauth"`
+
+	Local       LocalConfig       `mapstructure:"LOCAL" json:"local" yaml:"local"`
+	AccessToken AccessTokenConfig `mapstructure:"ACCESS_TOKEN" json:"access_token" yaml:"access_token"`
+
+	Room      RoomConfig      `mapstructure:"ROOM" json:"room" yaml:"room"`
+	Recording RecordingConfig `mapstructure:"RECORDING" json:"recording" yaml:"recording"`
+	TURN      TURNConfig      `mapstructure:"TURN" json:"turn" yaml:"turn"`
+	ICE       ICEConfig       `mapstructure:"ICE" json:"ice" yaml:"ice"`
 }
 
 type AppConfig struct {
 	Addr         string   `mapstructure:"ADDR" json:"addr" yaml:"addr"`
 	Domain       string   `mapstructure:"DOMAIN" json:"domain" yaml:"domain"`
 	AllowOrigins []string `mapstructure:"ALLOW_ORIGINS" json:"allow_origins" yaml:"allow_origins"`
+
+	InternalToken string `mapstructure:"INTERNAL_TOKEN" json:"internal_token" yaml:"internal_token"`
+}
+
+type RoomConfig struct {
+	MinProtocolVersion int              `mapstructure:"MIN_PROTOCOL_VERSION" json:"min_protocol_version" yaml:"min_protocol_version"`
+	MaxParticipants    int              `mapstructure:"MAX_PARTICIPANTS" json:"max_participants" yaml:"max_participants"`
+	MaxUserConnections int              `mapstructure:"MAX_USER_CONNECTIONS" json:"max_user_connections" yaml:"max_user_connections"`
+	MaxActiveRooms     int              `mapstructure:"MAX_ACTIVE_ROOMS" json:"max_active_rooms" yaml:"max_active_rooms"`
+	IdleGracePeriod    time.Duration    `mapstructure:"IDLE_GRACE_PERIOD" json:"idle_grace_period" yaml:"idle_grace_period"`
+	MaxMessageSize     int64            `mapstructure:"MAX_MESSAGE_SIZE" json:"max_message_size" yaml:"max_message_size"`
+	ChatSlowMode       time.Duration    `mapstructure:"CHAT_SLOW_MODE" json:"chat_slow_mode" yaml:"chat_slow_mode"`
+	RateLimits         RateLimitsConfig `mapstructure:"RATE_LIMITS" json:"rate_limits" yaml:"rate_limits"`
+	MaxViolations      int              `mapstructure:"MAX_VIOLATIONS" json:"max_violations" yaml:"max_violations"`
+	ViolationWindow    time.Duration    `mapstructure:"VIOLATION_WINDOW" json:"violation_window" yaml:"violation_window"`
+}
+
+type RateLimitsConfig struct {
+	Chat      RateLimitConfig `mapstructure:"CHAT" json:"chat" yaml:"chat"`
+	Signaling RateLimitConfig `mapstructure:"SIGNALING" json:"signaling" yaml:"signaling"`
+}
+
+type RateLimitConfig struct {
+	Rate  float64 `mapstructure:"RATE" json:"rate" yaml:"rate"`
+	Burst int     `mapstructure:"BURST" json:"burst" yaml:"burst"`
 }
 
 type DBConfig struct {
@@ -26,8 +60,16 @@ type DBConfig struct {
 }
 
 type JWTConfig struct {
-	Room JWTRoom `mapstructure:"ROOM" json:"room" yaml:"room"`
-	User JWTUser `mapstructure:"AUTH" json:"auth" yaml:"auth"`
+	Issuer string   `mapstructure:"ISSUER" json:"issuer" yaml:"issuer"` // iss of the tokens, empty means vego
+	Keys   []JWTKey `mapstructure:"KEYS" json:"keys" yaml:"keys"`       // the first key signs, the others only verify tokens signed before a rotation
+	Room   JWTRoom  `mapstructure:"ROOM" json:"room" yaml:"room"`
+	User   JWTUser  `mapstructure:"AUTH" json:"auth" yaml:"auth"`
+}
+
+// JWTKey is a key signing the tokens, its public key is published at /.well-known/jwks.json
+type JWTKey struct {
+	ID             string `mapstructure:"ID" json:"id" yaml:"id"`                                           // kid of the tokens signed with the key
+	PrivateKeyFile string `mapstructure:"PRIVATE_KEY_FILE" json:"private_key_file" yaml:"private_key_file"` // PEM encoded RSA (RS256) or Ed25519 (EdDSA) key
 }
 
 type JWTRoom struct {
@@ -41,19 +83,133 @@ type JWTUser struct {
 	RefreshTokenTTL time.Duration `mapstructure:"REFRESH_TOKEN_TTL" json:"refresh_token_ttl" yaml:"refresh_token_ttl"`
 }
 
+// LocalConfig configures the users logging in with a username and password,
+// for deployments without a route to the oauth providers
+type LocalConfig struct {
+	Enabled           bool `mapstructure:"ENABLED" json:"enabled" yaml:"enabled"`                                     // log in with a username and password
+	Registration      bool `mapstructure:"REGISTRATION" json:"registration" yaml:"registration"`                      // users register themselves
+	PasswordChange    bool `mapstructure:"PASSWORD_CHANGE" json:"password_change" yaml:"password_change"`             // users change their password
+	AdminReset        bool `mapstructure:"ADMIN_RESET" json:"admin_reset" yaml:"admin_reset"`                         // operators create users and reset passwords through the internal api
+	MinPasswordLength int  `mapstructure:"MIN_PASSWORD_LENGTH" json:"min_password_length" yaml:"min_password_length"` // 0 means 12
+}
+
+// AccessTokenConfig configures the personal access tokens the users automate the api with
+type AccessTokenConfig struct {
+	MaxTTL time.Duration `mapstructure:"MAX_TTL" json:"max_ttl" yaml:"max_ttl"` // longest lifetime a token can be created with
+}
+
 type OAuthConfig struct {
-	Google OAuthProviderConfig `mapstructure:"GOOGLE" json:"google" yaml:"google"`
-	GitHub OAuthProviderConfig `mapstructure:"GITHUB" json:"github" yaml:"github"`
-	Yandex OAuthProviderConfig `mapstructure:"YANDEX" json:"yandex" yaml:"yandex"`
+	StateTTL time.Duration `mapstructure:"STATE_TTL" json:"state_ttl" yaml:"state_ttl"` // how long a started login can be completed, 0 means 10m
+	AutoLink bool          `mapstructure:"AUTO_LINK" json:"auto_link" yaml:"auto_link"` // log in new provider accounts to the user with the same verified email of a trusted provider
+
+	Providers map[string]OAuthProviderConfig `mapstructure:"PROVIDERS" json:"providers" yaml:"providers"` // keyed by the provider name
+
+	// Deprecated: use Providers, LoadConfig moves the providers of the legacy keys into Providers
+	Google OAuthProviderConfig `mapstructure:"GOOGLE" json:"google,omitempty" yaml:"google,omitempty"`
+	// Deprecated: use Providers
+	GitHub OAuthProviderConfig `mapstructure:"GITHUB" json:"github,omitempty" yaml:"github,omitempty"`
+	// Deprecated: use Providers
+	Yandex OAuthProviderConfig `mapstructure:"YANDEX" json:"yandex,omitempty" yaml:"yandex,omitempty"`
+}
+
+// moveLegacyProviders adds the providers of the legacy oauth.google, oauth.github and oauth.yandex
+// keys to Providers under the name of their kind, so their callback urls are unchanged
+func (c *OAuthConfig) moveLegacyProviders() error {
+	legacy := map[string]*OAuthProviderConfig{
+		"google": &c.Google,
+		"github": &c.GitHub,
+		"yandex": &c.Yandex,
+	}
+
+	for name, p := range legacy {
+		if p.ClientID == "" {
+			continue
+		}
+		if _, ok := c.Providers[name]; ok {
+			return fmt.Errorf("oauth.%s: provider also set in oauth.providers, remove the legacy key", name)
+		}
+
+		log.Printf("config: oauth.%s is deprecated, move it to oauth.providers.%s", name, name)
+		if c.Providers == nil {
+			c.Providers = make(map[string]OAuthProviderConfig)
+		}
+		provider := *p
+		provider.Kind = name
+		c.Providers[name] = provider
+		*p = OAuthProviderConfig{}
+	}
+
+	return nil
 }
 
+// OAuthProviderConfig is a login provider, providers without a client id are not enabled
 type OAuthProviderConfig struct {
-	ClientID     string `mapstructure:"CLIENT_ID" json:"client_id" yaml:"client_id"`
-	ClientSecret string `mapstructure:"CLIENT_SECRET" json:"client_secret" yaml:"client_secret"`
-	RedirectURL  string `mapstructure:"REDIRECT_URL" json:"redirect_url" yaml:"redirect_url"`
-	UserEndpoint string `mapstructure:"USER_ENDPOINT" json:"user_endpoint" yaml:"user_endpoint"`
+	Kind        string `mapstructure:"KIND" json:"kind" yaml:"kind"` // google, github, yandex, gitlab, entra, discord or oidc
+	Disabled    bool   `mapstructure:"DISABLED" json:"disabled" yaml:"disabled"`
+	DisplayName string `mapstructure:"DISPLAY_NAME" json:"display_name" yaml:"display_name"` // empty uses the name of the kind
+
+	// TrustedEmail treats the emails of the provider as verified so they can be auto linked, for
+	// single tenant entra, yandex or oidc issuers not sending email_verified only
+	TrustedEmail bool `mapstructure:"TRUSTED_EMAIL" json:"trusted_email" yaml:"trusted_email"`
+
+	ClientID     string   `mapstructure:"CLIENT_ID" json:"client_id" yaml:"client_id"`
+	ClientSecret string   `mapstructure:"CLIENT_SECRET" json:"client_secret" yaml:"client_secret"`
+	RedirectURL  string   `mapstructure:"REDIRECT_URL" json:"redirect_url" yaml:"redirect_url"`
+	Scopes       []string `mapstructure:"SCOPES" json:"scopes" yaml:"scopes"` // empty uses the scopes of the kind
+
+	UserEndpoint string `mapstructure:"USER_ENDPOINT" json:"user_endpoint" yaml:"user_endpoint"` // empty uses the endpoint of the kind
+	BaseURL      string `mapstructure:"BASE_URL" json:"base_url" yaml:"base_url"`                // gitlab, empty uses gitlab.com
+	Tenant       string `mapstructure:"TENANT" json:"tenant" yaml:"tenant"`                      // entra, empty uses common
+
+	// oidc, the endpoints are discovered from the issuer
+	IssuerURL string           `mapstructure:"ISSUER_URL" json:"issuer_url" yaml:"issuer_url"`
+	Claims    OIDCClaimsConfig `mapstructure:"CLAIMS" json:"claims" yaml:"claims"`
+}
+
+// OIDCClaimsConfig names the id token claims the user is read from, empty names use the standard claims
+type OIDCClaimsConfig struct {
+	Name   string `mapstructure:"NAME" json:"name" yaml:"name"`
+	Email  string `mapstructure:"EMAIL" json:"email" yaml:"email"`
+	Avatar string `mapstructure:"AVATAR" json:"avatar" yaml:"avatar"`
+}
+
+type RecordingConfig struct {
+	Dir           string        `mapstructure:"DIR" json:"dir" yaml:"dir"`
+	Retention     time.Duration `mapstructure:"RETENTION" json:"retention" yaml:"retention"`
+	PurgeInterval time.Duration `mapstructure:"PURGE_INTERVAL" json:"purge_interval" yaml:"purge_interval"`
+	MaxUploadSize int64         `mapstructure:"MAX_UPLOAD_SIZE" json:"max_upload_size" yaml:"max_upload_size"`
+	UploadTTL     time.Duration `mapstructure:"UPLOAD_TTL" json:"upload_ttl" yaml:"upload_ttl"`
+}
+
+type TURNConfig struct {
+	Enabled      bool   `mapstructure:"ENABLED" json:"enabled" yaml:"enabled"`
+	Realm        string `mapstructure:"REALM" json:"realm" yaml:"realm"`
+	Host         string `mapstructure:"HOST" json:"host" yaml:"host"`
+	Port         int    `mapstructure:"PORT" json:"port" yaml:"port"`
+	ListenIP     string `mapstructure:"LISTEN_IP" json:"listen_ip" yaml:"listen_ip"`
+	RelayIP      string `mapstructure:"RELAY_IP" json:"relay_ip" yaml:"relay_ip"`
+	RelayMinPort uint16 `mapstructure:"RELAY_MIN_PORT" json:"relay_min_port" yaml:"relay_min_port"`
+	RelayMaxPort uint16 `mapstructure:"RELAY_MAX_PORT" json:"relay_max_port" yaml:"relay_max_port"`
+	SecretKey    string `mapstructure:"SECRET_KEY" json:"secret_key" yaml:"secret_key"`
+}
+
+type ICEConfig struct {
+	Servers []ICEServerConfig `mapstructure:"SERVERS" json:"servers" yaml:"servers"`
 }
 
+// ICEServerConfig describes an external STUN/TURN server, its credentials are either
+// static (username and credential) or derived per user from a TURN REST API secret key
+type ICEServerConfig struct {
+	URLs       []string `mapstructure:"URLS" json:"urls" yaml:"urls"`
+	Username   string   `mapstructure:"USERNAME" json:"username" yaml:"username"`
+	Credential string   `mapstructure:"CREDENTIAL" json:"credential" yaml:"credential"`
+	SecretKey  string   `mapstructure:"SECRET_KEY" json:"secret_key" yaml:"secret_key"`
+}
+
+// defaultOAuthStateTTL is used when oauth.state_ttl is not set, a zero ttl would expire
+// every login before the user is back from the provider
+const defaultOAuthStateTTL = 10 * time.Minute
+
 func LoadConfig(file string) (Config, error) {
 	var config Config
 
@@ -71,5 +227,13 @@ func LoadConfig(file string) (Config, error) {
 		return config, err
 	}
 
+	if err := config.OAuth.moveLegacyProviders(); err != nil {
+		return config, err
+	}
+
+	if config.OAuth.StateTTL <= 0 {
+		config.OAuth.StateTTL = defaultOAuthStateTTL
+	}
+
 	return config, nil
 }
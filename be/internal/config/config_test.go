@@ -16,35 +16,135 @@ app:
   domain: "http://localhost:8080/api/health"
   allow_origins:
     - "http://localhost"
+  internal_token: "your_internal_token" # bearer token of the internal api, empty disables it
 
 db:
   file: "./database.db"
 
 jwt:
+  issuer: "https://vego.example.com" # iss of the tokens, the services verifying them with /.well-known/jwks.json check it, empty means vego
+  keys: # the first key signs, the others only verify tokens signed before a rotation, no keys sign with the secret keys below
+    - id: "2026-10"
+      private_key_file: "./keys/2026-10.pem" # PEM encoded RSA (RS256) or Ed25519 (EdDSA) key, e.g. openssl genpkey -algorithm ed25519
+    - id: "2026-04"
+      private_key_file: "./keys/2026-04.pem"
   room:
-    secret_key: "your_room_secret_key"
+    secret_key: "your_room_secret_key" # verifies HS256 tokens signed before the keys, signs when no keys are set
     token_ttl: 1m
   auth:
-    secret_key: "your_auth_secret_key"
+    secret_key: "your_auth_secret_key" # as for room
     access_token_ttl: 1h
     refresh_token_ttl: 720h
 
 oauth:
-  google:
-    client_id: "your-google-client-id"
-    client_secret: "your-google-client-secret"
-    redirect_url: "http://localhost:8080/api/oauth/google/callback"
-    user_endpoint: "https://www.googleapis.com/oauth2/v2/userinfo"
-  github:
-    client_id: "your-github-client-id"
-    client_secret: "your-github-client-secret"
-    redirect_url: "http://localhost:8080/api/oauth/github/callback"
-    user_endpoint: "https://api.github.com/user"
-  yandex:
-    client_id: "your-yandex-client-id"
-    client_secret: "your-yandex-client-secret"
-    redirect_url: "http://localhost:8080/api/oauth/yandex/callback"
-    user_endpoint: "https://login.yandex.ru/info?format=json"
+  state_ttl: 10m # how long a started login can be completed, 0 means 10m
+  auto_link: false # log in new provider accounts to the user with the same verified email of a trusted provider
+  providers: # keyed by name, providers without a client id are not enabled, replaces the deprecated oauth.google, oauth.github and oauth.yandex keys
+    google:
+      kind: "google" # google, github, yandex, gitlab, entra, discord or oidc
+      client_id: "your-google-client-id"
+      client_secret: "your-google-client-secret"
+      redirect_url: "http://localhost:8080/api/oauth/google/callback"
+    github:
+      kind: "github"
+      client_id: "your-github-client-id"
+      client_secret: "your-github-client-secret"
+      redirect_url: "http://localhost:8080/api/oauth/github/callback"
+    yandex:
+      kind: "yandex"
+      disabled: true
+      client_id: "your-yandex-client-id"
+      client_secret: "your-yandex-client-secret"
+      redirect_url: "http://localhost:8080/api/oauth/yandex/callback"
+    gitlab:
+      kind: "gitlab"
+      base_url: "https://gitlab.example.com" # self-hosted instance, empty uses gitlab.com
+      client_id: "your-gitlab-client-id"
+      client_secret: "your-gitlab-client-secret"
+      redirect_url: "http://localhost:8080/api/oauth/gitlab/callback"
+    entra:
+      kind: "entra"
+      display_name: "Microsoft"
+      tenant: "your-tenant-id" # empty uses common
+      trusted_email: true # single tenant, its emails are managed by the operator
+      client_id: "your-entra-client-id"
+      client_secret: "your-entra-client-secret"
+      redirect_url: "http://localhost:8080/api/oauth/entra/callback"
+    discord:
+      kind: "discord"
+      client_id: "your-discord-client-id"
+      client_secret: "your-discord-client-secret"
+      redirect_url: "http://localhost:8080/api/oauth/discord/callback"
+    keycloak:
+      kind: "oidc"
+      display_name: "Keycloak"
+      issuer_url: "https://keycloak.example.com/realms/vego" # the endpoints are discovered from the issuer
+      client_id: "your-keycloak-client-id"
+      client_secret: "your-keycloak-client-secret"
+      redirect_url: "http://localhost:8080/api/oauth/keycloak/callback"
+      scopes: ["email", "profile"] # openid is always requested
+      claims: # id token claims of the user, empty uses the standard claims
+        name: "preferred_username"
+        email: "email"
+        avatar: "picture"
+
+local: # username and password users, for deployments without a route to the oauth providers
+  enabled: false
+  registration: false # users register themselves
+  password_change: true
+  admin_reset: true # operators create users and reset passwords through the internal api
+  min_password_length: 12
+
+access_token:
+  max_ttl: 8760h # longest lifetime a personal access token can be created with
+
+room:
+  min_protocol_version: 1 # clients declaring an older protocol version must upgrade
+  max_participants: 50 # 0 means no limit
+  max_user_connections: 5 # across the rooms of the node
+  max_active_rooms: 1000 # per node
+  idle_grace_period: 30s # how long an empty room is kept before it is torn down
+  max_message_size: 65536 # 64KB
+  chat_slow_mode: 1s # min interval between two chat messages of a connection, 0s disables
+  rate_limits: # events per second refilling a bucket of burst events, rate 0 disables
+    chat:
+      rate: 2
+      burst: 5
+    signaling:
+      rate: 50
+      burst: 200
+  max_violations: 20 # rate limited events within violation_window before disconnecting, 0 never disconnects
+  violation_window: 10s
+
+recording:
+  dir: "./recordings"
+  retention: 720h
+  purge_interval: 1h
+  max_upload_size: 2147483648 # 2GB
+  upload_ttl: 24h
+
+turn:
+  enabled: true
+  realm: "vego"
+  host: "localhost" # public host clients reach the server at
+  port: 3478
+  listen_ip: "0.0.0.0"
+  relay_ip: "127.0.0.1" # public ip advertised for relayed candidates
+  relay_min_port: 50000
+  relay_max_port: 50100
+  secret_key: "your_turn_secret_key"
+
+ice:
+  servers:
+    - urls:
+        - "stun:stun.l.google.com:19302"
+    - urls:
+        - "turn:turn.example.com:3478"
+      username: "your-turn-username"
+      credential: "your-turn-credential"
+    - urls:
+        - "turns:turn.example.com:5349"
+      secret_key: "your-turn-rest-secret-key"
 `)
 
 	tmpFile, err := os.CreateTemp("/tmp", "config*.yml")
@@ -65,11 +165,18 @@ oauth:
 			Addr:         ":8080",
 			Domain:       "http://localhost:8080/api/health",
 			AllowOrigins: []string{"http://localhost"},
+
+			InternalToken: "your_internal_token",
 		},
 		DB: DBConfig{
 			File: "./database.db",
 		},
 		JWT: JWTConfig{
+			Issuer: "https://vego.example.com",
+			Keys: []JWTKey{
+				{ID: "2026-10", PrivateKeyFile: "./keys/2026-10.pem"},
+				{ID: "2026-04", PrivateKeyFile: "./keys/2026-04.pem"},
+			},
 			Room: JWTRoom{
 				SecretKey: "your_room_secret_key",
 				TokenTTL:  1 * time.Minute,
@@ -81,26 +188,209 @@ oauth:
 			},
 		},
 		OAuth: OAuthConfig{
-			Google: OAuthProviderConfig{
-				ClientID:     "your-google-client-id",
-				ClientSecret: "your-google-client-secret",
-				RedirectURL:  "http://localhost:8080/api/oauth/google/callback",
-				UserEndpoint: "https://www.googleapis.com/oauth2/v2/userinfo",
+			StateTTL: 10 * time.Minute,
+			AutoLink: false,
+			Providers: map[string]OAuthProviderConfig{
+				"google": {
+					Kind:         "google",
+					ClientID:     "your-google-client-id",
+					ClientSecret: "your-google-client-secret",
+					RedirectURL:  "http://localhost:8080/api/oauth/google/callback",
+				},
+				"github": {
+					Kind:         "github",
+					ClientID:     "your-github-client-id",
+					ClientSecret: "your-github-client-secret",
+					RedirectURL:  "http://localhost:8080/api/oauth/github/callback",
+				},
+				"yandex": {
+					Kind:         "yandex",
+					Disabled:     true,
+					ClientID:     "your-yandex-client-id",
+					ClientSecret: "your-yandex-client-secret",
+					RedirectURL:  "http://localhost:8080/api/oauth/yandex/callback",
+				},
+				"gitlab": {
+					Kind:         "gitlab",
+					BaseURL:      "https://gitlab.example.com",
+					ClientID:     "your-gitlab-client-id",
+					ClientSecret: "your-gitlab-client-secret",
+					RedirectURL:  "http://localhost:8080/api/oauth/gitlab/callback",
+				},
+				"entra": {
+					Kind:         "entra",
+					DisplayName:  "Microsoft",
+					Tenant:       "your-tenant-id",
+					TrustedEmail: true,
+					ClientID:     "your-entra-client-id",
+					ClientSecret: "your-entra-client-secret",
+					RedirectURL:  "http://localhost:8080/api/oauth/entra/callback",
+				},
+				"discord": {
+					Kind:         "discord",
+					ClientID:     "your-discord-client-id",
+					ClientSecret: "your-discord-client-secret",
+					RedirectURL:  "http://localhost:8080/api/oauth/discord/callback",
+				},
+				"keycloak": {
+					Kind:         "oidc",
+					DisplayName:  "Keycloak",
+					IssuerURL:    "https://keycloak.example.com/realms/vego",
+					ClientID:     "your-keycloak-client-id",
+					ClientSecret: "your-keycloak-client-secret",
+					RedirectURL:  "http://localhost:8080/api/oauth/keycloak/callback",
+					Scopes:       []string{"email", "profile"},
+					Claims: OIDCClaimsConfig{
+						Name:   "preferred_username",
+						Email:  "email",
+						Avatar: "picture",
+					},
+				},
 			},
-			GitHub: OAuthProviderConfig{
-				ClientID:     "your-github-client-id",
-				ClientSecret: "your-github-client-secret",
-				RedirectURL:  "http://localhost:8080/api/oauth/github/callback",
-				UserEndpoint: "https://api.github.com/user",
+		},
+		Local: LocalConfig{
+			PasswordChange:    true,
+			AdminReset:        true,
+			MinPasswordLength: 12,
+		},
+		AccessToken: AccessTokenConfig{
+			MaxTTL: 8760 * time.Hour,
+		},
+		Room: RoomConfig{
+			MinProtocolVersion: 1,
+			MaxParticipants:    50,
+			MaxUserConnections: 5,
+			MaxActiveRooms:     1000,
+			IdleGracePeriod:    30 * time.Second,
+			MaxMessageSize:     64 << 10,
+			ChatSlowMode:       1 * time.Second,
+			RateLimits: RateLimitsConfig{
+				Chat:      RateLimitConfig{Rate: 2, Burst: 5},
+				Signaling: RateLimitConfig{Rate: 50, Burst: 200},
 			},
-			Yandex: OAuthProviderConfig{
-				ClientID:     "your-yandex-client-id",
-				ClientSecret: "your-yandex-client-secret",
-				RedirectURL:  "http://localhost:8080/api/oauth/yandex/callback",
-				UserEndpoint: "https://login.yandex.ru/info?format=json",
+			MaxViolations:   20,
+			ViolationWindow: 10 * time.Second,
+		},
+		Recording: RecordingConfig{
+			Dir:           "./recordings",
+			Retention:     720 * time.Hour,
+			PurgeInterval: 1 * time.Hour,
+			MaxUploadSize: 2 << 30,
+			UploadTTL:     24 * time.Hour,
+		},
+		TURN: TURNConfig{
+			Enabled:      true,
+			Realm:        "vego",
+			Host:         "localhost",
+			Port:         3478,
+			ListenIP:     "0.0.0.0",
+			RelayIP:      "127.0.0.1",
+			RelayMinPort: 50000,
+			RelayMaxPort: 50100,
+			SecretKey:    "your_turn_secret_key",
+		},
+		ICE: ICEConfig{
+			Servers: []ICEServerConfig{
+				{
+					URLs: []string{"stun:stun.l.google.com:19302"},
+				},
+				{
+					URLs:       []string{"turn:turn.example.com:3478"},
+					Username:   "your-turn-username",
+					Credential: "your-turn-credential",
+				},
+				{
+					URLs:      []string{"turns:turn.example.com:5349"},
+					SecretKey: "your-turn-rest-secret-key",
+				},
 			},
 		},
 	}
 
 	require.Empty(t, cmp.Diff(expectedConfig, config))
 }
+
+func TestLoadConfig_DefaultOAuthStateTTL(t *testing.T) {
+	tmpFile, err := os.CreateTemp("/tmp", "config*.yml")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.Remove(tmpFile.Name()))
+	}()
+
+	_, err = tmpFile.Write([]byte("oauth:\n  auto_link: false\n"))
+	require.NoError(t, err)
+	require.NoError(t, tmpFile.Close())
+
+	config, err := LoadConfig(tmpFile.Name())
+	require.NoError(t, err)
+	require.Equal(t, defaultOAuthStateTTL, config.OAuth.StateTTL)
+}
+
+func TestLoadConfig_LegacyOAuthProviders(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]OAuthProviderConfig
+		wantErr bool
+	}{
+		{
+			name: "moved",
+			data: `
+oauth:
+  google:
+    client_id: "your-google-client-id"
+    client_secret: "your-google-client-secret"
+    redirect_url: "http://localhost:8080/api/oauth/google/callback"
+  providers:
+    gitlab:
+      kind: gitlab
+      client_id: "your-gitlab-client-id"
+`,
+			want: map[string]OAuthProviderConfig{
+				"google": {
+					Kind:         "google",
+					ClientID:     "your-google-client-id",
+					ClientSecret: "your-google-client-secret",
+					RedirectURL:  "http://localhost:8080/api/oauth/google/callback",
+				},
+				"gitlab": {Kind: "gitlab", ClientID: "your-gitlab-client-id"},
+			},
+		},
+		{
+			name: "conflict",
+			data: `
+oauth:
+  github:
+    client_id: "your-github-client-id"
+  providers:
+    github:
+      kind: github
+      client_id: "your-github-client-id"
+`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpFile, err := os.CreateTemp("/tmp", "config*.yml")
+			require.NoError(t, err)
+			defer func() {
+				require.NoError(t, os.Remove(tmpFile.Name()))
+			}()
+
+			_, err = tmpFile.Write([]byte(tt.data))
+			require.NoError(t, err)
+			require.NoError(t, tmpFile.Close())
+
+			config, err := LoadConfig(tmpFile.Name())
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, config.OAuth.Providers)
+			require.Empty(t, config.OAuth.Google)
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+)
+
+const accessTokenColumns = `
+	token_id,
+	user_id,
+	name,
+	token_hash,
+	scopes,
+	created_at,
+	expires_at,
+	last_used_at
+`
+
+func (db *DB) CreateAccessToken(ctx context.Context, token *domain.AccessToken) error {
+	const query = `
+		INSERT INTO access_tokens (token_id, user_id, name, token_hash, scopes, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+
+	_, err := db.conn.ExecContext(ctx, query,
+		token.TokenID,
+		token.UserID,
+		token.Name,
+		token.TokenHash,
+		strings.Join(token.Scopes, " "),
+		token.CreatedAt.Unix(),
+		token.ExpiresAt.Unix(),
+	)
+	if err != nil {
+		log.Printf("db.CreateAccessToken: %v", err)
+		return domain.ErrDBQuery
+	}
+
+	return nil
+}
+
+// GetAccessTokenByHash returns the token with the hash, expired tokens are returned as well
+func (db *DB) GetAccessTokenByHash(ctx context.Context, tokenHash string) (*domain.AccessToken, error) {
+	const query = `
+		SELECT` + accessTokenColumns + `
+		FROM access_tokens
+		WHERE token_hash = $1
+	`
+
+	token, err := scanAccessToken(db.conn.QueryRowContext(ctx, query, tokenHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrDBAccessTokenNotFound
+		}
+		log.Printf("db.GetAccessTokenByHash: %v", err)
+		return nil, domain.ErrDBQuery
+	}
+
+	return token, nil
+}
+
+// GetUserAccessTokens returns the tokens of the user, newest first
+func (db *DB) GetUserAccessTokens(ctx context.Context, userID int64) ([]domain.AccessToken, error) {
+	const query = `
+		SELECT` + accessTokenColumns + `
+		FROM access_tokens
+		WHERE user_id = $1
+		ORDER BY created_at DESC, token_id
+	`
+
+	rows, err := db.conn.QueryContext(ctx, query, userID)
+	if err != nil {
+		log.Printf("db.GetUserAccessTokens: %v", err)
+		return nil, domain.ErrDBQuery
+	}
+	defer func() { _ = rows.Close() }()
+
+	tokens := make([]domain.AccessToken, 0)
+	for rows.Next() {
+		token, err := scanAccessToken(rows)
+		if err != nil {
+			log.Printf("db.GetUserAccessTokens: scan: %v", err)
+			return nil, domain.ErrDBQuery
+		}
+		tokens = append(tokens, *token)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("db.GetUserAccessTokens: rows: %v", err)
+		return nil, domain.ErrDBQuery
+	}
+
+	return tokens, nil
+}
+
+// TouchAccessToken records the last use of the token
+func (db *DB) TouchAccessToken(ctx context.Context, tokenID string, at time.Time) error {
+	const query = `
+		UPDATE access_tokens
+		SET last_used_at = $1
+		WHERE token_id = $2
+	`
+
+	res, err := db.conn.ExecContext(ctx, query, at.Unix(), tokenID)
+	if err != nil {
+		log.Printf("db.TouchAccessToken: %v", err)
+		return domain.ErrDBQuery
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return domain.ErrDBAccessTokenNotFound
+	}
+
+	return nil
+}
+
+// DeleteAccessToken revokes the token of the user
+func (db *DB) DeleteAccessToken(ctx context.Context, userID int64, tokenID string) error {
+	const query = `
+		DELETE FROM access_tokens
+		WHERE user_id = $1 AND token_id = $2
+	`
+
+	res, err := db.conn.ExecContext(ctx, query, userID, tokenID)
+	if err != nil {
+		log.Printf("db.DeleteAccessToken: %v", err)
+		return domain.ErrDBQuery
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return domain.ErrDBAccessTokenNotFound
+	}
+
+	return nil
+}
+
+// DeleteUserAccessTokens revokes all the tokens of the user
+func (db *DB) DeleteUserAccessTokens(ctx context.Context, userID int64) error {
+	const query = `
+		DELETE FROM access_tokens
+		WHERE user_id = $1
+	`
+
+	if _, err := db.conn.ExecContext(ctx, query, userID); err != nil {
+		log.Printf("db.DeleteUserAccessTokens: %v", err)
+		return domain.ErrDBQuery
+	}
+
+	return nil
+}
+
+func scanAccessToken(row scanner) (*domain.AccessToken, error) {
+	var (
+		token                            domain.AccessToken
+		scopes                           string
+		createdAt, expiresAt, lastUsedAt int64
+	)
+
+	err := row.Scan(
+		&token.TokenID,
+		&token.UserID,
+		&token.Name,
+		&token.TokenHash,
+		&scopes,
+		&createdAt,
+		&expiresAt,
+		&lastUsedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Scopes = strings.Fields(scopes)
+	token.CreatedAt = time.Unix(createdAt, 0)
+	token.ExpiresAt = time.Unix(expiresAt, 0)
+	if lastUsedAt > 0 {
+		token.LastUsedAt = time.Unix(lastUsedAt, 0)
+	}
+	return &token, nil
+}
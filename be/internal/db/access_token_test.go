@@ -0,0 +1,76 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBAccessTokenMethods(t *testing.T) {
+	db := setupTestDB(t)
+
+	ctx := context.Background()
+
+	userID, err := db.CreateUser(ctx, &domain.User{Name: "User", Email: "user@example.com"}, "google")
+	require.NoError(t, err)
+
+	now := time.Now().Truncate(time.Second)
+	token := &domain.AccessToken{
+		TokenID:   uuid.NewString(),
+		UserID:    userID,
+		Name:      "ci",
+		TokenHash: "hash1",
+		Scopes:    []string{domain.ScopeRoomsWrite, domain.ScopeRecordingsRead},
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	}
+	require.NoError(t, db.CreateAccessToken(ctx, token))
+
+	got, err := db.GetAccessTokenByHash(ctx, "hash1")
+	require.NoError(t, err)
+	require.Equal(t, token, got)
+
+	_, err = db.GetAccessTokenByHash(ctx, "hash2")
+	require.ErrorIs(t, err, domain.ErrDBAccessTokenNotFound)
+
+	require.NoError(t, db.TouchAccessToken(ctx, token.TokenID, now.Add(time.Minute)))
+	token.LastUsedAt = now.Add(time.Minute)
+
+	other := &domain.AccessToken{
+		TokenID:   uuid.NewString(),
+		UserID:    userID,
+		Name:      "reports",
+		TokenHash: "hash2",
+		Scopes:    []string{domain.ScopeRecordingsRead},
+		CreatedAt: now.Add(time.Second),
+		ExpiresAt: now.Add(time.Hour),
+	}
+	require.NoError(t, db.CreateAccessToken(ctx, other))
+
+	tokens, err := db.GetUserAccessTokens(ctx, userID)
+	require.NoError(t, err)
+	require.Equal(t, []domain.AccessToken{*other, *token}, tokens)
+
+	// the token of another user is not revoked
+	require.ErrorIs(t, db.DeleteAccessToken(ctx, userID+1, token.TokenID), domain.ErrDBAccessTokenNotFound)
+	require.NoError(t, db.DeleteAccessToken(ctx, userID, token.TokenID))
+	require.ErrorIs(t, db.DeleteAccessToken(ctx, userID, token.TokenID), domain.ErrDBAccessTokenNotFound)
+	require.ErrorIs(t, db.TouchAccessToken(ctx, token.TokenID, now), domain.ErrDBAccessTokenNotFound)
+
+	_, err = db.GetAccessTokenByHash(ctx, "hash1")
+	require.ErrorIs(t, err, domain.ErrDBAccessTokenNotFound)
+
+	// the tokens of another user are kept
+	require.NoError(t, db.DeleteUserAccessTokens(ctx, userID+1))
+	_, err = db.GetAccessTokenByHash(ctx, "hash2")
+	require.NoError(t, err)
+
+	require.NoError(t, db.DeleteUserAccessTokens(ctx, userID))
+	tokens, err = db.GetUserAccessTokens(ctx, userID)
+	require.NoError(t, err)
+	require.Empty(t, tokens)
+}
@@ -29,7 +29,109 @@ func New(filepath string) (*DB, error) {
 			provider TEXT NOT NULL
 		);
 		
-		CREATE UNIQUE INDEX IF NOT EXISTS idx_email_provider ON users (email, provider); -- ensure email+provider is unique
+		-- users are keyed by their identities, accounts of a provider may share an email
+		DROP INDEX IF EXISTS idx_email_provider;
+		CREATE INDEX IF NOT EXISTS idx_users_email ON users (email, provider);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_users_local_email ON users (email) WHERE provider = 'local'; -- local users log in by username, their email is unique among them
+
+		CREATE TABLE IF NOT EXISTS rooms (
+			room_id TEXT PRIMARY KEY,
+			owner_id INTEGER NOT NULL REFERENCES users (user_id),
+			retention INTEGER NOT NULL DEFAULT 0, -- seconds, 0 means use the default retention
+			created_at INTEGER NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS room_members (
+			room_id TEXT NOT NULL REFERENCES rooms (room_id),
+			user_id INTEGER NOT NULL REFERENCES users (user_id),
+			joined_at INTEGER NOT NULL, -- first join, the member attended the recordings made since
+			PRIMARY KEY (room_id, user_id)
+		);
+
+		CREATE TABLE IF NOT EXISTS recordings (
+			recording_id TEXT PRIMARY KEY,
+			room_id TEXT NOT NULL REFERENCES rooms (room_id),
+			user_id INTEGER NOT NULL REFERENCES users (user_id),
+			file TEXT NOT NULL,
+			content_type TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			created_at INTEGER NOT NULL
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_recordings_room ON recordings (room_id);
+
+		CREATE TABLE IF NOT EXISTS uploads (
+			upload_id TEXT PRIMARY KEY,
+			room_id TEXT NOT NULL REFERENCES rooms (room_id),
+			user_id INTEGER NOT NULL REFERENCES users (user_id),
+			file TEXT NOT NULL,
+			content_type TEXT NOT NULL,
+			length INTEGER NOT NULL,
+			received INTEGER NOT NULL DEFAULT 0, -- bytes received so far (upload offset)
+			created_at INTEGER NOT NULL,
+			updated_at INTEGER NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS sessions (
+			session_id TEXT PRIMARY KEY, -- shared by the refresh tokens rotated from the same login
+			user_id INTEGER NOT NULL REFERENCES users (user_id),
+			token_id TEXT NOT NULL, -- jti of the current refresh token
+			token_hash TEXT NOT NULL, -- sha256 of the current refresh token
+			prev_token_id TEXT NOT NULL DEFAULT '', -- jti of the refresh token replaced by the last rotation
+			created_at INTEGER NOT NULL,
+			rotated_at INTEGER NOT NULL,
+			expires_at INTEGER NOT NULL,
+			revoked_at INTEGER NOT NULL DEFAULT 0, -- 0 while the session is active
+			last_used_at INTEGER NOT NULL,
+			user_agent TEXT NOT NULL DEFAULT '',
+			ip TEXT NOT NULL DEFAULT '' -- of the last use
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions (user_id);
+
+		CREATE TABLE IF NOT EXISTS oauth_states (
+			state TEXT PRIMARY KEY, -- also kept in a cookie of the browser the login started from
+			provider TEXT NOT NULL,
+			verifier TEXT NOT NULL, -- pkce code verifier
+			user_id INTEGER NOT NULL DEFAULT 0, -- user linking the provider, 0 for logins
+			expires_at INTEGER NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS user_identities (
+			provider TEXT NOT NULL,
+			subject TEXT NOT NULL, -- id of the account at the provider
+			user_id INTEGER NOT NULL REFERENCES users (user_id),
+			email TEXT NOT NULL,
+			created_at INTEGER NOT NULL,
+			PRIMARY KEY (provider, subject)
+		);
+
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_user_identities_user ON user_identities (user_id, provider); -- one account per provider
+
+		CREATE TABLE IF NOT EXISTS access_tokens (
+			token_id TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users (user_id),
+			name TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE, -- sha256 of the token, the token itself is shown once
+			scopes TEXT NOT NULL, -- space separated
+			created_at INTEGER NOT NULL,
+			expires_at INTEGER NOT NULL,
+			last_used_at INTEGER NOT NULL DEFAULT 0 -- 0 until the token is used
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_access_tokens_user ON access_tokens (user_id);
+
+		CREATE TABLE IF NOT EXISTS local_credentials (
+			username TEXT PRIMARY KEY, -- subject of the local identity, the password is ignored once it is unlinked
+			user_id INTEGER NOT NULL REFERENCES users (user_id),
+			password_hash TEXT NOT NULL, -- argon2id in the PHC string format
+			updated_at INTEGER NOT NULL
+		);
+
+		-- users logged in with an empty email shared one account, it is retired as it cannot tell them apart
+		UPDATE sessions SET revoked_at = CAST(strftime('%s', 'now') AS INTEGER)
+		WHERE revoked_at = 0 AND user_id IN (SELECT user_id FROM users WHERE email = '' AND provider <> 'local');
+		DELETE FROM access_tokens WHERE user_id IN (SELECT user_id FROM users WHERE email = '' AND provider <> 'local');
 	`
 	_, err = conn.Exec(query)
 	if err != nil {
@@ -64,11 +166,11 @@ func (db *DB) GetUser(_ context.Context, userID int64) (*domain.User, error) {
 	return &res, nil
 }
 
+// CreateUser creates a user for a new identity of the provider, users sharing the email are kept apart
 func (db *DB) CreateUser(_ context.Context, user *domain.User, provider string) (int64, error) {
 	const query = `
 		INSERT INTO users (name, email, avatar, provider)
-		VALUES ($1, $2, $3, $4) ON CONFLICT DO
-		UPDATE SET name = $1, avatar = $3
+		VALUES ($1, $2, $3, $4)
 		RETURNING user_id
 	`
 
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/escalopa/vego/internal/domain"
 	"github.com/google/uuid"
@@ -86,3 +87,67 @@ func TestDBUserMethods(t *testing.T) {
 		})
 	}
 }
+
+func TestNew_DropsEmailProviderIndex(t *testing.T) {
+	testDBFile := fmt.Sprintf("/tmp/test_db_%s.db", uuid.NewString())
+	t.Cleanup(func() { require.NoError(t, os.Remove(testDBFile)) })
+
+	db, err := New(testDBFile)
+	require.NoError(t, err)
+
+	// databases created before identities keyed the users by email and provider
+	_, err = db.conn.Exec(`CREATE UNIQUE INDEX idx_email_provider ON users (email, provider)`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	db, err = New(testDBFile)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+
+	ctx := context.Background()
+	for range 2 {
+		_, err := db.CreateUser(ctx, &domain.User{Name: "User", Email: "user@example.com"}, "google")
+		require.NoError(t, err)
+	}
+}
+
+func TestNew_RetiresEmptyEmailUsers(t *testing.T) {
+	testDBFile := fmt.Sprintf("/tmp/test_db_%s.db", uuid.NewString())
+	t.Cleanup(func() { require.NoError(t, os.Remove(testDBFile)) })
+
+	db, err := New(testDBFile)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	sessions := make(map[string]bool) // session id to whether it must be revoked
+	for _, email := range []string{"", "user@example.com"} {
+		userID, err := db.CreateUser(ctx, &domain.User{Name: "User", Email: email}, "github")
+		require.NoError(t, err)
+
+		session := &domain.Session{SessionID: uuid.NewString(), UserID: userID, CreatedAt: now, RotatedAt: now, ExpiresAt: now.Add(time.Hour), LastUsedAt: now}
+		require.NoError(t, db.CreateSession(ctx, session))
+		sessions[session.SessionID] = email == ""
+
+		token := &domain.AccessToken{TokenID: uuid.NewString(), UserID: userID, TokenHash: "hash" + email, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+		require.NoError(t, db.CreateAccessToken(ctx, token))
+	}
+	require.NoError(t, db.Close())
+
+	// the account shared by the users logged in with an empty email is retired on the next start
+	db, err = New(testDBFile)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+
+	for sessionID, revoked := range sessions {
+		session, err := db.GetSession(ctx, sessionID)
+		require.NoError(t, err)
+		require.Equal(t, revoked, !session.RevokedAt.IsZero())
+	}
+
+	_, err = db.GetAccessTokenByHash(ctx, "hash")
+	require.ErrorIs(t, err, domain.ErrDBAccessTokenNotFound)
+	_, err = db.GetAccessTokenByHash(ctx, "hashuser@example.com")
+	require.NoError(t, err)
+}
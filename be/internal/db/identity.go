@@ -0,0 +1,249 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+)
+
+// CreateIdentity links the identity to its user, ErrDBIdentityExists is returned if the
+// identity or another account of the provider is already linked
+func (db *DB) CreateIdentity(ctx context.Context, identity *domain.Identity) error {
+	const query = `
+		INSERT INTO user_identities (provider, subject, user_id, email, created_at)
+		VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING
+	`
+
+	res, err := db.conn.ExecContext(ctx, query,
+		identity.Provider,
+		identity.Subject,
+		identity.UserID,
+		identity.Email,
+		identity.CreatedAt.Unix(),
+	)
+	if err != nil {
+		log.Printf("db.CreateIdentity: %v", err)
+		return domain.ErrDBQuery
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return domain.ErrDBIdentityExists
+	}
+
+	return nil
+}
+
+func (db *DB) GetIdentity(ctx context.Context, provider string, subject string) (*domain.Identity, error) {
+	const query = `
+		SELECT provider,
+		       subject,
+		       user_id,
+		       email,
+		       created_at
+		FROM user_identities
+		WHERE provider = $1 AND subject = $2
+	`
+
+	identity, err := scanIdentity(db.conn.QueryRowContext(ctx, query, provider, subject))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrDBIdentityNotFound
+		}
+		log.Printf("db.GetIdentity: %v", err)
+		return nil, domain.ErrDBQuery
+	}
+
+	return identity, nil
+}
+
+func (db *DB) GetUserIdentities(ctx context.Context, userID int64) ([]domain.Identity, error) {
+	const query = `
+		SELECT provider,
+		       subject,
+		       user_id,
+		       email,
+		       created_at
+		FROM user_identities
+		WHERE user_id = $1
+		ORDER BY created_at, provider
+	`
+
+	rows, err := db.conn.QueryContext(ctx, query, userID)
+	if err != nil {
+		log.Printf("db.GetUserIdentities: %v", err)
+		return nil, domain.ErrDBQuery
+	}
+	defer func() { _ = rows.Close() }()
+
+	identities := make([]domain.Identity, 0)
+	for rows.Next() {
+		identity, err := scanIdentity(rows)
+		if err != nil {
+			log.Printf("db.GetUserIdentities: scan: %v", err)
+			return nil, domain.ErrDBQuery
+		}
+		identities = append(identities, *identity)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("db.GetUserIdentities: rows: %v", err)
+		return nil, domain.ErrDBQuery
+	}
+
+	return identities, nil
+}
+
+// DeleteIdentity unlinks the provider from the user unless it is the last identity of the user,
+// ErrDBIdentityNotFound is returned in both cases
+func (db *DB) DeleteIdentity(ctx context.Context, userID int64, provider string) error {
+	const query = `
+		DELETE FROM user_identities
+		WHERE user_id = $1 AND provider = $2
+		  AND (SELECT COUNT(*) FROM user_identities WHERE user_id = $1) > 1
+	`
+
+	res, err := db.conn.ExecContext(ctx, query, userID, provider)
+	if err != nil {
+		log.Printf("db.DeleteIdentity: %v", err)
+		return domain.ErrDBQuery
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return domain.ErrDBIdentityNotFound
+	}
+
+	return nil
+}
+
+// GetUserIDByEmail returns the oldest user with the email, created by the provider unless empty.
+// Users of the provider are only returned if they were created before identities and have none,
+// later users are found by their identities. Local users are only returned for the local provider
+// as their emails are not verified
+func (db *DB) GetUserIDByEmail(ctx context.Context, email string, provider string) (int64, error) {
+	const query = `
+		SELECT user_id
+		FROM users
+		WHERE email = $1
+		  AND (provider = $2 AND NOT EXISTS (SELECT 1 FROM user_identities i WHERE i.user_id = users.user_id)
+		       OR ($2 = '' AND provider <> $3))
+		ORDER BY user_id
+		LIMIT 1
+	`
+
+	var userID int64
+	err := db.conn.QueryRowContext(ctx, query, email, provider, domain.LocalProvider).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, domain.ErrDBUserNotFound
+		}
+		log.Printf("db.GetUserIDByEmail: %v", err)
+		return 0, domain.ErrDBQuery
+	}
+
+	return userID, nil
+}
+
+// MergeUsers moves the identities, rooms, memberships, recordings and uploads of the user
+// fromUserID to toUserID and deletes it, its sessions are revoked at and their ids returned.
+// ErrDBIdentityExists is returned if both users have an account of the same provider
+func (db *DB) MergeUsers(ctx context.Context, fromUserID int64, toUserID int64, at time.Time) ([]string, error) {
+	const (
+		conflictQuery = `
+			SELECT COUNT(*)
+			FROM user_identities f
+			JOIN user_identities t ON t.provider = f.provider
+			WHERE f.user_id = $1 AND t.user_id = $2
+		`
+		sessionQuery = `
+			UPDATE sessions
+			SET revoked_at = $1
+			WHERE user_id = $2 AND revoked_at = 0
+			RETURNING session_id
+		`
+	)
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("db.MergeUsers: begin tx: %v", err)
+		return nil, domain.ErrDBQuery
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// moveQueries hand over everything owned by the user merged from to the user merged to
+	moveQueries := []string{
+		`UPDATE user_identities SET user_id = @to WHERE user_id = @from`,
+		`UPDATE rooms SET owner_id = @to WHERE owner_id = @from`,
+		`INSERT INTO room_members (room_id, user_id, joined_at)
+		 SELECT room_id, @to, joined_at FROM room_members WHERE user_id = @from
+		 ON CONFLICT DO UPDATE SET joined_at = MIN(joined_at, excluded.joined_at)`,
+		`DELETE FROM room_members WHERE user_id = @from`,
+		`UPDATE recordings SET user_id = @to WHERE user_id = @from`,
+		`UPDATE uploads SET user_id = @to WHERE user_id = @from`,
+		`UPDATE access_tokens SET user_id = @to WHERE user_id = @from`,
+		`UPDATE local_credentials SET user_id = @to WHERE user_id = @from`,
+		`DELETE FROM users WHERE user_id = @from`,
+	}
+
+	var conflicts int
+	if err = tx.QueryRowContext(ctx, conflictQuery, fromUserID, toUserID).Scan(&conflicts); err != nil {
+		log.Printf("db.MergeUsers: check conflicts: %v", err)
+		return nil, domain.ErrDBQuery
+	}
+	if conflicts > 0 {
+		return nil, domain.ErrDBIdentityExists
+	}
+
+	for _, query := range moveQueries {
+		if _, err = tx.ExecContext(ctx, query, sql.Named("from", fromUserID), sql.Named("to", toUserID)); err != nil {
+			log.Printf("db.MergeUsers: move: %v", err)
+			return nil, domain.ErrDBQuery
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx, sessionQuery, at.Unix(), fromUserID)
+	if err != nil {
+		log.Printf("db.MergeUsers: revoke sessions: %v", err)
+		return nil, domain.ErrDBQuery
+	}
+	defer func() { _ = rows.Close() }()
+
+	sessionIDs := make([]string, 0)
+	for rows.Next() {
+		var sessionID string
+		if err := rows.Scan(&sessionID); err != nil {
+			log.Printf("db.MergeUsers: scan: %v", err)
+			return nil, domain.ErrDBQuery
+		}
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("db.MergeUsers: rows: %v", err)
+		return nil, domain.ErrDBQuery
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("db.MergeUsers: commit tx: %v", err)
+		return nil, domain.ErrDBQuery
+	}
+
+	return sessionIDs, nil
+}
+
+func scanIdentity(row scanner) (*domain.Identity, error) {
+	var (
+		identity  domain.Identity
+		createdAt int64
+	)
+	err := row.Scan(&identity.Provider, &identity.Subject, &identity.UserID, &identity.Email, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+
+	identity.CreatedAt = time.Unix(createdAt, 0)
+	return &identity, nil
+}
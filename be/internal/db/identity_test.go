@@ -0,0 +1,158 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBIdentityMethods(t *testing.T) {
+	db := setupTestDB(t)
+
+	ctx := context.Background()
+
+	userID, err := db.CreateUser(ctx, &domain.User{Name: "User", Email: "user@example.com"}, "google")
+	require.NoError(t, err)
+
+	now := time.Now().Truncate(time.Second)
+	google := &domain.Identity{Provider: "google", Subject: "1", UserID: userID, Email: "user@example.com", CreatedAt: now}
+	require.NoError(t, db.CreateIdentity(ctx, google))
+
+	got, err := db.GetIdentity(ctx, "google", "1")
+	require.NoError(t, err)
+	require.Equal(t, google, got)
+
+	_, err = db.GetIdentity(ctx, "github", "1")
+	require.ErrorIs(t, err, domain.ErrDBIdentityNotFound)
+
+	// the identity is linked once and the user has one account per provider
+	require.ErrorIs(t, db.CreateIdentity(ctx, google), domain.ErrDBIdentityExists)
+	other := &domain.Identity{Provider: "google", Subject: "2", UserID: userID, Email: "other@example.com", CreatedAt: now}
+	require.ErrorIs(t, db.CreateIdentity(ctx, other), domain.ErrDBIdentityExists)
+
+	// the last identity is kept
+	require.ErrorIs(t, db.DeleteIdentity(ctx, userID, "google"), domain.ErrDBIdentityNotFound)
+
+	github := &domain.Identity{Provider: "github", Subject: "1", UserID: userID, Email: "user@users.github.com", CreatedAt: now.Add(time.Second)}
+	require.NoError(t, db.CreateIdentity(ctx, github))
+
+	identities, err := db.GetUserIdentities(ctx, userID)
+	require.NoError(t, err)
+	require.Equal(t, []domain.Identity{*google, *github}, identities)
+
+	require.NoError(t, db.DeleteIdentity(ctx, userID, "github"))
+	require.ErrorIs(t, db.DeleteIdentity(ctx, userID, "github"), domain.ErrDBIdentityNotFound)
+
+	// the user of the provider is found by its identity once it has one
+	_, err = db.GetUserIDByEmail(ctx, "user@example.com", "google")
+	require.ErrorIs(t, err, domain.ErrDBUserNotFound)
+
+	legacyID, err := db.CreateUser(ctx, &domain.User{Name: "Legacy", Email: "legacy@example.com"}, "google")
+	require.NoError(t, err)
+	got2, err := db.GetUserIDByEmail(ctx, "legacy@example.com", "google")
+	require.NoError(t, err)
+	require.Equal(t, legacyID, got2)
+
+	got2, err = db.GetUserIDByEmail(ctx, "user@example.com", "")
+	require.NoError(t, err)
+	require.Equal(t, userID, got2)
+
+	_, err = db.GetUserIDByEmail(ctx, "user@example.com", "github")
+	require.ErrorIs(t, err, domain.ErrDBUserNotFound)
+}
+
+func TestDBSharedEmail(t *testing.T) {
+	db := setupTestDB(t)
+
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	victimID, err := db.CreateUser(ctx, &domain.User{Name: "Victim", Email: "user@example.com", Avatar: "victim"}, "entra")
+	require.NoError(t, err)
+	require.NoError(t, db.CreateIdentity(ctx, &domain.Identity{Provider: "entra", Subject: "1", UserID: victimID, Email: "user@example.com", CreatedAt: now}))
+
+	// another account of the provider with the same email logs in for the first time
+	_, err = db.GetUserIDByEmail(ctx, "user@example.com", "entra")
+	require.ErrorIs(t, err, domain.ErrDBUserNotFound)
+
+	userID, err := db.CreateUser(ctx, &domain.User{Name: "Squatter", Email: "user@example.com", Avatar: "squatter"}, "entra")
+	require.NoError(t, err)
+	require.NotEqual(t, victimID, userID)
+	require.NoError(t, db.CreateIdentity(ctx, &domain.Identity{Provider: "entra", Subject: "2", UserID: userID, Email: "user@example.com", CreatedAt: now}))
+
+	victim, err := db.GetUser(ctx, victimID)
+	require.NoError(t, err)
+	require.Equal(t, &domain.User{UserID: victimID, Name: "Victim", Email: "user@example.com", Avatar: "victim"}, victim)
+
+	for subject, wantUserID := range map[string]int64{"1": victimID, "2": userID} {
+		identity, err := db.GetIdentity(ctx, "entra", subject)
+		require.NoError(t, err)
+		require.Equal(t, wantUserID, identity.UserID)
+	}
+}
+
+func TestDBMergeUsers(t *testing.T) {
+	db := setupTestDB(t)
+
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	toUserID, err := db.CreateUser(ctx, &domain.User{Name: "User", Email: "user@example.com"}, "google")
+	require.NoError(t, err)
+	fromUserID, err := db.CreateUser(ctx, &domain.User{Name: "User", Email: "user@example.com"}, "github")
+	require.NoError(t, err)
+
+	require.NoError(t, db.CreateIdentity(ctx, &domain.Identity{Provider: "google", Subject: "1", UserID: toUserID, CreatedAt: now}))
+	require.NoError(t, db.CreateIdentity(ctx, &domain.Identity{Provider: "github", Subject: "1", UserID: fromUserID, CreatedAt: now}))
+
+	// rooms created by either account, both joined room2
+	require.NoError(t, db.AddRoomMember(ctx, "room1", fromUserID))
+	require.NoError(t, db.AddRoomMember(ctx, "room2", toUserID))
+	require.NoError(t, db.AddRoomMember(ctx, "room2", fromUserID))
+
+	rec := &domain.Recording{RecordingID: uuid.NewString(), RoomID: "room1", UserID: fromUserID, File: "file", CreatedAt: now}
+	require.NoError(t, db.CreateRecording(ctx, rec))
+
+	session := &domain.Session{SessionID: uuid.NewString(), UserID: fromUserID, CreatedAt: now, RotatedAt: now, ExpiresAt: now.Add(time.Hour), LastUsedAt: now}
+	require.NoError(t, db.CreateSession(ctx, session))
+
+	sessionIDs, err := db.MergeUsers(ctx, fromUserID, toUserID, now)
+	require.NoError(t, err)
+	require.Equal(t, []string{session.SessionID}, sessionIDs)
+
+	identities, err := db.GetUserIdentities(ctx, toUserID)
+	require.NoError(t, err)
+	require.Len(t, identities, 2)
+
+	room, err := db.GetRoom(ctx, "room1")
+	require.NoError(t, err)
+	require.Equal(t, toUserID, room.OwnerID)
+
+	for _, roomID := range []string{"room1", "room2"} {
+		member, err := db.IsRoomMember(ctx, roomID, toUserID)
+		require.NoError(t, err)
+		require.True(t, member)
+	}
+
+	recordings, err := db.GetUserRecordings(ctx, toUserID)
+	require.NoError(t, err)
+	require.Len(t, recordings, 1)
+
+	got, err := db.GetSession(ctx, session.SessionID)
+	require.NoError(t, err)
+	require.Equal(t, now, got.RevokedAt)
+
+	_, err = db.GetUser(ctx, fromUserID)
+	require.ErrorIs(t, err, domain.ErrDBUserNotFound)
+
+	// accounts of the same provider are not merged
+	thirdUserID, err := db.CreateUser(ctx, &domain.User{Name: "User", Email: "user@example.com"}, "yandex")
+	require.NoError(t, err)
+	require.NoError(t, db.CreateIdentity(ctx, &domain.Identity{Provider: "google", Subject: "2", UserID: thirdUserID, CreatedAt: now}))
+	_, err = db.MergeUsers(ctx, thirdUserID, toUserID, now)
+	require.ErrorIs(t, err, domain.ErrDBIdentityExists)
+}
@@ -0,0 +1,150 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+)
+
+// localCredentialQuery selects the credentials whose local identity is still linked to their user
+const localCredentialQuery = `
+	SELECT c.username,
+	       c.user_id,
+	       c.password_hash,
+	       c.updated_at
+	FROM local_credentials c
+	JOIN user_identities i ON i.provider = 'local' AND i.subject = c.username AND i.user_id = c.user_id
+`
+
+// CreateLocalUser creates the user with its local identity and password, ErrDBLocalUserExists
+// is returned if the username or the email is taken by another local user
+func (db *DB) CreateLocalUser(ctx context.Context, user *domain.User, cred *domain.LocalCredential) (int64, error) {
+	const (
+		userQuery = `
+			INSERT INTO users (name, email, avatar, provider)
+			VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING
+			RETURNING user_id
+		`
+		identityQuery = `
+			INSERT INTO user_identities (provider, subject, user_id, email, created_at)
+			VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING
+		`
+		// the credential of a local identity unlinked earlier is replaced
+		credentialQuery = `
+			INSERT INTO local_credentials (username, user_id, password_hash, updated_at)
+			VALUES ($1, $2, $3, $4) ON CONFLICT DO
+			UPDATE SET user_id = $2, password_hash = $3, updated_at = $4
+		`
+	)
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("db.CreateLocalUser: begin tx: %v", err)
+		return 0, domain.ErrDBQuery
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var userID int64
+	err = tx.QueryRowContext(ctx, userQuery, user.Name, user.Email, user.Avatar, domain.LocalProvider).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, domain.ErrDBLocalUserExists
+		}
+		log.Printf("db.CreateLocalUser: insert user: %v", err)
+		return 0, domain.ErrDBQuery
+	}
+
+	res, err := tx.ExecContext(ctx, identityQuery, domain.LocalProvider, cred.Username, userID, user.Email, cred.UpdatedAt.Unix())
+	if err != nil {
+		log.Printf("db.CreateLocalUser: insert identity: %v", err)
+		return 0, domain.ErrDBQuery
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, domain.ErrDBLocalUserExists
+	}
+
+	if _, err = tx.ExecContext(ctx, credentialQuery, cred.Username, userID, cred.PasswordHash, cred.UpdatedAt.Unix()); err != nil {
+		log.Printf("db.CreateLocalUser: insert credential: %v", err)
+		return 0, domain.ErrDBQuery
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("db.CreateLocalUser: commit tx: %v", err)
+		return 0, domain.ErrDBQuery
+	}
+
+	return userID, nil
+}
+
+func (db *DB) GetLocalCredential(ctx context.Context, username string) (*domain.LocalCredential, error) {
+	const query = localCredentialQuery + `
+		WHERE c.username = $1
+	`
+
+	cred, err := scanLocalCredential(db.conn.QueryRowContext(ctx, query, username))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrDBLocalUserNotFound
+		}
+		log.Printf("db.GetLocalCredential: %v", err)
+		return nil, domain.ErrDBQuery
+	}
+
+	return cred, nil
+}
+
+func (db *DB) GetUserLocalCredential(ctx context.Context, userID int64) (*domain.LocalCredential, error) {
+	const query = localCredentialQuery + `
+		WHERE c.user_id = $1
+	`
+
+	cred, err := scanLocalCredential(db.conn.QueryRowContext(ctx, query, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrDBLocalUserNotFound
+		}
+		log.Printf("db.GetUserLocalCredential: %v", err)
+		return nil, domain.ErrDBQuery
+	}
+
+	return cred, nil
+}
+
+// SetLocalPassword replaces the password of the local user
+func (db *DB) SetLocalPassword(ctx context.Context, username string, passwordHash string, at time.Time) error {
+	const query = `
+		UPDATE local_credentials
+		SET password_hash = $1, updated_at = $2
+		WHERE username = $3
+	`
+
+	res, err := db.conn.ExecContext(ctx, query, passwordHash, at.Unix(), username)
+	if err != nil {
+		log.Printf("db.SetLocalPassword: %v", err)
+		return domain.ErrDBQuery
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return domain.ErrDBLocalUserNotFound
+	}
+
+	return nil
+}
+
+func scanLocalCredential(row scanner) (*domain.LocalCredential, error) {
+	var (
+		cred      domain.LocalCredential
+		updatedAt int64
+	)
+	err := row.Scan(&cred.Username, &cred.UserID, &cred.PasswordHash, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	cred.UpdatedAt = time.Unix(updatedAt, 0)
+	return &cred, nil
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBLocalMethods(t *testing.T) {
+	db := setupTestDB(t)
+
+	ctx := context.Background()
+
+	now := time.Now().Truncate(time.Second)
+	user := &domain.User{Name: "Alice", Email: "alice@example.com"}
+	cred := &domain.LocalCredential{Username: "alice", PasswordHash: "hash1", UpdatedAt: now}
+	userID, err := db.CreateLocalUser(ctx, user, cred)
+	require.NoError(t, err)
+	cred.UserID = userID
+
+	got, err := db.GetLocalCredential(ctx, "alice")
+	require.NoError(t, err)
+	require.Equal(t, cred, got)
+
+	got, err = db.GetUserLocalCredential(ctx, userID)
+	require.NoError(t, err)
+	require.Equal(t, cred, got)
+
+	identity, err := db.GetIdentity(ctx, domain.LocalProvider, "alice")
+	require.NoError(t, err)
+	require.Equal(t, userID, identity.UserID)
+
+	// the username and the email are unique among the local users
+	_, err = db.CreateLocalUser(ctx, &domain.User{Name: "Alice", Email: "other@example.com"}, cred)
+	require.ErrorIs(t, err, domain.ErrDBLocalUserExists)
+	_, err = db.CreateLocalUser(ctx, user, &domain.LocalCredential{Username: "alice2", PasswordHash: "hash2", UpdatedAt: now})
+	require.ErrorIs(t, err, domain.ErrDBLocalUserExists)
+
+	// local emails are not verified so they are not auto-linked to, and local users have their identity
+	_, err = db.GetUserIDByEmail(ctx, "alice@example.com", "")
+	require.ErrorIs(t, err, domain.ErrDBUserNotFound)
+	_, err = db.GetUserIDByEmail(ctx, "alice@example.com", domain.LocalProvider)
+	require.ErrorIs(t, err, domain.ErrDBUserNotFound)
+
+	require.NoError(t, db.SetLocalPassword(ctx, "alice", "hash3", now.Add(time.Second)))
+	got, err = db.GetLocalCredential(ctx, "alice")
+	require.NoError(t, err)
+	require.Equal(t, "hash3", got.PasswordHash)
+	require.Equal(t, now.Add(time.Second), got.UpdatedAt)
+	require.ErrorIs(t, db.SetLocalPassword(ctx, "bob", "hash3", now), domain.ErrDBLocalUserNotFound)
+
+	// the password is ignored once the local identity is unlinked
+	require.NoError(t, db.CreateIdentity(ctx, &domain.Identity{Provider: "google", Subject: "1", UserID: userID, Email: "alice@example.com", CreatedAt: now}))
+	require.NoError(t, db.DeleteIdentity(ctx, userID, domain.LocalProvider))
+	_, err = db.GetLocalCredential(ctx, "alice")
+	require.ErrorIs(t, err, domain.ErrDBLocalUserNotFound)
+	_, err = db.GetUserLocalCredential(ctx, userID)
+	require.ErrorIs(t, err, domain.ErrDBLocalUserNotFound)
+}
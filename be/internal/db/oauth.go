@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+)
+
+// CreateOAuthState stores the login attempt, the attempts expired at now are dropped
+func (db *DB) CreateOAuthState(ctx context.Context, state *domain.OAuthState, now time.Time) error {
+	const deleteQuery = `DELETE FROM oauth_states WHERE expires_at <= $1`
+
+	_, err := db.conn.ExecContext(ctx, deleteQuery, now.Unix())
+	if err != nil {
+		log.Printf("db.CreateOAuthState: delete expired: %v", err)
+		return domain.ErrDBQuery
+	}
+
+	const query = `
+		INSERT INTO oauth_states (state, provider, verifier, user_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+
+	_, err = db.conn.ExecContext(ctx, query,
+		state.State,
+		state.Provider,
+		state.Verifier,
+		state.UserID,
+		state.ExpiresAt.Unix(),
+	)
+	if err != nil {
+		log.Printf("db.CreateOAuthState: %v", err)
+		return domain.ErrDBQuery
+	}
+
+	return nil
+}
+
+// ConsumeOAuthState deletes the login attempt and returns it unless it was expired at now,
+// so the same state is never accepted twice
+func (db *DB) ConsumeOAuthState(ctx context.Context, state string, now time.Time) (*domain.OAuthState, error) {
+	const query = `
+		DELETE FROM oauth_states
+		WHERE state = $1
+		RETURNING state, provider, verifier, user_id, expires_at
+	`
+
+	var (
+		s         domain.OAuthState
+		expiresAt int64
+	)
+	err := db.conn.QueryRowContext(ctx, query, state).Scan(&s.State, &s.Provider, &s.Verifier, &s.UserID, &expiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrDBOAuthStateNotFound
+		}
+		log.Printf("db.ConsumeOAuthState: %v", err)
+		return nil, domain.ErrDBQuery
+	}
+
+	s.ExpiresAt = time.Unix(expiresAt, 0)
+	if !now.Before(s.ExpiresAt) {
+		return nil, domain.ErrDBOAuthStateNotFound
+	}
+
+	return &s, nil
+}
@@ -0,0 +1,44 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBOAuthStateMethods(t *testing.T) {
+	db := setupTestDB(t)
+
+	ctx := context.Background()
+
+	now := time.Now().Truncate(time.Second)
+	state := &domain.OAuthState{
+		State:     "state1",
+		Provider:  "google",
+		Verifier:  "verifier1",
+		ExpiresAt: now.Add(time.Minute),
+	}
+	require.NoError(t, db.CreateOAuthState(ctx, state, now))
+
+	got, err := db.ConsumeOAuthState(ctx, "state1", now)
+	require.NoError(t, err)
+	require.Equal(t, state, got)
+
+	// the state is accepted once
+	_, err = db.ConsumeOAuthState(ctx, "state1", now)
+	require.ErrorIs(t, err, domain.ErrDBOAuthStateNotFound)
+
+	expired := &domain.OAuthState{State: "state2", Provider: "google", Verifier: "verifier2", ExpiresAt: now}
+	require.NoError(t, db.CreateOAuthState(ctx, expired, now.Add(-time.Minute)))
+	_, err = db.ConsumeOAuthState(ctx, "state2", now)
+	require.ErrorIs(t, err, domain.ErrDBOAuthStateNotFound)
+
+	// expired states are dropped when a new one is stored
+	require.NoError(t, db.CreateOAuthState(ctx, expired, now.Add(-time.Minute)))
+	require.NoError(t, db.CreateOAuthState(ctx, state, now))
+	_, err = db.ConsumeOAuthState(ctx, "state2", now.Add(-time.Minute))
+	require.ErrorIs(t, err, domain.ErrDBOAuthStateNotFound)
+}
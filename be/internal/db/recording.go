@@ -0,0 +1,164 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+)
+
+const recordingColumns = `
+	r.recording_id,
+	r.room_id,
+	r.user_id,
+	r.file,
+	r.content_type,
+	r.size,
+	r.created_at
+`
+
+func (db *DB) CreateRecording(ctx context.Context, rec *domain.Recording) error {
+	const query = `
+		INSERT INTO recordings (recording_id, room_id, user_id, file, content_type, size, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+
+	_, err := db.conn.ExecContext(ctx, query,
+		rec.RecordingID,
+		rec.RoomID,
+		rec.UserID,
+		rec.File,
+		rec.ContentType,
+		rec.Size,
+		rec.CreatedAt.Unix(),
+	)
+	if err != nil {
+		log.Printf("db.CreateRecording: %v", err)
+		return domain.ErrDBQuery
+	}
+
+	return nil
+}
+
+func (db *DB) GetRecording(ctx context.Context, recordingID string) (*domain.Recording, error) {
+	const query = `
+		SELECT` + recordingColumns + `
+		FROM recordings r
+		WHERE r.recording_id = $1
+	`
+
+	rec, err := scanRecording(db.conn.QueryRowContext(ctx, query, recordingID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrDBRecordingNotFound
+		}
+		log.Printf("db.GetRecording: %v", err)
+		return nil, domain.ErrDBQuery
+	}
+
+	return rec, nil
+}
+
+// GetUserRecordings returns the recordings the user uploaded, made in the rooms it owns
+// or made in the rooms it joined before
+func (db *DB) GetUserRecordings(ctx context.Context, userID int64) ([]domain.Recording, error) {
+	const query = `
+		SELECT` + recordingColumns + `
+		FROM recordings r
+		LEFT JOIN rooms ro ON ro.room_id = r.room_id
+		WHERE r.user_id = $1 OR ro.owner_id = $1 OR EXISTS (
+			SELECT 1
+			FROM room_members m
+			WHERE m.room_id = r.room_id AND m.user_id = $1 AND m.joined_at <= r.created_at
+		)
+		ORDER BY r.created_at DESC
+	`
+
+	return db.queryRecordings(ctx, "db.GetUserRecordings", query, userID)
+}
+
+// GetExpiredRecordings returns the recordings that outlived their room retention,
+// rooms without a retention of their own fall back to defaultRetention, 0 keeps them forever
+func (db *DB) GetExpiredRecordings(ctx context.Context, now time.Time, defaultRetention time.Duration) ([]domain.Recording, error) {
+	const query = `
+		SELECT` + recordingColumns + `
+		FROM recordings r
+		JOIN rooms ro ON ro.room_id = r.room_id
+		WHERE $1 > r.created_at + (CASE WHEN ro.retention > 0 THEN ro.retention ELSE $2 END)
+		AND (ro.retention > 0 OR $2 > 0)
+	`
+
+	return db.queryRecordings(ctx, "db.GetExpiredRecordings", query, now.Unix(), int64(defaultRetention.Seconds()))
+}
+
+func (db *DB) DeleteRecording(ctx context.Context, recordingID string) error {
+	const query = `DELETE FROM recordings WHERE recording_id = $1`
+
+	res, err := db.conn.ExecContext(ctx, query, recordingID)
+	if err != nil {
+		log.Printf("db.DeleteRecording: %v", err)
+		return domain.ErrDBQuery
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return domain.ErrDBRecordingNotFound
+	}
+
+	return nil
+}
+
+func (db *DB) queryRecordings(ctx context.Context, op string, query string, args ...any) ([]domain.Recording, error) {
+	rows, err := db.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Printf("%s: %v", op, err)
+		return nil, domain.ErrDBQuery
+	}
+	defer func() { _ = rows.Close() }()
+
+	recs := make([]domain.Recording, 0)
+	for rows.Next() {
+		rec, err := scanRecording(rows)
+		if err != nil {
+			log.Printf("%s: scan: %v", op, err)
+			return nil, domain.ErrDBQuery
+		}
+		recs = append(recs, *rec)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("%s: rows: %v", op, err)
+		return nil, domain.ErrDBQuery
+	}
+
+	return recs, nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRecording(row scanner) (*domain.Recording, error) {
+	var (
+		rec       domain.Recording
+		createdAt int64
+	)
+
+	err := row.Scan(
+		&rec.RecordingID,
+		&rec.RoomID,
+		&rec.UserID,
+		&rec.File,
+		&rec.ContentType,
+		&rec.Size,
+		&createdAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	rec.CreatedAt = time.Unix(createdAt, 0)
+	return &rec, nil
+}
@@ -0,0 +1,165 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBRecordingMethods(t *testing.T) {
+	db := setupTestDB(t)
+
+	ctx := context.Background()
+
+	ownerID, err := db.CreateUser(ctx, &domain.User{Name: "Owner", Email: "owner@example.com"}, "google")
+	require.NoError(t, err)
+
+	guestID, err := db.CreateUser(ctx, &domain.User{Name: "Guest", Email: "guest@example.com"}, "google")
+	require.NoError(t, err)
+
+	strangerID, err := db.CreateUser(ctx, &domain.User{Name: "Stranger", Email: "stranger@example.com"}, "google")
+	require.NoError(t, err)
+
+	roomID := uuid.NewString()
+	require.NoError(t, db.AddRoomMember(ctx, roomID, ownerID))
+	require.NoError(t, db.AddRoomMember(ctx, roomID, guestID))
+
+	now := time.Now().Truncate(time.Second)
+	rec := &domain.Recording{
+		RecordingID: uuid.NewString(),
+		RoomID:      roomID,
+		UserID:      guestID,
+		File:        "recording.webm",
+		ContentType: "video/webm",
+		Size:        1024,
+		CreatedAt:   now.Add(-2 * time.Hour),
+	}
+	require.NoError(t, db.CreateRecording(ctx, rec))
+
+	tests := []struct {
+		name string
+		test func(t *testing.T)
+	}{
+		{
+			name: "first_member_owns_room",
+			test: func(t *testing.T) {
+				room, err := db.GetRoom(ctx, roomID)
+				require.NoError(t, err)
+				require.Equal(t, ownerID, room.OwnerID)
+				require.Zero(t, room.Retention)
+			},
+		},
+		{
+			name: "room_membership",
+			test: func(t *testing.T) {
+				ok, err := db.IsRoomMember(ctx, roomID, guestID)
+				require.NoError(t, err)
+				require.True(t, ok)
+
+				ok, err = db.IsRoomMember(ctx, roomID, strangerID)
+				require.NoError(t, err)
+				require.False(t, ok)
+
+				// the members attended what happened in the room since they first joined it
+				ok, err = db.IsRoomAttendee(ctx, roomID, guestID, now.Add(time.Minute))
+				require.NoError(t, err)
+				require.True(t, ok)
+
+				ok, err = db.IsRoomAttendee(ctx, roomID, guestID, rec.CreatedAt)
+				require.NoError(t, err)
+				require.False(t, ok)
+			},
+		},
+		{
+			name: "get_recording",
+			test: func(t *testing.T) {
+				got, err := db.GetRecording(ctx, rec.RecordingID)
+				require.NoError(t, err)
+				require.Equal(t, rec, got)
+
+				_, err = db.GetRecording(ctx, uuid.NewString())
+				require.ErrorIs(t, err, domain.ErrDBRecordingNotFound)
+			},
+		},
+		{
+			name: "get_user_recordings",
+			test: func(t *testing.T) {
+				for _, userID := range []int64{ownerID, guestID} {
+					recs, err := db.GetUserRecordings(ctx, userID)
+					require.NoError(t, err)
+					require.Len(t, recs, 1)
+				}
+
+				// joining the room does not give access to the recordings made before
+				require.NoError(t, db.AddRoomMember(ctx, roomID, strangerID))
+				recs, err := db.GetUserRecordings(ctx, strangerID)
+				require.NoError(t, err)
+				require.Empty(t, recs)
+
+				// the attendees get the recordings made since they joined
+				later := &domain.Recording{
+					RecordingID: uuid.NewString(),
+					RoomID:      roomID,
+					UserID:      ownerID,
+					File:        "later.webm",
+					ContentType: "video/webm",
+					Size:        1024,
+					CreatedAt:   now.Add(time.Minute),
+				}
+				require.NoError(t, db.CreateRecording(ctx, later))
+				defer func() { require.NoError(t, db.DeleteRecording(ctx, later.RecordingID)) }()
+
+				recs, err = db.GetUserRecordings(ctx, strangerID)
+				require.NoError(t, err)
+				require.Equal(t, []domain.Recording{*later}, recs)
+
+				recs, err = db.GetUserRecordings(ctx, guestID)
+				require.NoError(t, err)
+				require.Equal(t, []domain.Recording{*later, *rec}, recs)
+			},
+		},
+		{
+			name: "get_expired_recordings",
+			test: func(t *testing.T) {
+				// default retention applies while the room has none
+				recs, err := db.GetExpiredRecordings(ctx, now, 3*time.Hour)
+				require.NoError(t, err)
+				require.Empty(t, recs)
+
+				recs, err = db.GetExpiredRecordings(ctx, now, time.Hour)
+				require.NoError(t, err)
+				require.Len(t, recs, 1)
+
+				// no default retention keeps the recordings of rooms without one forever
+				recs, err = db.GetExpiredRecordings(ctx, now, 0)
+				require.NoError(t, err)
+				require.Empty(t, recs)
+
+				// room retention overrides the default one
+				require.NoError(t, db.SetRoomRetention(ctx, roomID, 3*time.Hour))
+				recs, err = db.GetExpiredRecordings(ctx, now, time.Hour)
+				require.NoError(t, err)
+				require.Empty(t, recs)
+
+				recs, err = db.GetExpiredRecordings(ctx, now.Add(2*time.Hour), 0)
+				require.NoError(t, err)
+				require.Len(t, recs, 1)
+			},
+		},
+		{
+			name: "delete_recording",
+			test: func(t *testing.T) {
+				require.NoError(t, db.DeleteRecording(ctx, rec.RecordingID))
+				require.ErrorIs(t, db.DeleteRecording(ctx, rec.RecordingID), domain.ErrDBRecordingNotFound)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, tt.test)
+	}
+}
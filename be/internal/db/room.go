@@ -0,0 +1,144 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+)
+
+// AddRoomMember registers the user as a member of the room, rejoining keeps the first join time,
+// the first user to ever join a room becomes its owner
+func (db *DB) AddRoomMember(ctx context.Context, roomID string, userID int64) error {
+	const (
+		roomQuery = `
+			INSERT INTO rooms (room_id, owner_id, created_at)
+			VALUES ($1, $2, $3) ON CONFLICT DO NOTHING
+		`
+		memberQuery = `
+			INSERT INTO room_members (room_id, user_id, joined_at)
+			VALUES ($1, $2, $3) ON CONFLICT DO NOTHING
+		`
+	)
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("db.AddRoomMember: begin tx: %v", err)
+		return domain.ErrDBQuery
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	now := time.Now().Unix()
+
+	if _, err = tx.ExecContext(ctx, roomQuery, roomID, userID, now); err != nil {
+		log.Printf("db.AddRoomMember: insert room: %v", err)
+		return domain.ErrDBQuery
+	}
+
+	if _, err = tx.ExecContext(ctx, memberQuery, roomID, userID, now); err != nil {
+		log.Printf("db.AddRoomMember: insert member: %v", err)
+		return domain.ErrDBQuery
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("db.AddRoomMember: commit tx: %v", err)
+		return domain.ErrDBQuery
+	}
+
+	return nil
+}
+
+func (db *DB) GetRoom(ctx context.Context, roomID string) (*domain.Room, error) {
+	const query = `
+		SELECT room_id,
+		       owner_id,
+		       retention,
+		       created_at
+		FROM rooms
+		WHERE room_id = $1
+	`
+
+	row := db.conn.QueryRowContext(ctx, query, roomID)
+
+	var (
+		res                  domain.Room
+		retention, createdAt int64
+	)
+	err := row.Scan(&res.RoomID, &res.OwnerID, &retention, &createdAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrDBRoomNotFound
+		}
+		log.Printf("db.GetRoom: %v", err)
+		return nil, domain.ErrDBQuery
+	}
+
+	res.Retention = time.Duration(retention) * time.Second
+	res.CreatedAt = time.Unix(createdAt, 0)
+
+	return &res, nil
+}
+
+func (db *DB) IsRoomMember(ctx context.Context, roomID string, userID int64) (bool, error) {
+	const query = `
+		SELECT EXISTS (
+			SELECT 1
+			FROM room_members
+			WHERE room_id = $1 AND user_id = $2
+		)
+	`
+
+	row := db.conn.QueryRowContext(ctx, query, roomID, userID)
+
+	var exists bool
+	if err := row.Scan(&exists); err != nil {
+		log.Printf("db.IsRoomMember: %v", err)
+		return false, domain.ErrDBQuery
+	}
+
+	return exists, nil
+}
+
+// IsRoomAttendee reports whether the user joined the room at or before at
+func (db *DB) IsRoomAttendee(ctx context.Context, roomID string, userID int64, at time.Time) (bool, error) {
+	const query = `
+		SELECT EXISTS (
+			SELECT 1
+			FROM room_members
+			WHERE room_id = $1 AND user_id = $2 AND joined_at <= $3
+		)
+	`
+
+	row := db.conn.QueryRowContext(ctx, query, roomID, userID, at.Unix())
+
+	var exists bool
+	if err := row.Scan(&exists); err != nil {
+		log.Printf("db.IsRoomAttendee: %v", err)
+		return false, domain.ErrDBQuery
+	}
+
+	return exists, nil
+}
+
+func (db *DB) SetRoomRetention(ctx context.Context, roomID string, retention time.Duration) error {
+	const query = `
+		UPDATE rooms
+		SET retention = $1
+		WHERE room_id = $2
+	`
+
+	res, err := db.conn.ExecContext(ctx, query, int64(retention.Seconds()), roomID)
+	if err != nil {
+		log.Printf("db.SetRoomRetention: %v", err)
+		return domain.ErrDBQuery
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return domain.ErrDBRoomNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,245 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+)
+
+const sessionColumns = `
+	session_id,
+	user_id,
+	token_id,
+	token_hash,
+	prev_token_id,
+	created_at,
+	rotated_at,
+	expires_at,
+	revoked_at,
+	last_used_at,
+	user_agent,
+	ip
+`
+
+func (db *DB) CreateSession(ctx context.Context, session *domain.Session) error {
+	const query = `
+		INSERT INTO sessions (session_id, user_id, token_id, token_hash, created_at, rotated_at, expires_at, last_used_at, user_agent, ip)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+
+	_, err := db.conn.ExecContext(ctx, query,
+		session.SessionID,
+		session.UserID,
+		session.TokenID,
+		session.TokenHash,
+		session.CreatedAt.Unix(),
+		session.RotatedAt.Unix(),
+		session.ExpiresAt.Unix(),
+		session.LastUsedAt.Unix(),
+		session.UserAgent,
+		session.IP,
+	)
+	if err != nil {
+		log.Printf("db.CreateSession: %v", err)
+		return domain.ErrDBQuery
+	}
+
+	return nil
+}
+
+func (db *DB) GetSession(ctx context.Context, sessionID string) (*domain.Session, error) {
+	const query = `
+		SELECT` + sessionColumns + `
+		FROM sessions
+		WHERE session_id = $1
+	`
+
+	session, err := scanSession(db.conn.QueryRowContext(ctx, query, sessionID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrDBSessionNotFound
+		}
+		log.Printf("db.GetSession: %v", err)
+		return nil, domain.ErrDBQuery
+	}
+
+	return session, nil
+}
+
+// GetUserSessions returns the sessions of the user neither revoked nor expired at now
+func (db *DB) GetUserSessions(ctx context.Context, userID int64, now time.Time) ([]domain.Session, error) {
+	const query = `
+		SELECT` + sessionColumns + `
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at = 0 AND expires_at > $2
+		ORDER BY last_used_at DESC
+	`
+
+	rows, err := db.conn.QueryContext(ctx, query, userID, now.Unix())
+	if err != nil {
+		log.Printf("db.GetUserSessions: %v", err)
+		return nil, domain.ErrDBQuery
+	}
+	defer func() { _ = rows.Close() }()
+
+	sessions := make([]domain.Session, 0)
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			log.Printf("db.GetUserSessions: scan: %v", err)
+			return nil, domain.ErrDBQuery
+		}
+		sessions = append(sessions, *session)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("db.GetUserSessions: rows: %v", err)
+		return nil, domain.ErrDBQuery
+	}
+
+	return sessions, nil
+}
+
+// RotateSession replaces the refresh token of the session, session.PrevTokenID must be
+// the current refresh token so a concurrent rotation of the same token fails
+func (db *DB) RotateSession(ctx context.Context, session *domain.Session) error {
+	const query = `
+		UPDATE sessions
+		SET token_id = $1, token_hash = $2, prev_token_id = $3, rotated_at = $4, expires_at = $5, last_used_at = $4, ip = $6
+		WHERE session_id = $7 AND token_id = $3 AND revoked_at = 0
+	`
+
+	res, err := db.conn.ExecContext(ctx, query,
+		session.TokenID,
+		session.TokenHash,
+		session.PrevTokenID,
+		session.RotatedAt.Unix(),
+		session.ExpiresAt.Unix(),
+		session.IP,
+		session.SessionID,
+	)
+	if err != nil {
+		log.Printf("db.RotateSession: %v", err)
+		return domain.ErrDBQuery
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return domain.ErrDBSessionNotFound
+	}
+
+	return nil
+}
+
+// TouchSession records the last use of the session
+func (db *DB) TouchSession(ctx context.Context, sessionID string, ip string, at time.Time) error {
+	const query = `
+		UPDATE sessions
+		SET last_used_at = $1, ip = $2
+		WHERE session_id = $3
+	`
+
+	res, err := db.conn.ExecContext(ctx, query, at.Unix(), ip, sessionID)
+	if err != nil {
+		log.Printf("db.TouchSession: %v", err)
+		return domain.ErrDBQuery
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return domain.ErrDBSessionNotFound
+	}
+
+	return nil
+}
+
+// RevokeSession revokes the active session of the user
+func (db *DB) RevokeSession(ctx context.Context, userID int64, sessionID string, at time.Time) error {
+	const query = `
+		UPDATE sessions
+		SET revoked_at = $1
+		WHERE session_id = $2 AND user_id = $3 AND revoked_at = 0
+	`
+
+	res, err := db.conn.ExecContext(ctx, query, at.Unix(), sessionID, userID)
+	if err != nil {
+		log.Printf("db.RevokeSession: %v", err)
+		return domain.ErrDBQuery
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return domain.ErrDBSessionNotFound
+	}
+
+	return nil
+}
+
+// RevokeUserSessions revokes the active sessions of the user but the kept one, it returns the revoked sessions
+func (db *DB) RevokeUserSessions(ctx context.Context, userID int64, keepSessionID string, at time.Time) ([]string, error) {
+	const query = `
+		UPDATE sessions
+		SET revoked_at = $1
+		WHERE user_id = $2 AND session_id != $3 AND revoked_at = 0
+		RETURNING session_id
+	`
+
+	rows, err := db.conn.QueryContext(ctx, query, at.Unix(), userID, keepSessionID)
+	if err != nil {
+		log.Printf("db.RevokeUserSessions: %v", err)
+		return nil, domain.ErrDBQuery
+	}
+	defer func() { _ = rows.Close() }()
+
+	sessionIDs := make([]string, 0)
+	for rows.Next() {
+		var sessionID string
+		if err := rows.Scan(&sessionID); err != nil {
+			log.Printf("db.RevokeUserSessions: scan: %v", err)
+			return nil, domain.ErrDBQuery
+		}
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("db.RevokeUserSessions: rows: %v", err)
+		return nil, domain.ErrDBQuery
+	}
+
+	return sessionIDs, nil
+}
+
+func scanSession(row scanner) (*domain.Session, error) {
+	var (
+		session                                                domain.Session
+		createdAt, rotatedAt, expiresAt, revokedAt, lastUsedAt int64
+	)
+
+	err := row.Scan(
+		&session.SessionID,
+		&session.UserID,
+		&session.TokenID,
+		&session.TokenHash,
+		&session.PrevTokenID,
+		&createdAt,
+		&rotatedAt,
+		&expiresAt,
+		&revokedAt,
+		&lastUsedAt,
+		&session.UserAgent,
+		&session.IP,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	session.CreatedAt = time.Unix(createdAt, 0)
+	session.RotatedAt = time.Unix(rotatedAt, 0)
+	session.ExpiresAt = time.Unix(expiresAt, 0)
+	session.LastUsedAt = time.Unix(lastUsedAt, 0)
+	if revokedAt > 0 {
+		session.RevokedAt = time.Unix(revokedAt, 0)
+	}
+	return &session, nil
+}
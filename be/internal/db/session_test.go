@@ -0,0 +1,134 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBSessionMethods(t *testing.T) {
+	db := setupTestDB(t)
+
+	ctx := context.Background()
+
+	userID, err := db.CreateUser(ctx, &domain.User{Name: "User", Email: "user@example.com"}, "google")
+	require.NoError(t, err)
+
+	now := time.Now().Truncate(time.Second)
+	session := &domain.Session{
+		SessionID:  uuid.NewString(),
+		UserID:     userID,
+		TokenID:    "token1",
+		TokenHash:  "hash1",
+		CreatedAt:  now,
+		RotatedAt:  now,
+		ExpiresAt:  now.Add(time.Hour),
+		LastUsedAt: now,
+		Device:     domain.Device{UserAgent: "Firefox", IP: "10.0.0.1"},
+	}
+	require.NoError(t, db.CreateSession(ctx, session))
+
+	got, err := db.GetSession(ctx, session.SessionID)
+	require.NoError(t, err)
+	require.Equal(t, session, got)
+
+	rotated := &domain.Session{
+		SessionID:   session.SessionID,
+		TokenID:     "token2",
+		TokenHash:   "hash2",
+		PrevTokenID: "token1",
+		RotatedAt:   now.Add(time.Minute),
+		ExpiresAt:   now.Add(2 * time.Hour),
+		Device:      domain.Device{IP: "10.0.0.2"},
+	}
+	require.NoError(t, db.RotateSession(ctx, rotated))
+
+	// the token was already rotated
+	require.ErrorIs(t, db.RotateSession(ctx, rotated), domain.ErrDBSessionNotFound)
+
+	got, err = db.GetSession(ctx, session.SessionID)
+	require.NoError(t, err)
+	require.Equal(t, "token2", got.TokenID)
+	require.Equal(t, "hash2", got.TokenHash)
+	require.Equal(t, "token1", got.PrevTokenID)
+	require.Equal(t, now.Add(time.Minute), got.RotatedAt)
+	require.Equal(t, now.Add(2*time.Hour), got.ExpiresAt)
+	require.Equal(t, now.Add(time.Minute), got.LastUsedAt)
+	require.Equal(t, domain.Device{UserAgent: "Firefox", IP: "10.0.0.2"}, got.Device)
+	require.True(t, got.RevokedAt.IsZero())
+
+	require.NoError(t, db.TouchSession(ctx, session.SessionID, "10.0.0.3", now.Add(2*time.Minute)))
+	got, err = db.GetSession(ctx, session.SessionID)
+	require.NoError(t, err)
+	require.Equal(t, now.Add(2*time.Minute), got.LastUsedAt)
+	require.Equal(t, "10.0.0.3", got.IP)
+
+	// only the owner revokes the session
+	require.ErrorIs(t, db.RevokeSession(ctx, userID+1, session.SessionID, now), domain.ErrDBSessionNotFound)
+	require.NoError(t, db.RevokeSession(ctx, userID, session.SessionID, now))
+	require.ErrorIs(t, db.RevokeSession(ctx, userID, session.SessionID, now), domain.ErrDBSessionNotFound)
+
+	got, err = db.GetSession(ctx, session.SessionID)
+	require.NoError(t, err)
+	require.Equal(t, now, got.RevokedAt)
+
+	// revoked sessions are not rotated
+	rotated.TokenID, rotated.PrevTokenID = "token3", "token2"
+	require.ErrorIs(t, db.RotateSession(ctx, rotated), domain.ErrDBSessionNotFound)
+
+	_, err = db.GetSession(ctx, uuid.NewString())
+	require.ErrorIs(t, err, domain.ErrDBSessionNotFound)
+}
+
+func TestDBUserSessions(t *testing.T) {
+	db := setupTestDB(t)
+
+	ctx := context.Background()
+
+	userID, err := db.CreateUser(ctx, &domain.User{Name: "User", Email: "user@example.com"}, "google")
+	require.NoError(t, err)
+
+	now := time.Now().Truncate(time.Second)
+	createSession := func(userID int64, lastUsedAt time.Time, expiresAt time.Time) string {
+		session := &domain.Session{
+			SessionID:  uuid.NewString(),
+			UserID:     userID,
+			TokenID:    uuid.NewString(),
+			CreatedAt:  now,
+			RotatedAt:  now,
+			ExpiresAt:  expiresAt,
+			LastUsedAt: lastUsedAt,
+		}
+		require.NoError(t, db.CreateSession(ctx, session))
+		return session.SessionID
+	}
+
+	current := createSession(userID, now, now.Add(time.Hour))
+	other := createSession(userID, now.Add(-time.Minute), now.Add(time.Hour))
+	createSession(userID, now, now.Add(-time.Minute)) // expired
+	createSession(userID+1, now, now.Add(time.Hour))  // another user
+
+	sessions, err := db.GetUserSessions(ctx, userID, now)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	require.Equal(t, current, sessions[0].SessionID) // most recently used first
+	require.Equal(t, other, sessions[1].SessionID)
+
+	revoked, err := db.RevokeUserSessions(ctx, userID, current, now)
+	require.NoError(t, err)
+	require.Len(t, revoked, 2) // the expired session is revoked too
+	require.Contains(t, revoked, other)
+
+	sessions, err = db.GetUserSessions(ctx, userID, now)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	require.Equal(t, current, sessions[0].SessionID)
+
+	revoked, err = db.RevokeUserSessions(ctx, userID, current, now)
+	require.NoError(t, err)
+	require.Empty(t, revoked)
+}
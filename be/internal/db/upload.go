@@ -0,0 +1,227 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+)
+
+const uploadColumns = `
+	upload_id,
+	room_id,
+	user_id,
+	file,
+	content_type,
+	length,
+	received,
+	created_at,
+	updated_at
+`
+
+func (db *DB) CreateUpload(ctx context.Context, upload *domain.Upload) error {
+	const query = `
+		INSERT INTO uploads (upload_id, room_id, user_id, file, content_type, length, received, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+
+	_, err := db.conn.ExecContext(ctx, query,
+		upload.UploadID,
+		upload.RoomID,
+		upload.UserID,
+		upload.File,
+		upload.ContentType,
+		upload.Length,
+		upload.Offset,
+		upload.CreatedAt.Unix(),
+		upload.UpdatedAt.Unix(),
+	)
+	if err != nil {
+		log.Printf("db.CreateUpload: %v", err)
+		return domain.ErrDBQuery
+	}
+
+	return nil
+}
+
+func (db *DB) GetUpload(ctx context.Context, uploadID string) (*domain.Upload, error) {
+	const query = `
+		SELECT` + uploadColumns + `
+		FROM uploads
+		WHERE upload_id = $1
+	`
+
+	upload, err := scanUpload(db.conn.QueryRowContext(ctx, query, uploadID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrDBUploadNotFound
+		}
+		log.Printf("db.GetUpload: %v", err)
+		return nil, domain.ErrDBQuery
+	}
+
+	return upload, nil
+}
+
+// GetUserUploads returns the unfinished uploads of the user
+func (db *DB) GetUserUploads(ctx context.Context, userID int64) ([]domain.Upload, error) {
+	const query = `
+		SELECT` + uploadColumns + `
+		FROM uploads
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`
+
+	return db.queryUploads(ctx, "db.GetUserUploads", query, userID)
+}
+
+// GetStaleUploads returns the uploads that did not receive any data since before
+func (db *DB) GetStaleUploads(ctx context.Context, before time.Time) ([]domain.Upload, error) {
+	const query = `
+		SELECT` + uploadColumns + `
+		FROM uploads
+		WHERE updated_at < $1
+	`
+
+	return db.queryUploads(ctx, "db.GetStaleUploads", query, before.Unix())
+}
+
+func (db *DB) SetUploadOffset(ctx context.Context, uploadID string, offset int64) error {
+	const query = `
+		UPDATE uploads
+		SET received = $1, updated_at = $2
+		WHERE upload_id = $3
+	`
+
+	res, err := db.conn.ExecContext(ctx, query, offset, time.Now().Unix(), uploadID)
+	if err != nil {
+		log.Printf("db.SetUploadOffset: %v", err)
+		return domain.ErrDBQuery
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return domain.ErrDBUploadNotFound
+	}
+
+	return nil
+}
+
+// CompleteUpload turns the upload into a recording
+func (db *DB) CompleteUpload(ctx context.Context, uploadID string, rec *domain.Recording) error {
+	const (
+		recordingQuery = `
+			INSERT INTO recordings (recording_id, room_id, user_id, file, content_type, size, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`
+		uploadQuery = `DELETE FROM uploads WHERE upload_id = $1`
+	)
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("db.CompleteUpload: begin tx: %v", err)
+		return domain.ErrDBQuery
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.ExecContext(ctx, recordingQuery,
+		rec.RecordingID,
+		rec.RoomID,
+		rec.UserID,
+		rec.File,
+		rec.ContentType,
+		rec.Size,
+		rec.CreatedAt.Unix(),
+	)
+	if err != nil {
+		log.Printf("db.CompleteUpload: insert recording: %v", err)
+		return domain.ErrDBQuery
+	}
+
+	res, err := tx.ExecContext(ctx, uploadQuery, uploadID)
+	if err != nil {
+		log.Printf("db.CompleteUpload: delete upload: %v", err)
+		return domain.ErrDBQuery
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return domain.ErrDBUploadNotFound
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("db.CompleteUpload: commit tx: %v", err)
+		return domain.ErrDBQuery
+	}
+
+	return nil
+}
+
+func (db *DB) DeleteUpload(ctx context.Context, uploadID string) error {
+	const query = `DELETE FROM uploads WHERE upload_id = $1`
+
+	res, err := db.conn.ExecContext(ctx, query, uploadID)
+	if err != nil {
+		log.Printf("db.DeleteUpload: %v", err)
+		return domain.ErrDBQuery
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return domain.ErrDBUploadNotFound
+	}
+
+	return nil
+}
+
+func (db *DB) queryUploads(ctx context.Context, op string, query string, args ...any) ([]domain.Upload, error) {
+	rows, err := db.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Printf("%s: %v", op, err)
+		return nil, domain.ErrDBQuery
+	}
+	defer func() { _ = rows.Close() }()
+
+	uploads := make([]domain.Upload, 0)
+	for rows.Next() {
+		upload, err := scanUpload(rows)
+		if err != nil {
+			log.Printf("%s: scan: %v", op, err)
+			return nil, domain.ErrDBQuery
+		}
+		uploads = append(uploads, *upload)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("%s: rows: %v", op, err)
+		return nil, domain.ErrDBQuery
+	}
+
+	return uploads, nil
+}
+
+func scanUpload(row scanner) (*domain.Upload, error) {
+	var (
+		upload               domain.Upload
+		createdAt, updatedAt int64
+	)
+
+	err := row.Scan(
+		&upload.UploadID,
+		&upload.RoomID,
+		&upload.UserID,
+		&upload.File,
+		&upload.ContentType,
+		&upload.Length,
+		&upload.Offset,
+		&createdAt,
+		&updatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	upload.CreatedAt = time.Unix(createdAt, 0)
+	upload.UpdatedAt = time.Unix(updatedAt, 0)
+	return &upload, nil
+}
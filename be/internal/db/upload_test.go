@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBUploadMethods(t *testing.T) {
+	db := setupTestDB(t)
+
+	ctx := context.Background()
+
+	userID, err := db.CreateUser(ctx, &domain.User{Name: "Uploader", Email: "uploader@example.com"}, "google")
+	require.NoError(t, err)
+
+	roomID := uuid.NewString()
+	require.NoError(t, db.AddRoomMember(ctx, roomID, userID))
+
+	now := time.Now().Truncate(time.Second)
+	uploadID := uuid.NewString()
+	upload := &domain.Upload{
+		UploadID:    uploadID,
+		RoomID:      roomID,
+		UserID:      userID,
+		File:        uploadID,
+		ContentType: "video/webm",
+		Length:      10,
+		CreatedAt:   now.Add(-time.Hour),
+		UpdatedAt:   now.Add(-time.Hour),
+	}
+	require.NoError(t, db.CreateUpload(ctx, upload))
+
+	// stale until it receives data
+	uploads, err := db.GetStaleUploads(ctx, now)
+	require.NoError(t, err)
+	require.Len(t, uploads, 1)
+
+	require.NoError(t, db.SetUploadOffset(ctx, uploadID, 4))
+
+	got, err := db.GetUpload(ctx, uploadID)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), got.Offset)
+
+	uploads, err = db.GetStaleUploads(ctx, now.Add(-time.Minute))
+	require.NoError(t, err)
+	require.Empty(t, uploads)
+
+	uploads, err = db.GetUserUploads(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, uploads, 1)
+
+	rec := &domain.Recording{
+		RecordingID: uploadID,
+		RoomID:      roomID,
+		UserID:      userID,
+		File:        uploadID,
+		ContentType: "video/webm",
+		Size:        10,
+		CreatedAt:   now,
+	}
+	require.NoError(t, db.CompleteUpload(ctx, uploadID, rec))
+
+	_, err = db.GetUpload(ctx, uploadID)
+	require.ErrorIs(t, err, domain.ErrDBUploadNotFound)
+
+	gotRec, err := db.GetRecording(ctx, uploadID)
+	require.NoError(t, err)
+	require.Equal(t, rec, gotRec)
+}
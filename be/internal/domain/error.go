@@ -5,19 +5,95 @@ import "errors"
 var (
 	ErrTokenInvalid = errors.New("token invalid")
 	ErrTokenExpired = errors.New("token expired")
+	ErrTokenReused  = errors.New("token reused")
+
+	ErrSessionRevoked = errors.New("session revoked")
+)
+
+var (
+	ErrAccessTokenInvalidScope = errors.New("access token invalid scope")
+	ErrAccessTokenInvalidTTL   = errors.New("access token invalid ttl")
 )
 
 var (
 	ErrOAuthUnsupportedProvider = errors.New("unsupported oauth provider")
 	ErrOAuthExchange            = errors.New("oauth exchange error")
 	ErrOAuthGetUserInfo         = errors.New("oauth get user info error")
+	ErrOAuthInvalidState        = errors.New("oauth invalid state")
+	ErrOAuthEmailNotVerified    = errors.New("oauth email not verified")
+)
+
+var (
+	ErrLocalInvalidCredentials = errors.New("local invalid username or password")
+	ErrLocalInvalidUsername    = errors.New("local invalid username")
+	ErrLocalWeakPassword       = errors.New("local weak password")
+	ErrLocalTooManyAttempts    = errors.New("local too many attempts")
 )
 
 var (
-	ErrDBUserNotFound = errors.New("user not found")
-	ErrDBQuery        = errors.New("database query error")
+	ErrIdentityProviderLinked = errors.New("identity provider already linked")
+	ErrIdentityLastLogin      = errors.New("identity last login")
+)
+
+var (
+	ErrDBUserNotFound        = errors.New("user not found")
+	ErrDBRoomNotFound        = errors.New("room not found")
+	ErrDBRecordingNotFound   = errors.New("recording not found")
+	ErrDBUploadNotFound      = errors.New("upload not found")
+	ErrDBSessionNotFound     = errors.New("session not found")
+	ErrDBOAuthStateNotFound  = errors.New("oauth state not found")
+	ErrDBIdentityNotFound    = errors.New("identity not found")
+	ErrDBIdentityExists      = errors.New("identity already exists")
+	ErrDBAccessTokenNotFound = errors.New("access token not found")
+	ErrDBLocalUserNotFound   = errors.New("local user not found")
+	ErrDBLocalUserExists     = errors.New("local user already exists")
+	ErrDBQuery               = errors.New("database query error")
 )
 
 var (
 	ErrRoomIDTokenMismatch = errors.New("room id and token mismatch")
+	ErrRoomAccessDenied    = errors.New("room access denied")
+
+	ErrRoomFull               = errors.New("room full")
+	ErrRoomTooManyConnections = errors.New("room too many connections")
+	ErrRoomCapacity           = errors.New("room capacity reached")
+)
+
+var (
+	ErrRecordingAccessDenied = errors.New("recording access denied")
+)
+
+var (
+	ErrUploadAccessDenied   = errors.New("upload access denied")
+	ErrUploadInvalidLength  = errors.New("upload invalid length")
+	ErrUploadOffsetMismatch = errors.New("upload offset mismatch")
+	ErrUploadChunkTooLarge  = errors.New("upload chunk too large")
+)
+
+var (
+	ErrStorageFileNotFound = errors.New("storage file not found")
+)
+
+var (
+	ErrStreamNotFound        = errors.New("stream not found")
+	ErrStreamClosed          = errors.New("stream closed")
+	ErrStreamMessageTooLarge = errors.New("stream message too large")
+)
+
+// ErrorCode identifies why a room event sent by a client was rejected
+type ErrorCode string
+
+const (
+	ErrCodeBadRequest     ErrorCode = "bad-request"     // the message is not valid json
+	ErrCodeUnknownEvent   ErrorCode = "unknown-event"   // the event type does not exist
+	ErrCodeUnauthorized   ErrorCode = "unauthorized"    // the client is not allowed to send the event
+	ErrCodeInvalidData    ErrorCode = "invalid-data"    // the event data does not match its schema
+	ErrCodeDeliveryFailed ErrorCode = "delivery-failed" // the target of the event is gone
+	ErrCodeUnsupported    ErrorCode = "unsupported"     // the event needs a capability that was not negotiated
+	ErrCodeRateLimited    ErrorCode = "rate-limited"    // the client sends events too fast
+
+	ErrCodeRoomFull           ErrorCode = "room-full"            // the room has no room for another connection
+	ErrCodeTooManyConnections ErrorCode = "too-many-connections" // the user has too many connections
+	ErrCodeCapacity           ErrorCode = "capacity"             // the server has no room for another room
+	ErrCodeSessionRevoked     ErrorCode = "session-revoked"      // the session the connection was opened from was revoked
 )
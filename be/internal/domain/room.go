@@ -0,0 +1,72 @@
+package domain
+
+import "time"
+
+type (
+	Room struct {
+		RoomID    string        `json:"room_id"`
+		OwnerID   int64         `json:"owner_id"`
+		Retention time.Duration `json:"retention"`
+		CreatedAt time.Time     `json:"created_at"`
+	}
+
+	ICEServer struct {
+		URLs       []string `json:"urls"`
+		Username   string   `json:"username,omitempty"`
+		Credential string   `json:"credential,omitempty"`
+	}
+
+	// Client describes the protocol spoken by a room client
+	Client struct {
+		Version      int      // protocol version, 0 for clients that do not declare one
+		Capabilities []string // optional protocol features supported by the client
+		ResumeID     string   // inner id of a previous connection to resume
+		SessionID    string   // session of the user the connection was opened from
+	}
+
+	// Conn is the connection of a client to a room, it is implemented by every signaling
+	// transport with the semantics and message types of a websocket connection
+	Conn interface {
+		ReadMessage() (messageType int, data []byte, err error)
+		WriteMessage(messageType int, data []byte) error
+		SetReadLimit(limit int64)
+		Subprotocol() string
+		Close() error
+	}
+
+	// RoomStats are the current counts of the rooms handled by the server
+	RoomStats struct {
+		ActiveRooms int            `json:"active_rooms"`
+		Connections int            `json:"connections"`
+		Users       int            `json:"users"`
+		Rooms       map[string]int `json:"rooms"` // connections per room
+	}
+
+	Recording struct {
+		RecordingID string    `json:"recording_id"`
+		RoomID      string    `json:"room_id"`
+		UserID      int64     `json:"user_id"`
+		File        string    `json:"-"`
+		ContentType string    `json:"content_type"`
+		Size        int64     `json:"size"`
+		CreatedAt   time.Time `json:"created_at"`
+	}
+
+	Upload struct {
+		UploadID    string    `json:"upload_id"`
+		RoomID      string    `json:"room_id"`
+		UserID      int64     `json:"user_id"`
+		File        string    `json:"-"`
+		ContentType string    `json:"content_type"`
+		Length      int64     `json:"length"`
+		Offset      int64     `json:"offset"`
+		CreatedAt   time.Time `json:"created_at"`
+		UpdatedAt   time.Time `json:"updated_at"`
+	}
+)
+
+// ProtocolVersion returns the protocol version spoken by the client,
+// clients that do not declare a version speak the first one
+func (c Client) ProtocolVersion() int {
+	return max(c.Version, 1)
+}
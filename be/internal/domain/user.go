@@ -1,5 +1,21 @@
 package domain
 
+import "time"
+
+// LocalProvider is the provider of the users logging in with a username and password
+const LocalProvider = "local"
+
+// scopes of the personal access tokens
+const (
+	ScopeRoomsRead       = "rooms:read"
+	ScopeRoomsWrite      = "rooms:write"
+	ScopeRecordingsRead  = "recordings:read"
+	ScopeRecordingsWrite = "recordings:write"
+)
+
+// Scopes are the scopes a personal access token can be granted
+var Scopes = []string{ScopeRoomsRead, ScopeRoomsWrite, ScopeRecordingsRead, ScopeRecordingsWrite}
+
 type (
 	User struct {
 		UserID int64  `json:"user_id"`
@@ -9,17 +25,101 @@ type (
 	}
 
 	UserTokenPayload struct {
-		UserID int64  `json:"user_id"`
-		Email  string `json:"email"`
+		UserID    int64  `json:"user_id"`
+		Email     string `json:"email"`
+		SessionID string `json:"session_id"`
+		TokenID   string `json:"token_id"` // jti of refresh tokens
 	}
 
 	RoomTokenPayload struct {
-		UserID int64  `json:"user_id"`
-		RoomID string `json:"room_id"`
+		UserID    int64  `json:"user_id"`
+		RoomID    string `json:"room_id"`
+		SessionID string `json:"session_id"`
+	}
+
+	// Session is a login of the user, the refresh tokens rotated from the same login share the session
+	Session struct {
+		SessionID   string    `json:"session_id"`
+		UserID      int64     `json:"user_id"`
+		TokenID     string    `json:"-"` // jti of the current refresh token
+		TokenHash   string    `json:"-"` // sha256 of the current refresh token
+		PrevTokenID string    `json:"-"` // jti of the refresh token replaced by the last rotation
+		CreatedAt   time.Time `json:"created_at"`
+		RotatedAt   time.Time `json:"rotated_at"`
+		ExpiresAt   time.Time `json:"expires_at"`
+		RevokedAt   time.Time `json:"revoked_at,omitzero"` // zero while the session is active
+		LastUsedAt  time.Time `json:"last_used_at"`
+		Device
+	}
+
+	// AccessToken is a personal access token the user automates the api with,
+	// the token is shown once on creation and only its hash is kept
+	AccessToken struct {
+		TokenID    string    `json:"token_id"`
+		UserID     int64     `json:"-"`
+		Name       string    `json:"name"`
+		TokenHash  string    `json:"-"` // sha256 of the token
+		Scopes     []string  `json:"scopes"`
+		CreatedAt  time.Time `json:"created_at"`
+		ExpiresAt  time.Time `json:"expires_at"`
+		LastUsedAt time.Time `json:"last_used_at,omitzero"` // zero until the token is used
+	}
+
+	// Device is the client a session is used from
+	Device struct {
+		UserAgent string `json:"user_agent"`
+		IP        string `json:"ip"`
+	}
+
+	// Identity is an account of the user at a login provider, the user logs in with any of its identities
+	Identity struct {
+		Provider  string    `json:"provider"`
+		Subject   string    `json:"-"` // id of the account at the provider
+		UserID    int64     `json:"-"`
+		Email     string    `json:"email"`
+		CreatedAt time.Time `json:"created_at"`
+
+		EmailVerified bool `json:"-"` // set on login when the provider verified the email, unverified emails are only displayed
+	}
+
+	// LocalCredential is the password of a local user, the username is the subject of its local identity
+	LocalCredential struct {
+		Username     string
+		UserID       int64
+		PasswordHash string // argon2id in the PHC string format
+		UpdatedAt    time.Time
+	}
+
+	// OAuthProvider is a login provider enabled on the server
+	OAuthProvider struct {
+		Name        string `json:"name"`
+		Kind        string `json:"kind"`
+		DisplayName string `json:"display_name"`
+	}
+
+	// OAuthState is a login attempt started from a browser, its callback completes it once
+	OAuthState struct {
+		State     string
+		Provider  string
+		Verifier  string // pkce code verifier of the authorization code
+		UserID    int64  // user linking the provider to its account, 0 for logins
+		ExpiresAt time.Time
 	}
 
 	Token struct {
 		Access  string `json:"access"`
 		Refresh string `json:"refresh"`
 	}
+
+	// JWK is a public key verifying the tokens signed by the server (RFC 7517)
+	JWK struct {
+		KeyType   string `json:"kty"`
+		KeyID     string `json:"kid"`
+		Use       string `json:"use"`
+		Algorithm string `json:"alg"`
+		N         string `json:"n,omitempty"`   // modulus of rsa keys
+		E         string `json:"e,omitempty"`   // exponent of rsa keys
+		Curve     string `json:"crv,omitempty"` // curve of okp keys
+		X         string `json:"x,omitempty"`   // public key of okp keys
+	}
 )
@@ -0,0 +1,137 @@
+package room
+
+import (
+	"errors"
+	"log"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/gorilla/websocket"
+)
+
+// close codes sent to connections refused by the hub, they mirror the http status
+// returned to the connections refused before the upgrade
+const (
+	closeRoomFull           = 4409
+	closeTooManyConnections = 4429
+	closeCapacity           = 4503
+)
+
+// Admit checks the user can join the room without exceeding the hub limits,
+// Handle enforces the limits again since the hub may fill up meanwhile
+func (h *Hub) Admit(userID int64, roomID string) error {
+	rs, us := h.roomShard(roomID), h.userShard(userID)
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	_, active := rs.participants[roomID]
+	if !active && h.cfg.MaxActiveRooms > 0 && h.activeRooms.Load() >= int64(h.cfg.MaxActiveRooms) {
+		return domain.ErrRoomCapacity
+	}
+	return h.checkAdmission(rs, us, userID, roomID)
+}
+
+// Stats returns the current counts of the rooms handled by the hub
+func (h *Hub) Stats() domain.RoomStats {
+	stats := domain.RoomStats{Rooms: make(map[string]int)}
+
+	for i := range h.rooms {
+		rs := &h.rooms[i]
+		rs.mutex.Lock()
+		for roomID, n := range rs.participants {
+			stats.ActiveRooms++
+			stats.Connections += n
+			stats.Rooms[roomID] = n
+		}
+		rs.mutex.Unlock()
+	}
+
+	for i := range h.users {
+		us := &h.users[i]
+		us.mutex.Lock()
+		stats.Users += len(us.connections)
+		us.mutex.Unlock()
+	}
+
+	return stats
+}
+
+// checkAdmission checks the limits of the room and the user, the shards must be locked
+func (h *Hub) checkAdmission(rs *roomShard, us *userShard, userID int64, roomID string) error {
+	switch {
+	case h.cfg.MaxParticipants > 0 && rs.participants[roomID] >= h.cfg.MaxParticipants:
+		return domain.ErrRoomFull
+	case h.cfg.MaxUserConnections > 0 && us.connections[userID] >= h.cfg.MaxUserConnections:
+		return domain.ErrRoomTooManyConnections
+	}
+	return nil
+}
+
+// admit counts the connection of the user to the room if the limits allow it,
+// the room shard is always locked before the user shard
+func (h *Hub) admit(userID int64, roomID string) error {
+	rs, us := h.roomShard(roomID), h.userShard(userID)
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	if err := h.checkAdmission(rs, us, userID, roomID); err != nil {
+		return err
+	}
+
+	if _, active := rs.participants[roomID]; !active && !h.activateRoom() {
+		return domain.ErrRoomCapacity
+	}
+
+	rs.participants[roomID]++
+	us.connections[userID]++
+	return nil
+}
+
+// activateRoom counts another active room if the limit allows it
+func (h *Hub) activateRoom() bool {
+	for {
+		n := h.activeRooms.Load()
+		if h.cfg.MaxActiveRooms > 0 && n >= int64(h.cfg.MaxActiveRooms) {
+			return false
+		}
+		if h.activeRooms.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+func (h *Hub) release(userID int64, roomID string) {
+	rs, us := h.roomShard(roomID), h.userShard(userID)
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	if rs.participants[roomID]--; rs.participants[roomID] <= 0 {
+		delete(rs.participants, roomID)
+		h.activeRooms.Add(-1)
+	}
+	if us.connections[userID]--; us.connections[userID] <= 0 {
+		delete(us.connections, userID)
+	}
+}
+
+// refuse closes the connection the hub has no room for
+func refuse(conn domain.Conn, err error) {
+	code, reason := closeCapacity, domain.ErrCodeCapacity
+	switch {
+	case errors.Is(err, domain.ErrRoomFull):
+		code, reason = closeRoomFull, domain.ErrCodeRoomFull
+	case errors.Is(err, domain.ErrRoomTooManyConnections):
+		code, reason = closeTooManyConnections, domain.ErrCodeTooManyConnections
+	}
+
+	msg := websocket.FormatCloseMessage(code, string(reason))
+	if err := conn.WriteMessage(websocket.CloseMessage, msg); err != nil {
+		log.Printf("hub: refuse connection: %v", err)
+	}
+	_ = conn.Close()
+}
@@ -0,0 +1,112 @@
+package room
+
+import (
+	"testing"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_Admission(t *testing.T) {
+	t.Parallel()
+
+	type join struct {
+		userID  int64
+		roomID  string
+		wantErr error
+	}
+
+	tests := []struct {
+		name  string
+		cfg   Config
+		joins []join
+	}{
+		{
+			name: "no_limits",
+			joins: []join{
+				{1, "room1", nil},
+				{1, "room1", nil},
+				{2, "room2", nil},
+			},
+		},
+		{
+			name: "room_full",
+			cfg:  Config{MaxParticipants: 2},
+			joins: []join{
+				{1, "room1", nil},
+				{2, "room1", nil},
+				{3, "room1", domain.ErrRoomFull},
+				{3, "room2", nil},
+			},
+		},
+		{
+			name: "too_many_connections",
+			cfg:  Config{MaxUserConnections: 2},
+			joins: []join{
+				{1, "room1", nil},
+				{1, "room2", nil},
+				{1, "room3", domain.ErrRoomTooManyConnections},
+				{2, "room3", nil},
+			},
+		},
+		{
+			name: "capacity",
+			cfg:  Config{MaxActiveRooms: 1},
+			joins: []join{
+				{1, "room1", nil},
+				{2, "room2", domain.ErrRoomCapacity},
+				{2, "room1", nil}, // active rooms accept connections
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			h := NewHub(tt.cfg)
+			for i, j := range tt.joins {
+				require.Equal(t, j.wantErr, h.Admit(j.userID, j.roomID), "join %d", i)
+				require.Equal(t, j.wantErr, h.admit(j.userID, j.roomID), "join %d", i)
+			}
+
+			for _, j := range tt.joins {
+				if j.wantErr == nil {
+					h.release(j.userID, j.roomID)
+				}
+			}
+			require.Equal(t, domain.RoomStats{Rooms: map[string]int{}}, h.Stats())
+		})
+	}
+}
+
+func TestHub_Stats(t *testing.T) {
+	t.Parallel()
+
+	h := NewHub(Config{})
+	require.NoError(t, h.admit(1, "room1"))
+	require.NoError(t, h.admit(2, "room1"))
+	require.NoError(t, h.admit(1, "room2"))
+
+	require.Equal(t, domain.RoomStats{
+		ActiveRooms: 2,
+		Connections: 3,
+		Users:       2,
+		Rooms:       map[string]int{"room1": 2, "room2": 1},
+	}, h.Stats())
+}
+
+func TestHub_HandleRefused(t *testing.T) {
+	t.Parallel()
+
+	h := NewHub(Config{MaxParticipants: 1})
+	require.NoError(t, h.admit(2, "room1"))
+
+	conn := newTestConn()
+	h.Handle(&domain.User{UserID: 1}, "room1", domain.Client{}, conn, nil)
+	require.True(t, conn.isClosed())
+	require.Equal(t, closeRoomFull, conn.code())
+
+	// the refused connection is not counted
+	require.Equal(t, domain.RoomStats{ActiveRooms: 1, Connections: 1, Users: 1, Rooms: map[string]int{"room1": 1}}, h.Stats())
+}
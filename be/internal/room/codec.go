@@ -0,0 +1,178 @@
+package room
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// codec encodes the messages exchanged with a client, it is selected
+// by the websocket subprotocol negotiated on the upgrade
+type codec interface {
+	subprotocol() string
+	messageType() int // websocket message type of the encoded messages
+	marshal(v any) ([]byte, error)
+	unmarshal(data []byte, v any) error
+	decodeMessage(data []byte) (clientMessage, error)
+	rawValue(data []byte) any // embeds data already encoded by the codec in a message
+}
+
+// codecs are the codecs supported by the server in order of preference, json is the
+// default one used when the client does not ask for a subprotocol
+var codecs = []codec{jsonCodec{}, msgpackCodec{}, cborCodec{}}
+
+func codecFor(subprotocol string) codec {
+	for _, c := range codecs {
+		if c.subprotocol() == subprotocol {
+			return c
+		}
+	}
+	return jsonCodec{}
+}
+
+// Subprotocols returns the websocket subprotocols of the supported codecs
+func (h *Hub) Subprotocols() []string {
+	return subprotocols()
+}
+
+func subprotocols() []string {
+	names := make([]string, 0, len(codecs))
+	for _, c := range codecs {
+		names = append(names, c.subprotocol())
+	}
+	return names
+}
+
+// rawData is the event data as encoded by the client, it is only decoded
+// by the room when needed and otherwise forwarded as is
+type rawData struct {
+	codec codec
+	data  []byte
+}
+
+// as returns the data ready to be encoded by c, data encoded by another codec is converted
+func (r rawData) as(c codec) (any, error) {
+	if len(r.data) == 0 {
+		return nil, nil
+	}
+
+	if r.codec == c {
+		return c.rawValue(r.data), nil
+	}
+
+	var v any
+	if err := r.codec.unmarshal(r.data, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// normalize returns the data with the json encoded string legacy json clients send unwrapped,
+// so the data is forwarded to the other clients as the object it holds
+//
+// Deprecated: the legacy form is still accepted until all clients send the data as an object
+func (r rawData) normalize() (rawData, error) {
+	if _, isJSON := r.codec.(jsonCodec); !isJSON {
+		return r, nil
+	}
+
+	data := bytes.TrimSpace(r.data)
+	if len(data) == 0 || data[0] != '"' {
+		return r, nil
+	}
+
+	var legacy string
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return rawData{}, err
+	}
+
+	if !json.Valid([]byte(legacy)) {
+		return rawData{}, errors.New("legacy data is not json")
+	}
+
+	return rawData{codec: r.codec, data: []byte(legacy)}, nil
+}
+
+// envelope is the client message with its data left encoded by the codec raw type R
+type envelope[R ~[]byte] struct {
+	ID   string    `json:"id,omitempty"`
+	Type eventType `json:"type"`
+	Data R         `json:"data,omitempty"`
+}
+
+func decodeEnvelope[R ~[]byte](c codec, data []byte) (clientMessage, error) {
+	var env envelope[R]
+	if err := c.unmarshal(data, &env); err != nil {
+		return clientMessage{}, err
+	}
+
+	return clientMessage{
+		ID:   env.ID,
+		Type: env.Type,
+		Data: rawData{codec: c, data: env.Data},
+	}, nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) subprotocol() string                { return "vego.json" }
+func (jsonCodec) messageType() int                   { return websocket.TextMessage }
+func (jsonCodec) marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) rawValue(data []byte) any           { return json.RawMessage(data) }
+
+func (c jsonCodec) decodeMessage(data []byte) (clientMessage, error) {
+	return decodeEnvelope[json.RawMessage](c, data)
+}
+
+// msgpackCodec encodes messages with MessagePack using the json field names
+type msgpackCodec struct{}
+
+func (msgpackCodec) subprotocol() string { return "vego.msgpack" }
+func (msgpackCodec) messageType() int    { return websocket.BinaryMessage }
+
+func (msgpackCodec) marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) unmarshal(data []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+func (msgpackCodec) rawValue(data []byte) any { return msgpack.RawMessage(data) }
+
+func (c msgpackCodec) decodeMessage(data []byte) (clientMessage, error) {
+	return decodeEnvelope[msgpack.RawMessage](c, data)
+}
+
+// cborCodec encodes messages with CBOR using the json field names
+type cborCodec struct{}
+
+var (
+	cborEncMode, _ = cbor.EncOptions{Time: cbor.TimeRFC3339Nano}.EncMode()
+	cborDecMode, _ = cbor.DecOptions{DefaultMapType: reflect.TypeOf(map[string]any(nil))}.DecMode()
+)
+
+func (cborCodec) subprotocol() string                { return "vego.cbor" }
+func (cborCodec) messageType() int                   { return websocket.BinaryMessage }
+func (cborCodec) marshal(v any) ([]byte, error)      { return cborEncMode.Marshal(v) }
+func (cborCodec) unmarshal(data []byte, v any) error { return cborDecMode.Unmarshal(data, v) }
+func (cborCodec) rawValue(data []byte) any           { return cbor.RawMessage(data) }
+
+func (c cborCodec) decodeMessage(data []byte) (clientMessage, error) {
+	return decodeEnvelope[cbor.RawMessage](c, data)
+}
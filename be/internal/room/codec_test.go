@@ -0,0 +1,106 @@
+package room
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func jsonData(data string) rawData {
+	return rawData{codec: jsonCodec{}, data: []byte(data)}
+}
+
+func encodedData(t *testing.T, c codec, v any) rawData {
+	data, err := c.marshal(v)
+	require.NoError(t, err)
+	return rawData{codec: c, data: data}
+}
+
+func TestCodecFor(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, jsonCodec{}, codecFor(""))
+	require.Equal(t, jsonCodec{}, codecFor("unknown"))
+	for _, c := range codecs {
+		require.Equal(t, c, codecFor(c.subprotocol()))
+	}
+}
+
+func TestCodec_Forward(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]any{
+		"id":   "1",
+		"type": "offer",
+		"data": map[string]any{
+			"to":      "peer",
+			"content": map[string]any{"type": "offer", "sdp": "v=0"},
+		},
+	}
+
+	for _, sender := range codecs {
+		for _, receiver := range codecs {
+			t.Run(sender.subprotocol()+"_to_"+receiver.subprotocol(), func(t *testing.T) {
+				t.Parallel()
+
+				encoded, err := sender.marshal(input)
+				require.NoError(t, err)
+
+				msg, err := sender.decodeMessage(encoded)
+				require.NoError(t, err)
+				require.Equal(t, "1", msg.ID)
+				require.Equal(t, eventOffer, msg.Type)
+
+				data, err := msg.Data.as(receiver)
+				require.NoError(t, err)
+
+				output, err := receiver.marshal(&baseMessage{Type: msg.Type, From: "sender", Data: data})
+				require.NoError(t, err)
+
+				var got struct {
+					Type eventType     `json:"type"`
+					From string        `json:"from"`
+					Data webRTCMessage `json:"data"`
+				}
+				require.NoError(t, receiver.unmarshal(output, &got))
+				require.Equal(t, eventOffer, got.Type)
+				require.Equal(t, "sender", got.From)
+				require.Equal(t, "peer", got.Data.To)
+				require.Equal(t, map[string]any{"type": "offer", "sdp": "v=0"}, got.Data.Content)
+			})
+		}
+	}
+}
+
+func TestRawData_Normalize(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		input   rawData
+		want    rawData
+		wantErr bool
+	}{
+		{"object_data", jsonData(`{"to":"peer"}`), jsonData(`{"to":"peer"}`), false},
+		{"legacy_string_data", jsonData(` "{\"to\":\"peer\"}"`), jsonData(`{"to":"peer"}`), false},
+		{"malformed_legacy_data", jsonData(`"{\"to\":"`), rawData{}, true},
+		{"legacy_string_not_json", jsonData(`"peer"`), rawData{}, true},
+		{"empty_data", jsonData(``), jsonData(``), false},
+		{"msgpack_string_data", encodedData(t, msgpackCodec{}, `{"to":"peer"}`), encodedData(t, msgpackCodec{}, `{"to":"peer"}`), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := tt.input.normalize()
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
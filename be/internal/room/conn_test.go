@@ -0,0 +1,130 @@
+package room
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// testConn is the connection of the room tests, the frames written to it are recorded.
+// Its reads return the client messages sent to in, without in they block until the
+// connection leaves or is closed
+type testConn struct {
+	subprotocol string
+	in          chan []byte     // client messages, reads fail once it is closed
+	frames      chan []byte     // receives the written frames when set
+	wg          *sync.WaitGroup // done once per written frame when set
+	discard     bool            // count the frames without recording them
+
+	joined    chan struct{} // closed once the first frame, the info event, is written
+	joinOnce  sync.Once
+	left      chan struct{} // closed by leave, reads end with a normal closure
+	leaveOnce sync.Once
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mutex     sync.Mutex
+	messages  [][]byte
+	prepared  []*websocket.PreparedMessage
+	closeCode int // of the close frame written to the connection
+}
+
+func newTestConn() *testConn {
+	return &testConn{
+		joined: make(chan struct{}),
+		left:   make(chan struct{}),
+		closed: make(chan struct{}),
+	}
+}
+
+func (c *testConn) ReadMessage() (int, []byte, error) {
+	select {
+	case data, ok := <-c.in:
+		if !ok {
+			return 0, nil, errors.New("connection closed")
+		}
+		return websocket.TextMessage, data, nil
+	case <-c.left:
+		return 0, nil, &websocket.CloseError{Code: websocket.CloseNormalClosure}
+	case <-c.closed:
+		return 0, nil, errors.New("connection closed")
+	}
+}
+
+func (c *testConn) WriteMessage(messageType int, data []byte) error {
+	if c.isClosed() {
+		return errors.New("connection closed")
+	}
+
+	c.mutex.Lock()
+	if messageType == websocket.CloseMessage {
+		c.closeCode = int(binary.BigEndian.Uint16(data))
+	}
+	if !c.discard {
+		c.messages = append(c.messages, data)
+	}
+	c.mutex.Unlock()
+
+	if c.frames != nil {
+		c.frames <- data
+	}
+	c.written()
+	return nil
+}
+
+func (c *testConn) SetReadLimit(int64) {}
+
+func (c *testConn) Subprotocol() string { return c.subprotocol }
+
+func (c *testConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *testConn) leave() {
+	c.leaveOnce.Do(func() { close(c.left) })
+}
+
+func (c *testConn) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *testConn) code() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.closeCode
+}
+
+func (c *testConn) written() {
+	c.joinOnce.Do(func() { close(c.joined) })
+	if c.wg != nil {
+		c.wg.Done()
+	}
+}
+
+// preparedConn is a testConn taking the prepared frames as the websocket connections do
+type preparedConn struct {
+	*testConn
+}
+
+func (c preparedConn) WritePreparedMessage(pm *websocket.PreparedMessage) error {
+	if c.isClosed() {
+		return errors.New("connection closed")
+	}
+
+	if !c.discard {
+		c.mutex.Lock()
+		c.prepared = append(c.prepared, pm)
+		c.mutex.Unlock()
+	}
+
+	c.written()
+	return nil
+}
@@ -1,64 +1,170 @@
 package room
 
 import (
+	"hash/fnv"
+	"log"
 	"maps"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/escalopa/vego/internal/domain"
-	"github.com/gorilla/websocket"
 )
 
-const hubCleanupInterval = 5 * time.Minute
+const hubShards = 64
 
-// Hub handles WebRTC signaling for multiple rooms
+// Config limits the rooms handled by the hub and what a single connection can send to its room
+type Config struct {
+	MaxParticipants    int // max connections per room, 0 means no limit
+	MaxUserConnections int // max connections per user across the rooms, 0 means no limit
+	MaxActiveRooms     int // max rooms with connections, 0 means no limit
+
+	IdleGracePeriod time.Duration // how long an empty room is kept before it is torn down
+
+	MaxMessageSize int64         // max size of a client message in bytes, 0 means no limit
+	ChatSlowMode   time.Duration // min interval between two chat messages of a connection
+
+	ChatRate      RateLimit
+	SignalingRate RateLimit
+
+	// MaxViolations is the number of rate limited events within ViolationWindow after which
+	// the connection is closed, 0 never closes it, violations never expire without a window
+	MaxViolations   int
+	ViolationWindow time.Duration
+}
+
+// Hub handles WebRTC signaling for multiple rooms, rooms and users are spread
+// over shards so joins to different rooms rarely wait on each other
 type Hub struct {
-	rooms map[string]*room
-	mutex sync.RWMutex
+	cfg         Config
+	rooms       [hubShards]roomShard
+	users       [hubShards]userShard
+	activeRooms atomic.Int64 // rooms with connections
+}
+
+type roomShard struct {
+	mutex        sync.Mutex
+	rooms        map[string]*room
+	participants map[string]int // connections per room
+}
+
+type userShard struct {
+	mutex       sync.Mutex
+	connections map[int64]int              // connections per user
+	sessions    map[string]map[*user]*room // live connections per session
 }
 
-// NewHub creates a new WebRTCHandler
-func NewHub() *Hub {
-	h := &Hub{rooms: make(map[string]*room)}
-	go h.cleanup()
+// NewHub creates a new WebRTCHandler, cfg limits the rooms and their connections
+func NewHub(cfg Config) *Hub {
+	h := &Hub{cfg: cfg}
+	for i := range hubShards {
+		h.rooms[i] = roomShard{rooms: make(map[string]*room), participants: make(map[string]int)}
+		h.users[i] = userShard{connections: make(map[int64]int), sessions: make(map[string]map[*user]*room)}
+	}
 	return h
 }
 
+func (h *Hub) roomShard(roomID string) *roomShard {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(roomID))
+	return &h.rooms[hash.Sum32()%hubShards]
+}
+
+func (h *Hub) userShard(userID int64) *userShard {
+	return &h.users[uint64(userID)%hubShards]
+}
+
 func (h *Hub) getOrCreateRoom(roomID string) *room {
-	h.mutex.Lock()
-	defer h.mutex.Unlock()
+	shard := h.roomShard(roomID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
 
-	r, ok := h.rooms[roomID]
+	r, ok := shard.rooms[roomID]
 	if !ok {
-		r = newRoom()
-		h.rooms[roomID] = r
+		r = newRoom(h.cfg, func() { h.removeRoom(roomID, r) })
+		shard.rooms[roomID] = r
 		go r.run()
 	}
 
 	return r
 }
 
-func (h *Hub) Handle(user *domain.User, roomID string, conn *websocket.Conn) {
-	r := h.getOrCreateRoom(roomID)
-	r.join(newUser(user, conn))
+// removeRoom is called by the room once it is idle, a room created
+// meanwhile under the same id is kept
+func (h *Hub) removeRoom(roomID string, r *room) {
+	shard := h.roomShard(roomID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	if shard.rooms[roomID] == r {
+		delete(shard.rooms, roomID)
+	}
 }
 
-func (h *Hub) cleanup() {
-	ticker := time.NewTicker(hubCleanupInterval)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		h.mutex.RLock()
-		rs := maps.Clone(h.rooms)
-		h.mutex.RUnlock()
-
-		for roomID, r := range rs {
-			if len(r.users) == 0 {
-				r.stop()
-				h.mutex.Lock()
-				delete(h.rooms, roomID)
-				h.mutex.Unlock()
-			}
+// Handle joins the user to the room speaking the protocol negotiated with the client,
+// the previous connection of the user to the room is resumed if the client asks for it
+func (h *Hub) Handle(user *domain.User, roomID string, client domain.Client, conn domain.Conn, iceServers []domain.ICEServer) {
+	if err := h.admit(user.UserID, roomID); err != nil {
+		log.Printf("hub: user %d cannot join room %s: %v", user.UserID, roomID, err)
+		refuse(conn, err)
+		return
+	}
+	defer h.release(user.UserID, roomID)
+
+	u := newUser(user, client, conn, iceServers)
+	defer h.untrack(u)
+
+	for {
+		// the room may be torn down between getting it and joining it
+		r := h.getOrCreateRoom(roomID)
+		h.track(u, r)
+		if r.join(u) {
+			return
 		}
 	}
 }
+
+// closeSessionRevoked is the websocket close code sent to the connections of a revoked session
+const closeSessionRevoked = 4401
+
+// DisconnectSession closes the connections the user opened from the session
+func (h *Hub) DisconnectSession(userID int64, sessionID string) {
+	us := h.userShard(userID)
+	us.mutex.Lock()
+	conns := maps.Clone(us.sessions[sessionID])
+	us.mutex.Unlock()
+
+	for u, r := range conns {
+		r.emit(baseMessage{
+			Type: eventKick,
+			Data: kick{user: u, code: closeSessionRevoked, reason: string(domain.ErrCodeSessionRevoked)},
+		})
+	}
+}
+
+// track records the room of the connection so it can be closed once its session is revoked
+func (h *Hub) track(u *user, r *room) {
+	if u.sessionID == "" {
+		return
+	}
+
+	us := h.userShard(u.userID)
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	if us.sessions[u.sessionID] == nil {
+		us.sessions[u.sessionID] = make(map[*user]*room)
+	}
+	us.sessions[u.sessionID][u] = r
+}
+
+func (h *Hub) untrack(u *user) {
+	us := h.userShard(u.userID)
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	delete(us.sessions[u.sessionID], u)
+	if len(us.sessions[u.sessionID]) == 0 {
+		delete(us.sessions, u.sessionID)
+	}
+}
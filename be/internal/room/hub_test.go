@@ -0,0 +1,176 @@
+package room
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/stretchr/testify/require"
+)
+
+// joinAll joins users to rooms concurrently and returns their connections once all joined,
+// done is closed once every connection left
+func joinAll(h *Hub, users, rooms int) (conns []*testConn, done chan struct{}) {
+	var wg sync.WaitGroup
+	conns = make([]*testConn, users)
+	for i := range users {
+		conns[i] = newTestConn()
+		conns[i].discard = true
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			user := &domain.User{UserID: int64(i)}
+			h.Handle(user, fmt.Sprintf("room-%d", i%rooms), domain.Client{}, conns[i], nil)
+		}()
+	}
+
+	for _, c := range conns {
+		<-c.joined
+	}
+
+	done = make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return conns, done
+}
+
+func (h *Hub) roomCount() int {
+	n := 0
+	for i := range h.rooms {
+		h.rooms[i].mutex.Lock()
+		n += len(h.rooms[i].rooms)
+		h.rooms[i].mutex.Unlock()
+	}
+	return n
+}
+
+func TestHub_ConcurrentJoins(t *testing.T) {
+	t.Parallel()
+
+	const users, rooms = 2000, 100
+
+	h := NewHub(Config{IdleGracePeriod: 10 * time.Millisecond})
+	conns, done := joinAll(h, users, rooms)
+
+	stats := h.Stats()
+	require.Equal(t, rooms, stats.ActiveRooms)
+	require.Equal(t, users, stats.Connections)
+	require.Equal(t, users, stats.Users)
+
+	for _, c := range conns {
+		c.leave()
+	}
+	<-done
+
+	require.Equal(t, domain.RoomStats{Rooms: map[string]int{}}, h.Stats())
+
+	// empty rooms are torn down once the grace period is over
+	require.Eventually(t, func() bool { return h.roomCount() == 0 }, time.Second, 5*time.Millisecond)
+}
+
+func TestHub_IdleGracePeriod(t *testing.T) {
+	t.Parallel()
+
+	h := NewHub(Config{IdleGracePeriod: 50 * time.Millisecond})
+
+	conns, done := joinAll(h, 1, 1)
+	r := h.getOrCreateRoom("room-0")
+
+	conns[0].leave()
+	<-done
+
+	// a user joining within the grace period joins the same room
+	conns, done = joinAll(h, 1, 1)
+	require.Same(t, r, h.getOrCreateRoom("room-0"))
+
+	time.Sleep(100 * time.Millisecond)
+	require.Same(t, r, h.getOrCreateRoom("room-0"), "room torn down while not empty")
+
+	conns[0].leave()
+	<-done
+
+	require.Eventually(t, func() bool { return h.roomCount() == 0 }, time.Second, 5*time.Millisecond)
+	select {
+	case <-r.done:
+	default:
+		t.Fatal("idle room not stopped")
+	}
+}
+
+func TestHub_JoinTornDownRoom(t *testing.T) {
+	t.Parallel()
+
+	// rooms are torn down right away so joins race with the teardown
+	h := NewHub(Config{})
+	for range 50 {
+		conns, done := joinAll(h, 20, 1)
+		for _, c := range conns {
+			c.leave()
+		}
+		<-done
+	}
+
+	require.Eventually(t, func() bool { return h.roomCount() == 0 }, time.Second, 5*time.Millisecond)
+}
+
+func TestHub_DisconnectSession(t *testing.T) {
+	t.Parallel()
+
+	h := NewHub(Config{})
+	user := &domain.User{UserID: 1}
+
+	type connection struct {
+		conn      *testConn
+		sessionID string
+		done      chan struct{}
+	}
+
+	conns := []connection{
+		{newTestConn(), "session1", make(chan struct{})},
+		{newTestConn(), "session1", make(chan struct{})},
+		{newTestConn(), "session2", make(chan struct{})},
+	}
+	for i, c := range conns {
+		go func() {
+			defer close(c.done)
+			h.Handle(user, fmt.Sprintf("room-%d", i%2), domain.Client{SessionID: c.sessionID}, c.conn, nil)
+		}()
+		<-c.conn.joined
+	}
+
+	h.DisconnectSession(user.UserID, "session1")
+	for _, c := range conns[:2] {
+		<-c.done
+		require.Equal(t, closeSessionRevoked, c.conn.code())
+	}
+
+	// the connections of the other sessions are kept
+	require.Equal(t, 1, h.Stats().Connections)
+	require.Zero(t, conns[2].conn.code())
+
+	conns[2].conn.leave()
+	<-conns[2].done
+	us := h.userShard(user.UserID)
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+	require.Empty(t, us.sessions)
+}
+
+func BenchmarkHub_Join(b *testing.B) {
+	for _, rooms := range []int{50, 500, 5000} {
+		b.Run(fmt.Sprintf("rooms_%d", rooms), func(b *testing.B) {
+			for range b.N {
+				h := NewHub(Config{IdleGracePeriod: time.Minute})
+				conns, done := joinAll(h, 5000, rooms)
+				for _, c := range conns {
+					c.leave()
+				}
+				<-done
+			}
+		})
+	}
+}
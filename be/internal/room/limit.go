@@ -0,0 +1,107 @@
+package room
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimit is a token bucket refilled with Rate events per second up to Burst events,
+// a zero rate disables the limit
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// verdict is the response to an event sent by a connection, it escalates
+// as the connection keeps exceeding its limits
+type verdict int
+
+const (
+	verdictAllow      verdict = iota
+	verdictWarn               // drop the event and warn the client to slow down
+	verdictDrop               // drop the event, the client was already warned
+	verdictDisconnect         // drop the event and close the connection
+)
+
+// limiter enforces the rate limits of a connection, it is only used by the connection goroutine
+type limiter struct {
+	buckets  map[eventType]*rate.Limiter
+	slowMode time.Duration
+	lastChat time.Time
+
+	maxViolations int
+	window        time.Duration
+	windowStart   time.Time
+	violations    int
+}
+
+// newLimiter buckets the chat and the signaling events, the room has no reaction event
+// so reactions get no bucket of their own
+func newLimiter(cfg Config) *limiter {
+	chat := newBucket(cfg.ChatRate)
+	signaling := newBucket(cfg.SignalingRate)
+
+	return &limiter{
+		buckets: map[eventType]*rate.Limiter{
+			eventChatMessage:  chat,
+			eventOffer:        signaling,
+			eventAnswer:       signaling,
+			eventIceCandidate: signaling,
+			eventIceRestart:   signaling,
+		},
+		slowMode:      cfg.ChatSlowMode,
+		maxViolations: cfg.MaxViolations,
+		window:        cfg.ViolationWindow,
+	}
+}
+
+func newBucket(limit RateLimit) *rate.Limiter {
+	if limit.Rate <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(limit.Rate), max(limit.Burst, 1))
+}
+
+// check decides what to do with the event sent at now, the returned duration
+// is how long the client should wait before sending the event again
+func (l *limiter) check(t eventType, now time.Time) (verdict, time.Duration) {
+	if retryAfter := l.limit(t, now); retryAfter > 0 {
+		return l.violate(now), retryAfter
+	}
+	return verdictAllow, 0
+}
+
+func (l *limiter) limit(t eventType, now time.Time) time.Duration {
+	if t == eventChatMessage && l.slowMode > 0 {
+		if next := l.lastChat.Add(l.slowMode); now.Before(next) {
+			return next.Sub(now)
+		}
+	}
+
+	if bucket := l.buckets[t]; bucket != nil && !bucket.AllowN(now, 1) {
+		return time.Duration(float64(time.Second) / float64(bucket.Limit()))
+	}
+
+	if t == eventChatMessage {
+		l.lastChat = now
+	}
+	return 0
+}
+
+func (l *limiter) violate(now time.Time) verdict {
+	if l.window > 0 && now.Sub(l.windowStart) > l.window {
+		l.windowStart = now
+		l.violations = 0
+	}
+	l.violations++
+
+	switch {
+	case l.maxViolations > 0 && l.violations > l.maxViolations:
+		return verdictDisconnect
+	case l.violations == 1:
+		return verdictWarn
+	default:
+		return verdictDrop
+	}
+}
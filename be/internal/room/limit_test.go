@@ -0,0 +1,92 @@
+package room
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	type step struct {
+		event   eventType
+		after   time.Duration // since the previous step
+		verdict verdict
+	}
+
+	tests := []struct {
+		name  string
+		cfg   Config
+		steps []step
+	}{
+		{
+			name: "no_limits",
+			cfg:  Config{},
+			steps: []step{
+				{eventChatMessage, 0, verdictAllow},
+				{eventChatMessage, 0, verdictAllow},
+				{eventIceCandidate, 0, verdictAllow},
+			},
+		},
+		{
+			name: "escalation",
+			cfg: Config{
+				SignalingRate:   RateLimit{Rate: 1, Burst: 2},
+				MaxViolations:   2,
+				ViolationWindow: 10 * time.Second,
+			},
+			steps: []step{
+				{eventIceCandidate, 0, verdictAllow},
+				{eventOffer, 0, verdictAllow},
+				{eventIceCandidate, 0, verdictWarn},
+				{eventChatMessage, 0, verdictAllow}, // other buckets are not affected
+				{eventIceCandidate, 0, verdictDrop},
+				{eventIceCandidate, 0, verdictDisconnect},
+			},
+		},
+		{
+			name: "violation_window",
+			cfg: Config{
+				SignalingRate:   RateLimit{Rate: 1, Burst: 1},
+				MaxViolations:   1,
+				ViolationWindow: time.Second,
+			},
+			steps: []step{
+				{eventOffer, 0, verdictAllow},
+				{eventOffer, 0, verdictWarn},
+				{eventOffer, 2 * time.Second, verdictAllow}, // refilled
+				{eventOffer, 0, verdictWarn},                // new window
+			},
+		},
+		{
+			name: "chat_slow_mode",
+			cfg:  Config{ChatSlowMode: 5 * time.Second},
+			steps: []step{
+				{eventChatMessage, 0, verdictAllow},
+				{eventChatMessage, time.Second, verdictWarn},
+				{eventChatMessage, time.Second, verdictDrop},
+				{eventChatMessage, 3 * time.Second, verdictAllow},
+				{eventOffer, 0, verdictAllow},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			l := newLimiter(tt.cfg)
+			at := now
+			for i, s := range tt.steps {
+				at = at.Add(s.after)
+				v, retryAfter := l.check(s.event, at)
+				require.Equal(t, s.verdict, v, "step %d", i)
+				require.Equal(t, v != verdictAllow, retryAfter > 0, "step %d", i)
+			}
+		})
+	}
+}
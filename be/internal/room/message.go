@@ -0,0 +1,63 @@
+package room
+
+import (
+	"github.com/gorilla/websocket"
+)
+
+// message is an event encoded at most once per codec, a broadcast shares
+// the message between its recipients instead of encoding it for each of them
+type message struct {
+	event  *baseMessage
+	shared bool
+	frames map[codec]*frame
+}
+
+// frame is an encoded event queued to the writer of a user
+type frame struct {
+	messageType int
+	data        []byte
+	prepared    *websocket.PreparedMessage // set for shared messages so the websocket frame is built once
+}
+
+func newMessage(event *baseMessage, shared bool) *message {
+	return &message{event: event, shared: shared, frames: make(map[codec]*frame, 1)}
+}
+
+// frame returns the event encoded by c, it is only called by the room goroutine
+func (m *message) frame(c codec) (*frame, error) {
+	if f, ok := m.frames[c]; ok {
+		return f, nil
+	}
+
+	event := m.event
+	if raw, ok := event.Data.(rawData); ok {
+		// forwarded data is re-encoded only if the sender uses another codec
+		data, err := raw.as(c)
+		if err != nil {
+			return nil, err
+		}
+		converted := *event
+		converted.Data = data
+		event = &converted
+	}
+
+	data, err := c.marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &frame{messageType: c.messageType(), data: data}
+	if m.shared {
+		f.prepared, err = websocket.NewPreparedMessage(f.messageType, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	m.frames[c] = f
+	return f, nil
+}
+
+func closeFrame(code int, reason string) *frame {
+	return &frame{messageType: websocket.CloseMessage, data: websocket.FormatCloseMessage(code, reason)}
+}
@@ -0,0 +1,152 @@
+package room
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRoom returns a room with a writing user per connection, the room goroutine is not running
+func newTestRoom(conns []*testConn) *room {
+	r := newRoom(Config{}, func() {})
+	for i, conn := range conns {
+		u := newUser(&domain.User{UserID: int64(i)}, domain.Client{}, preparedConn{conn}, nil)
+		u.innerID = fmt.Sprintf("user-%d", i)
+		r.users[u.innerID] = u
+		go u.write()
+	}
+	return r
+}
+
+func TestMessage_Frame(t *testing.T) {
+	t.Parallel()
+
+	event := &baseMessage{Type: eventOffer, From: "sender", Data: jsonData(`{"to":"peer"}`)}
+
+	m := newMessage(event, true)
+	for _, c := range codecs {
+		f, err := m.frame(c)
+		require.NoError(t, err)
+		require.Equal(t, c.messageType(), f.messageType)
+		require.NotNil(t, f.prepared)
+
+		var got struct {
+			Data webRTCMessage `json:"data"`
+		}
+		require.NoError(t, c.unmarshal(f.data, &got))
+		require.Equal(t, "peer", got.Data.To)
+
+		again, err := m.frame(c)
+		require.NoError(t, err)
+		require.Same(t, f, again, "encoded twice")
+	}
+
+	f, err := newMessage(event, false).frame(jsonCodec{})
+	require.NoError(t, err)
+	require.Nil(t, f.prepared)
+}
+
+func TestRoom_Broadcast(t *testing.T) {
+	t.Parallel()
+
+	var wg sync.WaitGroup
+	conns := make([]*testConn, 6)
+	for i := range conns {
+		conns[i] = newTestConn()
+		conns[i].subprotocol, conns[i].wg = codecs[i%len(codecs)].subprotocol(), &wg
+	}
+	r := newTestRoom(conns)
+
+	wg.Add(len(conns))
+	r.sendChatMessage("user-0", chatMessage{Content: "hello"})
+	wg.Wait()
+
+	// recipients with the same codec share the prepared frame
+	for i, conn := range conns {
+		require.Len(t, conn.prepared, 1)
+		require.Same(t, conns[i%len(codecs)].prepared[0], conn.prepared[0])
+	}
+	require.NotSame(t, conns[0].prepared[0], conns[1].prepared[0])
+}
+
+func TestUser_SendQueueFull(t *testing.T) {
+	t.Parallel()
+
+	conn := newTestConn()
+	u := newUser(&domain.User{}, domain.Client{}, conn, nil)
+
+	// the writer is not running so the queue fills up
+	for range sendBufferSize + 1 {
+		u.send(&baseMessage{Type: eventLeave, From: "peer"})
+	}
+
+	require.True(t, u.closed)
+	require.True(t, conn.isClosed())
+	require.Len(t, u.out, sendBufferSize)
+}
+
+func TestUser_SendClose(t *testing.T) {
+	t.Parallel()
+
+	var wg sync.WaitGroup
+	conn := newTestConn()
+	conn.wg = &wg
+	u := newUser(&domain.User{}, domain.Client{}, conn, nil)
+
+	wg.Add(2)
+	u.send(&baseMessage{Type: eventLeave, From: "peer"})
+	u.sendClose(websocket.ClosePolicyViolation, "rate limit exceeded")
+	u.send(&baseMessage{Type: eventLeave, From: "peer"}) // dropped, the user is closed
+
+	u.write()
+	wg.Wait()
+
+	// the queued frames are written before the close frame and the connection is closed
+	require.Len(t, conn.messages, 2)
+	require.Equal(t, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "rate limit exceeded"), conn.messages[1])
+	require.True(t, conn.isClosed())
+}
+
+func BenchmarkRoom_Broadcast(b *testing.B) {
+	msg := chatMessage{Content: "hello everyone, thanks for joining the webinar", Ts: time.Now()}
+
+	for _, n := range []int{50, 200, 1000} {
+		var wg sync.WaitGroup
+		conns := make([]*testConn, n)
+		for i := range conns {
+			conns[i] = newTestConn()
+			conns[i].wg, conns[i].discard = &wg, true
+		}
+		r := newTestRoom(conns)
+
+		b.Run(fmt.Sprintf("participants_%d/broadcast", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				wg.Add(n)
+				r.sendChatMessage("user-0", msg)
+				wg.Wait()
+			}
+		})
+
+		// baseline encoding the event for each recipient
+		b.Run(fmt.Sprintf("participants_%d/per_recipient", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				wg.Add(n)
+				for _, u := range r.users {
+					u.send(&baseMessage{Type: eventChatMessage, From: "user-0", Data: msg})
+				}
+				wg.Wait()
+			}
+		})
+
+		for _, u := range r.users {
+			u.close()
+		}
+	}
+}
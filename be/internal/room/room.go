@@ -1,193 +1,616 @@
 package room
 
 import (
-	"encoding/json"
+	"fmt"
 	"log"
 	"time"
 
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
 type room struct {
-	users  map[string]*user
-	events chan baseMessage
-	done   chan struct{}
+	cfg     Config
+	seq     uint64
+	users   map[string]*user
+	pending map[string]*pendingUser
+	events  chan baseMessage
+	done    chan struct{}
+
+	remove    func()      // removes the room from the hub once it is idle
+	idleTimer *time.Timer // running while the room is empty
+	idleSeq   uint64      // identifies the idle timer, older timers are ignored
+}
+
+// pendingUser is a user who lost the connection and may resume within resumeGracePeriod,
+// signaling addressed to them meanwhile is buffered and delivered once they are back
+type pendingUser struct {
+	user     *user
+	expires  time.Time
+	messages []baseMessage
 }
 
-func newRoom() *room {
+func newRoom(cfg Config, remove func()) *room {
 	return &room{
-		users:  make(map[string]*user),
-		events: make(chan baseMessage),
-		done:   make(chan struct{}),
+		cfg:     cfg,
+		users:   make(map[string]*user),
+		pending: make(map[string]*pendingUser),
+		events:  make(chan baseMessage),
+		done:    make(chan struct{}),
+		remove:  remove,
 	}
 }
 
+// run handles the room events until the room is torn down, the room starts
+// empty so it is torn down if nobody joins within the idle grace period
 func (r *room) run() {
+	r.checkIdle()
+
 	for {
 		select {
 		case event := <-r.events:
 			r.handleEvent(event)
+			r.checkIdle()
 		case <-r.done:
 			return
 		}
 	}
 }
 
-func (r *room) stop() {
-	close(r.done)
+// checkIdle starts the idle timer once the room is empty and stops it once somebody joins,
+// users who may resume keep the room alive
+func (r *room) checkIdle() {
+	empty := len(r.users) == 0 && len(r.pending) == 0
+
+	switch {
+	case empty && r.idleTimer == nil:
+		r.idleSeq++
+		seq := r.idleSeq
+		r.idleTimer = time.AfterFunc(r.cfg.IdleGracePeriod, func() {
+			r.emit(baseMessage{Type: eventIdle, Data: seq})
+		})
+	case !empty && r.idleTimer != nil:
+		r.idleTimer.Stop()
+		r.idleTimer = nil
+	}
+}
+
+// emit sends the event to the room, it returns false if the room is torn down
+func (r *room) emit(event baseMessage) bool {
+	select {
+	case r.events <- event:
+		return true
+	case <-r.done:
+		return false
+	}
 }
 
-func (r *room) join(u *user) {
-	r.events <- baseMessage{
-		Type: eventJoin,
-		From: u.innerID,
-		Data: u,
+// join returns false if the room was torn down before the user joined
+func (r *room) join(u *user) bool {
+	if !r.emit(baseMessage{Type: eventJoin, Data: u}) {
+		return false
 	}
-	r.listen(u.userID, u.innerID, u.conn)
+
+	// the room assigns the inner id as soon as it receives the join
+	<-u.joined
+
+	r.listen(u)
+	return true
 }
 
-func (r *room) listen(userID int64, innerID string, conn *websocket.Conn) {
+func (r *room) listen(u *user) {
+	var readErr error
 	defer func() {
-		r.events <- baseMessage{Type: eventLeave, From: innerID}
+		// a clean close means the user left, otherwise the user may come back
+		leaveType := eventDisconnect
+		if readErr == nil || websocket.IsCloseError(readErr, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+			leaveType = eventLeave
+		}
+		r.emit(baseMessage{Type: leaveType, From: u.innerID, Data: u})
 	}()
 
+	if r.cfg.MaxMessageSize > 0 {
+		u.conn.SetReadLimit(r.cfg.MaxMessageSize)
+	}
+	lim := newLimiter(r.cfg)
+
 	messageChan := make(chan []byte)
-	errChan := make(chan error)
+	errChan := make(chan error, 1)
+	stop := make(chan struct{})
+	defer close(stop)
 
+	// the writer closes the connection once the room closes the user,
+	// the reader stops once the connection is closed
+	go u.write()
 	go func() {
-		defer close(messageChan)
-		defer close(errChan)
-
 		for {
-			_, message, err := conn.ReadMessage()
+			_, message, err := u.conn.ReadMessage()
 			if err != nil {
 				errChan <- err
 				return
 			}
-			messageChan <- message
+			select {
+			case messageChan <- message:
+			case <-stop:
+				return
+			}
 		}
 	}()
 
-	ticker := time.NewTicker(websocketPingInterval)
-	defer ticker.Stop()
-
 	for {
 		select {
-		case <-ticker.C:
-			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				log.Printf("room_listen: ping user %d(%s): %v", userID, innerID, err)
-				return
-			}
 		case input := <-messageChan:
-			var message baseMessage
-			if err := json.Unmarshal(input, &message); err != nil {
-				log.Printf("room_listen: parse message from user %d(%s): %v", userID, innerID, err)
+			message, cerr := parseClientMessage(u, input)
+			if cerr != nil {
+				if !r.invalid(u, lim, message.ID, cerr) {
+					return // readErr is nil so the user leaves without the chance to resume
+				}
 				continue
 			}
-			message.From = innerID // override the from field (prevent spoofing)
-			r.events <- message
+			if v, retryAfter := lim.check(message.Type, time.Now()); v != verdictAllow {
+				log.Printf("room_listen: rate limited event %q from user %d(%s)", message.Type, u.userID, u.innerID)
+				if !r.limit(u, v, retryAfter) {
+					return
+				}
+				continue
+			}
+			r.emit(baseMessage{
+				ID:   message.ID,
+				Type: message.Type,
+				From: u.innerID, // set the from field on the server (prevent spoofing)
+				Data: message.Data,
+			})
 		case err := <-errChan:
-			log.Printf("room_listen: read from user %d(%s): %v", userID, innerID, err)
+			log.Printf("room_listen: read from user %d(%s): %v", u.userID, u.innerID, err)
+			readErr = err
 			return
 		}
 	}
 }
 
+// parseClientMessage decodes the message of the user, it fails if the room cannot handle it
+func parseClientMessage(u *user, input []byte) (clientMessage, *clientError) {
+	message, err := u.codec.decodeMessage(input)
+	if err != nil {
+		log.Printf("room_listen: parse message from user %d(%s): %v", u.userID, u.innerID, err)
+		return clientMessage{}, newClientError(domain.ErrCodeBadRequest, "malformed message")
+	}
+
+	if message.Data, err = message.Data.normalize(); err != nil {
+		log.Printf("room_listen: parse legacy data from user %d(%s): %v", u.userID, u.innerID, err)
+		return message, newClientError(domain.ErrCodeInvalidData, "malformed data")
+	}
+
+	if !message.Type.isClientEvent() {
+		log.Printf("room_listen: unexpected event %q from user %d(%s)", message.Type, u.userID, u.innerID)
+		if message.Type.isServerEvent() {
+			return message, newClientError(domain.ErrCodeUnauthorized, fmt.Sprintf("event %q cannot be sent by clients", message.Type))
+		}
+		return message, newClientError(domain.ErrCodeUnknownEvent, fmt.Sprintf("unknown event %q", message.Type))
+	}
+
+	if c, ok := eventCapabilities[message.Type]; ok && !u.supports(c) {
+		return message, unsupported(u.innerID, c)
+	}
+
+	return message, nil
+}
+
+// invalid responds to the message the room cannot handle, such messages are violations
+// of the limits so only the first one of the violation window gets the error from the
+// room, the others are dropped until the connection is closed
+func (r *room) invalid(u *user, lim *limiter, id string, err *clientError) bool {
+	switch lim.violate(time.Now()) {
+	case verdictDisconnect:
+		u.closeWith(websocket.ClosePolicyViolation, "too many invalid events")
+		return false
+	case verdictWarn:
+		r.reject(u.innerID, id, err)
+	}
+	return true
+}
+
+// limit responds to the event dropped by the rate limiter, the client is warned once
+// and the dropped events get no reply, it returns false if the connection must be closed
+func (r *room) limit(u *user, v verdict, retryAfter time.Duration) bool {
+	switch v {
+	case verdictDisconnect:
+		u.closeWith(websocket.ClosePolicyViolation, "rate limit exceeded")
+		return false
+	case verdictWarn:
+		r.emit(baseMessage{
+			Type: eventWarning,
+			From: u.innerID,
+			Data: warningMessage{
+				Code:       domain.ErrCodeRateLimited,
+				Message:    "too many events, slow down",
+				RetryAfter: retryAfter.Milliseconds(),
+			},
+		})
+	}
+	return true
+}
+
+// reject makes the room reply with an error to an event it never received
+func (r *room) reject(innerID string, id string, err *clientError) {
+	r.emit(baseMessage{Type: eventReject, ID: id, From: innerID, Data: err})
+}
+
 func (r *room) handleEvent(event baseMessage) {
 	switch event.Type {
+	case eventIdle:
+		if event.Data.(uint64) != r.idleSeq || r.idleTimer == nil {
+			return // somebody joined since the timer started
+		}
+
+		r.remove()
+		close(r.done)
 	case eventJoin:
 		u := event.Data.(*user)
+		if r.resume(u) {
+			break
+		}
+
+		r.seq++
+		u.seq = r.seq
+		u.innerID = uuid.NewString()
 		r.users[u.innerID] = u
+		close(u.joined)
 
-		r.sendUserJoined(event.From, u.name, u.avatar)
+		r.sendUserJoined(u, false)
 	case eventLeave:
-		_ = r.users[event.From].conn.Close()
-		delete(r.users, event.From)
+		u := event.Data.(*user)
+		if r.users[u.innerID] != u {
+			return // the user was already replaced by a resumed connection
+		}
+
+		u.close()
+		delete(r.users, u.innerID)
+
+		r.sendUserLeft(u.innerID)
+	case eventDisconnect:
+		u := event.Data.(*user)
+		if r.users[u.innerID] != u {
+			return
+		}
+
+		u.close()
+		delete(r.users, u.innerID)
+
+		if !u.supports(capabilityResume) {
+			r.sendUserLeft(u.innerID)
+			return
+		}
+
+		r.pending[u.innerID] = &pendingUser{user: u, expires: time.Now().Add(resumeGracePeriod)}
+		time.AfterFunc(resumeGracePeriod, func() {
+			r.emit(baseMessage{Type: eventResumeExpired, From: u.innerID})
+		})
+	case eventResumeExpired:
+		p, ok := r.pending[event.From]
+		if !ok || time.Now().Before(p.expires) {
+			return // resumed meanwhile
+		}
+
+		delete(r.pending, event.From)
+		for _, msg := range p.messages {
+			r.sendError(msg.From, msg.ID, deliveryFailed(event.From))
+		}
 
 		r.sendUserLeft(event.From)
+	case eventReject:
+		r.sendError(event.From, event.ID, event.Data.(*clientError))
+	case eventWarning:
+		if u, ok := r.users[event.From]; ok {
+			u.send(&event)
+		}
+	case eventKick:
+		k := event.Data.(kick)
+		if r.users[k.user.innerID] != k.user {
+			return
+		}
+
+		// the user leaves right away, it cannot resume the connection
+		k.user.sendClose(k.code, k.reason)
+		delete(r.users, k.user.innerID)
+
+		r.sendUserLeft(k.user.innerID)
+	default:
+		buffered, err := r.handleClientEvent(event)
+		if err != nil {
+			r.sendError(event.From, event.ID, err)
+			return
+		}
+		if !buffered {
+			r.sendAck(event.From, event.ID)
+		}
+	}
+}
+
+// handleClientEvent returns true if the event is buffered, its reply is sent once it is delivered
+func (r *room) handleClientEvent(event baseMessage) (bool, *clientError) {
+	switch event.Type {
 	case eventChatMessage:
-		if msg, ok := unmarshalClientData[chatMessage](event.Data); ok {
-			r.sendChatMessage(event.From, msg)
+		msg, err := unmarshalClientData[chatMessage](event.Data)
+		if err != nil {
+			return false, err
 		}
+		r.sendChatMessage(event.From, msg)
 	case eventOffer, eventAnswer, eventIceCandidate:
-		if msg, ok := unmarshalClientData[webRTCMessage](event.Data); ok {
-			r.forwardMessage(event, msg.To)
+		msg, err := unmarshalClientData[webRTCMessage](event.Data)
+		if err != nil {
+			return false, err
+		}
+		return r.forwardMessage(event, msg.To)
+	case eventIceRestart:
+		msg, err := unmarshalClientData[iceRestartRequest](event.Data)
+		if err != nil {
+			return false, err
+		}
+		return false, r.sendIceRestart(event.From, msg.To)
+	}
+
+	return false, nil
+}
+
+// resume registers the user under the inner id it asked to resume, it is only allowed
+// for the same user while the previous connection is pending or still registered
+func (r *room) resume(u *user) bool {
+	if u.resumeID == "" || !u.supports(capabilityResume) {
+		return false
+	}
+
+	var prev *user
+	if p, ok := r.pending[u.resumeID]; ok {
+		prev = p.user
+	} else if existing, ok := r.users[u.resumeID]; ok {
+		prev = existing // the previous connection is not detected as lost yet
+	}
+
+	if prev == nil || prev.userID != u.userID {
+		return false
+	}
+
+	prev.close()
+	_ = prev.conn.Close()
+
+	u.seq = prev.seq
+	u.innerID = u.resumeID
+	r.users[u.innerID] = u
+	close(u.joined)
+
+	r.sendUserJoined(u, true)
+
+	if p, ok := r.pending[u.innerID]; ok {
+		delete(r.pending, u.innerID)
+		for _, msg := range p.messages {
+			sendForwarded(u, msg)
+			r.sendAck(msg.From, msg.ID)
+		}
+	}
+
+	// peer connections may have broken while the user was away
+	for _, peer := range r.users {
+		if peer != u {
+			_ = r.sendIceRestart(u.innerID, peer.innerID)
 		}
 	}
+
+	return true
 }
 
-func (r *room) sendUserJoined(innerID string, name, avatar string) {
+// sendUserJoined sends the info event to the joined user and the join event to its peers,
+// the join event differs only by the negotiation role so it is encoded once per role and codec
+func (r *room) sendUserJoined(joined *user, resumed bool) {
+	joins := make(map[negotiation]*message, 2)
 	for _, u := range r.users {
 		// send info message to the user who joined only
-		if u.innerID == innerID {
+		if u.innerID == joined.innerID {
 			msg := baseMessage{
 				Type: eventInfo,
-				From: innerID,
-				Data: infoMessage{Users: createInfoUsers(r.users, innerID)},
+				From: joined.innerID,
+				Data: infoMessage{
+					Users:      createInfoUsers(r.users, joined),
+					ICEServers: u.iceServers,
+					Resumed:    resumed,
+					Protocol: protocolInfo{
+						Version:      u.version,
+						Capabilities: u.capabilities,
+					},
+				},
 			}
 			u.send(&msg)
 			continue
 		}
 
-		msg := baseMessage{
-			Type: eventJoin,
-			From: innerID,
-			Data: joinMessage{Name: name, Avatar: avatar},
+		if resumed {
+			continue // the peers never saw the user leave
 		}
-		u.send(&msg)
+
+		role := negotiationRole(u, joined)
+		m, ok := joins[role]
+		if !ok {
+			m = newMessage(&baseMessage{
+				Type: eventJoin,
+				From: joined.innerID,
+				Data: joinMessage{
+					Name:        joined.name,
+					Avatar:      joined.avatar,
+					Negotiation: role,
+				},
+			}, true)
+			joins[role] = m
+		}
+		u.deliver(m)
 	}
 }
 
-func (r *room) sendUserLeft(innerID string) {
+// broadcast sends the event to all the users, it is encoded once per codec
+func (r *room) broadcast(event *baseMessage) {
+	m := newMessage(event, true)
 	for _, u := range r.users {
-		msg := baseMessage{
-			Type: eventLeave,
-			From: innerID,
-		}
-		u.send(&msg)
+		u.deliver(m)
 	}
 }
 
+func (r *room) sendUserLeft(innerID string) {
+	r.broadcast(&baseMessage{
+		Type: eventLeave,
+		From: innerID,
+	})
+}
+
 func (r *room) sendChatMessage(innerID string, chatMsg chatMessage) {
-	for _, u := range r.users {
+	r.broadcast(&baseMessage{
+		Type: eventChatMessage,
+		From: innerID,
+		Data: chatMsg,
+	})
+}
+
+// forwardMessage delivers the message to the target user, messages to a user who may resume
+// are buffered and true is returned, the sender gets the ack once the message is delivered
+// or a delivery-failed error if the user does not resume
+func (r *room) forwardMessage(msg baseMessage, to string) (bool, *clientError) {
+	if targetUser, ok := r.users[to]; ok {
+		sendForwarded(targetUser, msg)
+		return false, nil
+	}
+
+	if p, ok := r.pending[to]; ok && len(p.messages) < pendingBufferSize {
+		p.messages = append(p.messages, msg)
+		return true, nil
+	}
+
+	return false, deliveryFailed(to)
+}
+
+// sendForwarded sends the message of another user to the target, the id of the message
+// only correlates the replies with the request of its sender so it is not forwarded
+func sendForwarded(target *user, msg baseMessage) {
+	msg.ID = ""
+	target.send(&msg)
+}
+
+func (r *room) sendAck(innerID string, id string) {
+	if id == "" {
+		return // the client is not interested in the ack
+	}
+
+	u, ok := r.users[innerID]
+	if !ok {
+		return
+	}
+
+	msg := baseMessage{
+		Type: eventAck,
+		From: innerID,
+		Data: ackMessage{ID: id},
+	}
+	u.send(&msg)
+}
+
+func (r *room) sendError(innerID string, id string, err *clientError) {
+	u, ok := r.users[innerID]
+	if !ok {
+		return
+	}
+
+	msg := baseMessage{
+		Type: eventError,
+		From: innerID,
+		Data: errorMessage{ID: id, Code: err.code, Message: err.message},
+	}
+	u.send(&msg)
+}
+
+func deliveryFailed(to string) *clientError {
+	return newClientError(domain.ErrCodeDeliveryFailed, fmt.Sprintf("user %q is not in the room", to))
+}
+
+func unsupported(innerID string, c capability) *clientError {
+	return newClientError(domain.ErrCodeUnsupported, fmt.Sprintf("user %q did not negotiate %q", innerID, c))
+}
+
+// sendIceRestart tells both peers of the pair to renegotiate with an ICE restart,
+// the pair initiator is expected to send the restart offer
+func (r *room) sendIceRestart(from, to string) *clientError {
+	a, ok := r.users[from]
+	if !ok {
+		return nil
+	}
+
+	b, ok := r.users[to]
+	if !ok {
+		return deliveryFailed(to)
+	}
+
+	if a == b {
+		return newClientError(domain.ErrCodeInvalidData, "cannot restart ice with yourself")
+	}
+
+	for _, u := range []*user{a, b} {
+		if !u.supports(capabilityIceRestart) {
+			return unsupported(u.innerID, capabilityIceRestart)
+		}
+	}
+
+	for _, pair := range [][2]*user{{a, b}, {b, a}} {
+		self, peer := pair[0], pair[1]
 		msg := baseMessage{
-			Type: eventChatMessage,
-			From: innerID,
-			Data: chatMsg,
+			Type: eventIceRestart,
+			From: peer.innerID,
+			Data: iceRestartMessage{
+				Peer:        peer.innerID,
+				Negotiation: negotiationRole(self, peer),
+			},
 		}
-		u.send(&msg)
+		self.send(&msg)
 	}
+
+	return nil
 }
 
-func (r *room) forwardMessage(msg baseMessage, to string) {
-	if targetUser, ok := r.users[to]; ok {
-		targetUser.send(&msg)
+// negotiationRole returns the role of self in its peer connection with peer,
+// the peer who joined later initiates the connection and is the impolite one
+// so its offers win when both peers send offers simultaneously (glare)
+func negotiationRole(self, peer *user) negotiation {
+	newcomer := self.seq > peer.seq
+	return negotiation{
+		Polite:    !newcomer,
+		Initiator: newcomer,
 	}
 }
 
-func createInfoUsers(users map[string]*user, exclude string) []infoUser {
+func createInfoUsers(users map[string]*user, self *user) []infoUser {
 	infoUsers := make([]infoUser, 0, len(users)-1)
 	for _, u := range users {
-		if u.innerID == exclude {
+		if u.innerID == self.innerID {
 			continue
 		}
-		infoUsers = append(infoUsers, infoUser{InnerID: u.innerID, Name: u.name, Avatar: u.avatar})
+		infoUsers = append(infoUsers, infoUser{
+			InnerID:     u.innerID,
+			Name:        u.name,
+			Avatar:      u.avatar,
+			Negotiation: negotiationRole(self, u),
+		})
 	}
 	return infoUsers
 }
 
-func unmarshalClientData[T any](input any) (T, bool) {
-	data, ok := input.(string)
-	if !ok {
-		log.Printf("unmarshal: unexpected data type: %T", input)
-		return *new(T), false
+func unmarshalClientData[T any](input any) (T, *clientError) {
+	raw, ok := input.(rawData)
+	if !ok || len(raw.data) == 0 {
+		log.Printf("unmarshal: missing data of type %T", input)
+		return *new(T), newClientError(domain.ErrCodeInvalidData, "missing data")
 	}
 
 	var dst T
-	if err := json.Unmarshal([]byte(data), &dst); err != nil {
+	if err := raw.codec.unmarshal(raw.data, &dst); err != nil {
 		log.Printf("unmarshal: decode data: %v", err)
-		return *new(T), false
+		return *new(T), newClientError(domain.ErrCodeInvalidData, "malformed data")
 	}
 
-	return dst, true
+	return dst, nil
 }
@@ -0,0 +1,549 @@
+package room
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnmarshalClientData(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		input    any
+		want     iceRestartRequest
+		wantCode domain.ErrorCode
+	}{
+		{"object_data", jsonData(`{"to":"peer"}`), iceRestartRequest{To: "peer"}, ""},
+		{"msgpack_data", encodedData(t, msgpackCodec{}, map[string]any{"to": "peer"}), iceRestartRequest{To: "peer"}, ""},
+		{"cbor_data", encodedData(t, cborCodec{}, map[string]any{"to": "peer"}), iceRestartRequest{To: "peer"}, ""},
+		{"malformed_data", jsonData(`{"to":`), iceRestartRequest{}, domain.ErrCodeInvalidData},
+		{"empty_data", jsonData(``), iceRestartRequest{}, domain.ErrCodeInvalidData},
+		{"missing_data", nil, iceRestartRequest{}, domain.ErrCodeInvalidData},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := unmarshalClientData[iceRestartRequest](tt.input)
+			if tt.wantCode == "" {
+				require.Nil(t, err)
+				require.Equal(t, tt.want, got)
+				return
+			}
+
+			require.NotNil(t, err)
+			require.Equal(t, tt.wantCode, err.code)
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		client      domain.Client
+		wantVersion int
+		wantCaps    []capability
+	}{
+		{"legacy_client", domain.Client{}, 1, []capability{}},
+		{"current_client", domain.Client{Version: protocolVersion, Capabilities: []string{"ack", "resume"}}, protocolVersion, []capability{capabilityResume, capabilityAck}},
+		{"newer_client", domain.Client{Version: protocolVersion + 1, Capabilities: []string{"media-state", "ice-restart"}}, protocolVersion, []capability{capabilityIceRestart}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			version, caps := negotiate(tt.client)
+			require.Equal(t, tt.wantVersion, version)
+			require.Equal(t, tt.wantCaps, caps)
+		})
+	}
+}
+
+// newFrameConn returns a connection passing the frames written to it to the test
+func newFrameConn() *testConn {
+	c := newTestConn()
+	c.frames = make(chan []byte, sendBufferSize)
+	return c
+}
+
+// testEvent is an event as received by a json client
+type testEvent struct {
+	ID   string          `json:"id"`
+	Type eventType       `json:"type"`
+	From string          `json:"from"`
+	Data json.RawMessage `json:"data"`
+}
+
+// newRunlessRoom returns a room whose events are handled by the test instead of the room goroutine
+func newRunlessRoom(t *testing.T) *room {
+	r := newRoom(Config{}, func() {})
+	t.Cleanup(func() { close(r.done) }) // releases the timers emitting to the room
+	return r
+}
+
+// joinTestUser joins a json client of the user with the capabilities, resumeID is the inner id
+// of the connection to resume, the info event sent to the user is returned
+func joinTestUser(t *testing.T, r *room, userID int64, resumeID string, caps ...capability) (*user, *testConn, infoMessage) {
+	t.Helper()
+
+	client := domain.Client{Version: protocolVersion, ResumeID: resumeID}
+	for _, c := range caps {
+		client.Capabilities = append(client.Capabilities, string(c))
+	}
+
+	conn := newFrameConn()
+	u := newUser(&domain.User{UserID: userID}, client, conn, nil)
+	r.handleEvent(baseMessage{Type: eventJoin, Data: u})
+	go u.write()
+
+	var info infoMessage
+	require.NoError(t, json.Unmarshal(requireEvent(t, conn, eventInfo).Data, &info))
+	return u, conn, info
+}
+
+// clientEvent returns the event as received by the room from the user
+func clientEvent(from *user, id string, t eventType, data string) baseMessage {
+	return baseMessage{ID: id, Type: t, From: from.innerID, Data: jsonData(data)}
+}
+
+func requireEvent(t *testing.T, conn *testConn, want eventType) testEvent {
+	t.Helper()
+
+	select {
+	case data := <-conn.frames:
+		var event testEvent
+		require.NoError(t, json.Unmarshal(data, &event))
+		require.Equal(t, want, event.Type, "unexpected event %s", data)
+		return event
+	case <-time.After(time.Second):
+		t.Fatalf("no %q event received", want)
+		return testEvent{}
+	}
+}
+
+func requireNoEvent(t *testing.T, conn *testConn) {
+	t.Helper()
+
+	select {
+	case data := <-conn.frames:
+		t.Fatalf("unexpected event %s", data)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func requireError(t *testing.T, conn *testConn, id string, code domain.ErrorCode) {
+	t.Helper()
+
+	var msg errorMessage
+	require.NoError(t, json.Unmarshal(requireEvent(t, conn, eventError).Data, &msg))
+	require.Equal(t, id, msg.ID)
+	require.Equal(t, code, msg.Code)
+}
+
+func requireAck(t *testing.T, conn *testConn, id string) {
+	t.Helper()
+
+	var msg ackMessage
+	require.NoError(t, json.Unmarshal(requireEvent(t, conn, eventAck).Data, &msg))
+	require.Equal(t, id, msg.ID)
+}
+
+func requireIceRestart(t *testing.T, conn *testConn, peer *user, want negotiation) {
+	t.Helper()
+
+	var msg iceRestartMessage
+	require.NoError(t, json.Unmarshal(requireEvent(t, conn, eventIceRestart).Data, &msg))
+	require.Equal(t, iceRestartMessage{Peer: peer.innerID, Negotiation: want}, msg)
+}
+
+var (
+	// roles of the peer who joined first and of the newcomer in their pair
+	firstRole    = negotiation{Polite: true}
+	newcomerRole = negotiation{Initiator: true}
+)
+
+func TestRoom_IceRestart(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		peerCaps []capability
+		to       func(self, peer *user) string
+		wantCode domain.ErrorCode
+	}{
+		{"restart", []capability{capabilityIceRestart}, func(_, peer *user) string { return peer.innerID }, ""},
+		{"unsupported_peer", nil, func(_, peer *user) string { return peer.innerID }, domain.ErrCodeUnsupported},
+		{"unknown_peer", []capability{capabilityIceRestart}, func(*user, *user) string { return "ghost" }, domain.ErrCodeDeliveryFailed},
+		{"self", []capability{capabilityIceRestart}, func(self, _ *user) string { return self.innerID }, domain.ErrCodeInvalidData},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := newRunlessRoom(t)
+			a, aConn, _ := joinTestUser(t, r, 1, "", capabilityIceRestart, capabilityAck)
+			b, bConn, _ := joinTestUser(t, r, 2, "", tt.peerCaps...)
+			requireEvent(t, aConn, eventJoin)
+
+			r.handleEvent(clientEvent(a, "1", eventIceRestart, fmt.Sprintf(`{"to":%q}`, tt.to(a, b))))
+
+			if tt.wantCode != "" {
+				requireError(t, aConn, "1", tt.wantCode)
+				requireNoEvent(t, bConn)
+				return
+			}
+
+			// the newcomer of the pair sends the restart offer
+			requireIceRestart(t, aConn, b, firstRole)
+			requireIceRestart(t, bConn, a, newcomerRole)
+			requireAck(t, aConn, "1")
+		})
+	}
+}
+
+func TestRoom_JoinEncodedOnce(t *testing.T) {
+	t.Parallel()
+
+	r := newRunlessRoom(t)
+	_, aConn, _ := joinTestUser(t, r, 1, "")
+	_, bConn, _ := joinTestUser(t, r, 2, "")
+	requireEvent(t, aConn, eventJoin)
+	joinTestUser(t, r, 3, "")
+
+	// both peers joined before the newcomer so they share its join frame
+	aData, bData := <-aConn.frames, <-bConn.frames
+	require.Same(t, &aData[0], &bData[0])
+
+	var event testEvent
+	require.NoError(t, json.Unmarshal(aData, &event))
+	require.Equal(t, eventJoin, event.Type)
+
+	var msg joinMessage
+	require.NoError(t, json.Unmarshal(event.Data, &msg))
+	require.Equal(t, firstRole, msg.Negotiation)
+}
+
+func TestRoom_ResumeRestartsIce(t *testing.T) {
+	t.Parallel()
+
+	caps := []capability{capabilityResume, capabilityIceRestart}
+
+	r := newRunlessRoom(t)
+	a, aConn, _ := joinTestUser(t, r, 1, "", caps...)
+	b, _, _ := joinTestUser(t, r, 2, "", caps...)
+	_, cConn, _ := joinTestUser(t, r, 3, "", capabilityResume) // cannot restart ice
+	requireEvent(t, aConn, eventJoin)
+	requireEvent(t, aConn, eventJoin)
+
+	r.handleEvent(baseMessage{Type: eventDisconnect, From: b.innerID, Data: b})
+	resumed, resumedConn, info := joinTestUser(t, r, 2, b.innerID, caps...)
+	require.True(t, info.Resumed)
+
+	// the server asks the pairs of the resumed user to renegotiate
+	requireIceRestart(t, aConn, resumed, firstRole)
+	requireIceRestart(t, resumedConn, a, newcomerRole)
+	requireNoEvent(t, resumedConn)
+	requireNoEvent(t, cConn)
+}
+
+func TestRoom_ResumeWithinGracePeriod(t *testing.T) {
+	t.Parallel()
+
+	r := newRunlessRoom(t)
+	a, aConn, _ := joinTestUser(t, r, 1, "", capabilityAck)
+	b, _, _ := joinTestUser(t, r, 2, "", capabilityResume)
+	requireEvent(t, aConn, eventJoin)
+
+	// the peers do not see the user leave while it may resume
+	r.handleEvent(baseMessage{Type: eventDisconnect, From: b.innerID, Data: b})
+	requireNoEvent(t, aConn)
+
+	// signaling addressed to the user meanwhile is buffered
+	r.handleEvent(clientEvent(a, "", eventOffer, fmt.Sprintf(`{"to":%q,"content":"sdp"}`, b.innerID)))
+	requireNoEvent(t, aConn)
+
+	resumed, resumedConn, info := joinTestUser(t, r, 2, b.innerID, capabilityResume)
+	require.True(t, info.Resumed)
+	require.Equal(t, b.innerID, resumed.innerID)
+	require.Len(t, info.Users, 1)
+	require.Equal(t, a.innerID, info.Users[0].InnerID)
+	require.Empty(t, r.pending)
+
+	offer := requireEvent(t, resumedConn, eventOffer)
+	require.Equal(t, a.innerID, offer.From)
+	requireNoEvent(t, aConn)
+}
+
+func TestRoom_ResumeAfterGracePeriod(t *testing.T) {
+	t.Parallel()
+
+	r := newRunlessRoom(t)
+	a, aConn, _ := joinTestUser(t, r, 1, "", capabilityAck)
+	b, _, _ := joinTestUser(t, r, 2, "", capabilityResume)
+	requireEvent(t, aConn, eventJoin)
+
+	r.handleEvent(baseMessage{Type: eventDisconnect, From: b.innerID, Data: b})
+	r.handleEvent(clientEvent(a, "", eventOffer, fmt.Sprintf(`{"to":%q,"content":"sdp"}`, b.innerID)))
+
+	r.pending[b.innerID].expires = time.Now()
+	r.handleEvent(baseMessage{Type: eventResumeExpired, From: b.innerID})
+
+	// the buffered signaling is reported undelivered before the user leaves
+	requireError(t, aConn, "", domain.ErrCodeDeliveryFailed)
+	require.Equal(t, b.innerID, requireEvent(t, aConn, eventLeave).From)
+	require.Empty(t, r.pending)
+
+	// resuming is too late, the user joins as a new participant
+	joined, _, info := joinTestUser(t, r, 2, b.innerID, capabilityResume)
+	require.False(t, info.Resumed)
+	require.NotEqual(t, b.innerID, joined.innerID)
+	require.Equal(t, joined.innerID, requireEvent(t, aConn, eventJoin).From)
+}
+
+func TestRoom_ResumeRefused(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		userID int64
+		caps   []capability
+	}{
+		{"other_user", 3, []capability{capabilityResume}},
+		{"resume_not_negotiated", 2, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := newRunlessRoom(t)
+			b, _, _ := joinTestUser(t, r, 2, "", capabilityResume)
+			r.handleEvent(baseMessage{Type: eventDisconnect, From: b.innerID, Data: b})
+
+			u, _, info := joinTestUser(t, r, tt.userID, b.innerID, tt.caps...)
+			require.False(t, info.Resumed)
+			require.NotEqual(t, b.innerID, u.innerID)
+			require.Contains(t, r.pending, b.innerID)
+		})
+	}
+}
+
+func TestRoom_DisconnectWithoutResume(t *testing.T) {
+	t.Parallel()
+
+	r := newRunlessRoom(t)
+	_, aConn, _ := joinTestUser(t, r, 1, "")
+	b, _, _ := joinTestUser(t, r, 2, "")
+	requireEvent(t, aConn, eventJoin)
+
+	r.handleEvent(baseMessage{Type: eventDisconnect, From: b.innerID, Data: b})
+	require.Equal(t, b.innerID, requireEvent(t, aConn, eventLeave).From)
+	require.Empty(t, r.pending)
+}
+
+func TestRoom_DeliveryFailed(t *testing.T) {
+	t.Parallel()
+
+	r := newRunlessRoom(t)
+	a, aConn, _ := joinTestUser(t, r, 1, "", capabilityAck)
+	b, _, _ := joinTestUser(t, r, 2, "", capabilityResume)
+	requireEvent(t, aConn, eventJoin)
+
+	// the target was never in the room
+	r.handleEvent(clientEvent(a, "", eventIceCandidate, `{"to":"ghost","content":"candidate"}`))
+	requireError(t, aConn, "", domain.ErrCodeDeliveryFailed)
+
+	// the buffer of a user who may resume is full
+	r.handleEvent(baseMessage{Type: eventDisconnect, From: b.innerID, Data: b})
+	candidate := fmt.Sprintf(`{"to":%q,"content":"candidate"}`, b.innerID)
+	for range pendingBufferSize {
+		r.handleEvent(clientEvent(a, "", eventIceCandidate, candidate))
+	}
+	requireNoEvent(t, aConn)
+
+	r.handleEvent(clientEvent(a, "", eventIceCandidate, candidate))
+	requireError(t, aConn, "", domain.ErrCodeDeliveryFailed)
+}
+
+func TestRoom_ForwardMessage(t *testing.T) {
+	t.Parallel()
+
+	r := newRunlessRoom(t)
+	a, aConn, _ := joinTestUser(t, r, 1, "", capabilityAck)
+	b, bConn, _ := joinTestUser(t, r, 2, "", capabilityResume)
+	requireEvent(t, aConn, eventJoin)
+	offer := fmt.Sprintf(`{"to":%q,"content":"sdp"}`, b.innerID)
+
+	// the id of the sender's request is not forwarded
+	r.handleEvent(clientEvent(a, "1", eventOffer, offer))
+	got := requireEvent(t, bConn, eventOffer)
+	require.Empty(t, got.ID)
+	require.Equal(t, a.innerID, got.From)
+	require.JSONEq(t, offer, string(got.Data))
+	requireAck(t, aConn, "1")
+
+	// a buffered message is acked once delivered
+	r.handleEvent(baseMessage{Type: eventDisconnect, From: b.innerID, Data: b})
+	r.handleEvent(clientEvent(a, "2", eventOffer, offer))
+	requireNoEvent(t, aConn)
+
+	_, resumedConn, _ := joinTestUser(t, r, 2, b.innerID, capabilityResume)
+	require.Empty(t, requireEvent(t, resumedConn, eventOffer).ID)
+	requireAck(t, aConn, "2")
+}
+
+func TestRoom_ForwardMessageUndelivered(t *testing.T) {
+	t.Parallel()
+
+	r := newRunlessRoom(t)
+	a, aConn, _ := joinTestUser(t, r, 1, "", capabilityAck)
+	b, _, _ := joinTestUser(t, r, 2, "", capabilityResume)
+	requireEvent(t, aConn, eventJoin)
+
+	r.handleEvent(baseMessage{Type: eventDisconnect, From: b.innerID, Data: b})
+	r.handleEvent(clientEvent(a, "1", eventOffer, fmt.Sprintf(`{"to":%q,"content":"sdp"}`, b.innerID)))
+
+	r.pending[b.innerID].expires = time.Now()
+	r.handleEvent(baseMessage{Type: eventResumeExpired, From: b.innerID})
+
+	// the only reply to the buffered message is the error
+	requireError(t, aConn, "1", domain.ErrCodeDeliveryFailed)
+	requireEvent(t, aConn, eventLeave)
+	requireNoEvent(t, aConn)
+}
+
+// newScriptConn returns a frame connection the test sends client messages through
+func newScriptConn(t *testing.T) *testConn {
+	c := newFrameConn()
+	c.in = make(chan []byte)
+	t.Cleanup(func() { close(c.in) })
+	return c
+}
+
+func TestRoom_NormalizeLegacyData(t *testing.T) {
+	t.Parallel()
+
+	r := newRoom(Config{IdleGracePeriod: time.Minute}, func() {})
+	t.Cleanup(func() { close(r.done) })
+	go r.run()
+
+	modernConn := newScriptConn(t)
+	go r.join(newUser(&domain.User{UserID: 1}, domain.Client{Version: protocolVersion}, modernConn, nil))
+	modern := requireEvent(t, modernConn, eventInfo).From
+
+	legacyConn := newScriptConn(t)
+	go r.join(newUser(&domain.User{UserID: 2}, domain.Client{}, legacyConn, nil))
+	requireEvent(t, legacyConn, eventInfo)
+	requireEvent(t, modernConn, eventJoin)
+
+	// legacy clients send the data as a json encoded string
+	data := fmt.Sprintf(`{"to":%q,"content":"sdp"}`, modern)
+	legacyConn.in <- []byte(fmt.Sprintf(`{"type":"offer","data":%q}`, data))
+
+	offer := requireEvent(t, modernConn, eventOffer)
+	require.JSONEq(t, data, string(offer.Data))
+}
+
+func TestRoom_LegacyClientErrors(t *testing.T) {
+	t.Parallel()
+
+	r := newRunlessRoom(t)
+	a, aConn, _ := joinTestUser(t, r, 1, "")
+
+	// legacy clients get the errors but not the acks
+	r.handleEvent(clientEvent(a, "1", eventOffer, `{"to":"ghost","content":"sdp"}`))
+	requireError(t, aConn, "1", domain.ErrCodeDeliveryFailed)
+
+	r.handleEvent(clientEvent(a, "2", eventChatMessage, `{"content":"hello"}`))
+	requireEvent(t, aConn, eventChatMessage)
+	requireNoEvent(t, aConn)
+}
+
+// joinScriptUser joins a json client of the user to the running room, the test sends the
+// client messages through the returned connection
+func joinScriptUser(t *testing.T, r *room, userID int64, caps ...capability) *testConn {
+	t.Helper()
+
+	client := domain.Client{Version: protocolVersion}
+	for _, c := range caps {
+		client.Capabilities = append(client.Capabilities, string(c))
+	}
+
+	conn := newScriptConn(t)
+	go r.join(newUser(&domain.User{UserID: userID}, client, conn, nil))
+	requireEvent(t, conn, eventInfo)
+	return conn
+}
+
+func requireClosed(t *testing.T, conn *testConn, code int, reason string) {
+	t.Helper()
+
+	select {
+	case data := <-conn.frames:
+		require.Equal(t, websocket.FormatCloseMessage(code, reason), data)
+	case <-time.After(time.Second):
+		t.Fatal("connection not closed")
+	}
+}
+
+func TestRoom_InvalidMessages(t *testing.T) {
+	t.Parallel()
+
+	r := newRoom(Config{IdleGracePeriod: time.Minute, MaxViolations: 3, ViolationWindow: time.Minute}, func() {})
+	t.Cleanup(func() { close(r.done) })
+	go r.run()
+
+	conn := joinScriptUser(t, r, 1, capabilityAck)
+
+	// the first invalid message of the window gets the error, the others are dropped
+	conn.in <- []byte(`not json`)
+	requireError(t, conn, "", domain.ErrCodeBadRequest)
+
+	conn.in <- []byte(`{"id":"1","type":"unknown"}`)
+	conn.in <- []byte(`{"id":"2","type":"join"}`)
+	requireNoEvent(t, conn)
+
+	conn.in <- []byte(`{"id":"3","type":"warning"}`)
+	requireClosed(t, conn, websocket.ClosePolicyViolation, "too many invalid events")
+}
+
+func TestRoom_RateLimitedMessages(t *testing.T) {
+	t.Parallel()
+
+	cfg := Config{
+		IdleGracePeriod: time.Minute,
+		SignalingRate:   RateLimit{Rate: 0.001, Burst: 1},
+		MaxViolations:   2,
+		ViolationWindow: time.Minute,
+	}
+	r := newRoom(cfg, func() {})
+	t.Cleanup(func() { close(r.done) })
+	go r.run()
+
+	conn := joinScriptUser(t, r, 1, capabilityAck)
+	candidate := []byte(`{"id":"1","type":"ice-candidate","data":{"to":"ghost","content":"candidate"}}`)
+
+	conn.in <- candidate
+	requireError(t, conn, "1", domain.ErrCodeDeliveryFailed)
+
+	// the client is warned once and the dropped events get no reply
+	conn.in <- candidate
+	requireEvent(t, conn, eventWarning)
+	conn.in <- candidate
+	requireNoEvent(t, conn)
+
+	conn.in <- candidate
+	requireClosed(t, conn, websocket.ClosePolicyViolation, "rate limit exceeded")
+}
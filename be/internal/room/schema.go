@@ -0,0 +1,145 @@
+package room
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Schema returns the JSON Schema of every event of the room protocol along with the protocol
+// version, capabilities and subprotocols, schemas are grouped by the sender (client or server)
+// and keyed by event type
+func (h *Hub) Schema() map[string]any {
+	return protocolSchema
+}
+
+var protocolSchema = map[string]any{
+	"version":      protocolVersion,
+	"capabilities": capabilities,
+	"subprotocols": subprotocols(),
+	"client":       eventSchemas(clientEvents, true),
+	"server":       eventSchemas(serverEvents, false),
+}
+
+func eventSchemas(events map[eventType]any, client bool) map[eventType]any {
+	schemas := make(map[eventType]any, len(events))
+	for t, data := range events {
+		schemas[t] = eventSchema(t, data, client)
+	}
+	return schemas
+}
+
+// eventSchema describes the envelope of the event with its data
+func eventSchema(t eventType, data any, client bool) map[string]any {
+	properties := map[string]any{
+		"id":   map[string]any{"type": "string"},
+		"type": map[string]any{"const": t},
+	}
+	required := []string{"type"}
+
+	if !client {
+		properties["from"] = map[string]any{"type": "string"}
+		required = append(required, "from")
+	}
+
+	if data != nil {
+		dataSchema := typeSchema(reflect.TypeOf(data))
+
+		// forwarded events carry the data exactly as the sender encoded it
+		if client || t == eventOffer || t == eventAnswer || t == eventIceCandidate {
+			dataSchema = map[string]any{
+				"oneOf": []any{
+					dataSchema,
+					map[string]any{
+						"type":             "string",
+						"contentMediaType": "application/json",
+						"deprecated":       true,
+						"description":      "legacy json encoded data",
+					},
+				},
+			}
+		}
+
+		properties["data"] = dataSchema
+		required = append(required, "data")
+	}
+
+	schema := map[string]any{
+		"$schema":    jsonSchemaDraft,
+		"title":      string(t),
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+
+	// events newer than the first protocol version are only used once negotiated
+	if c, ok := eventCapabilities[t]; ok {
+		schema["x-capability"] = c
+	}
+
+	return schema
+}
+
+func typeSchema(t reflect.Type) map[string]any {
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		return structSchema(t)
+	default:
+		return map[string]any{} // any value
+	}
+}
+
+func structSchema(t reflect.Type) map[string]any {
+	properties := make(map[string]any, t.NumField())
+	required := make([]string, 0, t.NumField())
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = typeSchema(field.Type)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	return map[string]any{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
@@ -0,0 +1,45 @@
+package room
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchema(t *testing.T) {
+	t.Parallel()
+
+	schema := (&Hub{}).Schema()
+
+	// the schema must be publishable as json
+	_, err := json.Marshal(schema)
+	require.NoError(t, err)
+
+	client := schema["client"].(map[eventType]any)
+	for _, et := range []eventType{eventChatMessage, eventOffer, eventAnswer, eventIceCandidate, eventIceRestart} {
+		require.Contains(t, client, et)
+		require.True(t, et.isClientEvent())
+	}
+	for et := range client {
+		require.True(t, et.isClientEvent(), "client schema for non client event %q", et)
+	}
+
+	server := schema["server"].(map[eventType]any)
+	for _, et := range []eventType{eventJoin, eventLeave, eventInfo, eventAck, eventError} {
+		require.Contains(t, server, et)
+	}
+
+	chat := server[eventChatMessage].(map[string]any)
+	require.Equal(t, []string{"type", "from", "data"}, chat["required"])
+
+	data := chat["properties"].(map[string]any)["data"].(map[string]any)
+	require.Equal(t, "object", data["type"])
+	require.Equal(t, map[string]any{"type": "string", "format": "date-time"}, data["properties"].(map[string]any)["ts"])
+
+	require.Equal(t, capabilityAck, server[eventAck].(map[string]any)["x-capability"])
+	require.NotContains(t, chat, "x-capability")
+
+	leave := server[eventLeave].(map[string]any)
+	require.NotContains(t, leave["properties"], "data")
+}
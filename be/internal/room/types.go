@@ -1,9 +1,19 @@
 package room
 
-import "time"
+import (
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+)
 
 const (
+	protocolVersion = 2 // latest version of the room protocol spoken by the server
+
 	websocketPingInterval = 30 * time.Second
+
+	resumeGracePeriod = 10 * time.Second
+	pendingBufferSize = 256 // max buffered signaling messages per pending user
+	sendBufferSize    = 256 // max queued messages per user before the connection is dropped
 )
 
 type eventType string
@@ -11,9 +21,12 @@ type eventType string
 const (
 	// server events
 
-	eventJoin  eventType = "join"
-	eventLeave eventType = "leave"
-	eventInfo  eventType = "info"
+	eventJoin    eventType = "join"
+	eventLeave   eventType = "leave"
+	eventInfo    eventType = "info"
+	eventAck     eventType = "ack"
+	eventError   eventType = "error"
+	eventWarning eventType = "warning"
 
 	// client events
 
@@ -21,28 +34,130 @@ const (
 	eventOffer        eventType = "offer"
 	eventAnswer       eventType = "answer"
 	eventIceCandidate eventType = "ice-candidate"
+	eventIceRestart   eventType = "ice-restart" // also sent by the server to both peers of the pair
+
+	// internal events
+
+	eventDisconnect    eventType = "disconnect"
+	eventResumeExpired eventType = "resume-expired"
+	eventReject        eventType = "reject"
+	eventKick          eventType = "kick"
+	eventIdle          eventType = "idle"
+)
+
+// capability is an optional protocol feature negotiated with the client on join
+type capability string
+
+const (
+	capabilityResume     capability = "resume"      // resume a lost connection with its buffered signaling
+	capabilityIceRestart capability = "ice-restart" // ice-restart events
+	capabilityAck        capability = "ack"         // ack and warning replies to client events
 )
 
+// capabilities are the capabilities supported by the server
+var capabilities = []capability{capabilityResume, capabilityIceRestart, capabilityAck}
+
+// eventCapabilities maps the events newer than the first protocol version to the
+// capability the client must negotiate to send or receive them, errors are sent to
+// every client so legacy clients still learn about rejected events
+var eventCapabilities = map[eventType]capability{
+	eventAck:        capabilityAck,
+	eventWarning:    capabilityAck,
+	eventIceRestart: capabilityIceRestart,
+}
+
+func (t eventType) isClientEvent() bool {
+	switch t {
+	case eventChatMessage, eventOffer, eventAnswer, eventIceCandidate, eventIceRestart:
+		return true
+	default:
+		return false
+	}
+}
+
+func (t eventType) isServerEvent() bool {
+	switch t {
+	case eventJoin, eventLeave, eventInfo, eventAck, eventError, eventWarning,
+		eventDisconnect, eventResumeExpired, eventReject, eventKick, eventIdle:
+		return true
+	default:
+		return false
+	}
+}
+
+// clientEvents maps the events sent by clients to their data
+var clientEvents = map[eventType]any{
+	eventChatMessage:  chatMessage{},
+	eventOffer:        webRTCMessage{},
+	eventAnswer:       webRTCMessage{},
+	eventIceCandidate: webRTCMessage{},
+	eventIceRestart:   iceRestartRequest{},
+}
+
+// serverEvents maps the events sent by the server to their data, nil means no data
+var serverEvents = map[eventType]any{
+	eventJoin:         joinMessage{},
+	eventLeave:        nil,
+	eventInfo:         infoMessage{},
+	eventAck:          ackMessage{},
+	eventError:        errorMessage{},
+	eventWarning:      warningMessage{},
+	eventChatMessage:  chatMessage{},
+	eventOffer:        webRTCMessage{},
+	eventAnswer:       webRTCMessage{},
+	eventIceCandidate: webRTCMessage{},
+	eventIceRestart:   iceRestartMessage{},
+}
+
 type (
 	baseMessage struct {
+		// ID is set by the client to correlate the ack or error reply with the event,
+		// it is not forwarded to the other users
+		ID   string    `json:"id,omitempty"`
 		Type eventType `json:"type"`
 		From string    `json:"from"`
 		Data any       `json:"data,omitempty"`
 	}
 
+	// clientMessage is the envelope of the events sent by clients, the data is
+	// decoded according to the event type once the event reaches the room
+	clientMessage struct {
+		ID   string
+		Type eventType
+		Data rawData
+	}
+
+	// negotiation describes the role of the recipient in the peer connection
+	// with another peer following the perfect negotiation pattern
+	negotiation struct {
+		Polite    bool `json:"polite"`
+		Initiator bool `json:"initiator"`
+	}
+
 	joinMessage struct {
-		Name   string `json:"name"`
-		Avatar string `json:"avatar"`
+		Name        string      `json:"name"`
+		Avatar      string      `json:"avatar"`
+		Negotiation negotiation `json:"negotiation"`
 	}
 
 	infoUser struct {
-		InnerID string `json:"inner_id"`
-		Name    string `json:"name"`
-		Avatar  string `json:"avatar"`
+		InnerID     string      `json:"inner_id"`
+		Name        string      `json:"name"`
+		Avatar      string      `json:"avatar"`
+		Negotiation negotiation `json:"negotiation"`
+	}
+
+	// protocolInfo is the protocol negotiated with the client
+	protocolInfo struct {
+		Version      int          `json:"version"`
+		Capabilities []capability `json:"capabilities"`
 	}
 
 	infoMessage struct {
-		Users []infoUser `json:"users"`
+		Users      []infoUser         `json:"users"`
+		ICEServers []domain.ICEServer `json:"ice_servers"`
+		Resumed    bool               `json:"resumed"`
+		Protocol   protocolInfo       `json:"protocol"`
 	}
 
 	chatMessage struct {
@@ -52,6 +167,53 @@ type (
 
 	webRTCMessage struct {
 		To      string `json:"to"`
-		Content string `json:"content"`
+		Content any    `json:"content"` // session description or ice candidate, forwarded as is
+	}
+
+	iceRestartRequest struct {
+		To string `json:"to"`
+	}
+
+	ackMessage struct {
+		ID string `json:"id"`
+	}
+
+	errorMessage struct {
+		ID      string           `json:"id,omitempty"`
+		Code    domain.ErrorCode `json:"code"`
+		Message string           `json:"message"`
+	}
+
+	// warningMessage tells the client its events are dropped without reply until it slows down
+	warningMessage struct {
+		Code       domain.ErrorCode `json:"code"`
+		Message    string           `json:"message"`
+		RetryAfter int64            `json:"retry_after"` // milliseconds
+	}
+
+	iceRestartMessage struct {
+		Peer        string      `json:"peer"`
+		Negotiation negotiation `json:"negotiation"`
+	}
+
+	// kick closes the connection of the user with the close code and reason
+	kick struct {
+		user   *user
+		code   int
+		reason string
 	}
 )
+
+// clientError is the reason a client event was rejected
+type clientError struct {
+	code    domain.ErrorCode
+	message string
+}
+
+func newClientError(code domain.ErrorCode, message string) *clientError {
+	return &clientError{code: code, message: message}
+}
+
+func (e *clientError) Error() string {
+	return string(e.code) + ": " + e.message
+}
@@ -1,40 +1,169 @@
 package room
 
 import (
-	"encoding/json"
 	"log"
+	"slices"
+	"time"
 
 	"github.com/escalopa/vego/internal/domain"
-	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
 type user struct {
-	innerID string
-	userID  int64
-	name    string
-	avatar  string
-	conn    *websocket.Conn
+	seq          uint64 // join order in the room
+	innerID      string
+	resumeID     string        // inner id of a previous connection to resume
+	joined       chan struct{} // closed once the room assigned the inner id
+	userID       int64
+	sessionID    string // session the connection was opened from
+	name         string
+	avatar       string
+	conn         domain.Conn
+	codec        codec // encoding of the messages negotiated via the websocket subprotocol
+	iceServers   []domain.ICEServer
+	version      int          // negotiated protocol version
+	capabilities []capability // negotiated capabilities
+	out          chan *frame  // frames queued to the writer
+	closing      chan *frame  // close frame written by the writer before anything queued
+	closed       bool         // out is closed, only used by the room goroutine
 }
 
-func newUser(u *domain.User, conn *websocket.Conn) *user {
+func newUser(u *domain.User, client domain.Client, conn domain.Conn, iceServers []domain.ICEServer) *user {
+	version, caps := negotiate(client)
 	return &user{
-		innerID: uuid.NewString(),
-		userID:  u.UserID,
-		name:    u.Name,
-		avatar:  u.Avatar,
-		conn:    conn,
+		resumeID:     client.ResumeID,
+		joined:       make(chan struct{}),
+		userID:       u.UserID,
+		sessionID:    client.SessionID,
+		name:         u.Name,
+		avatar:       u.Avatar,
+		conn:         conn,
+		codec:        codecFor(conn.Subprotocol()),
+		iceServers:   iceServers,
+		version:      version,
+		capabilities: caps,
+		out:          make(chan *frame, sendBufferSize),
+		closing:      make(chan *frame, 1),
 	}
 }
 
+// negotiate returns the protocol version and the capabilities supported by both the client
+// and the server, clients that do not declare a version speak the first protocol version
+func negotiate(client domain.Client) (int, []capability) {
+	version := min(client.ProtocolVersion(), protocolVersion)
+
+	caps := make([]capability, 0, len(capabilities))
+	for _, c := range capabilities {
+		if slices.Contains(client.Capabilities, string(c)) {
+			caps = append(caps, c)
+		}
+	}
+
+	return version, caps
+}
+
+func (u *user) supports(c capability) bool {
+	return slices.Contains(u.capabilities, c)
+}
+
 func (u *user) send(msg *baseMessage) {
-	data, err := json.Marshal(msg)
-	if err != nil {
-		log.Printf("user: marshal message: %v", err)
+	u.deliver(newMessage(msg, false))
+}
+
+// deliver queues the message to the writer, it is only called by the room goroutine
+func (u *user) deliver(m *message) {
+	if u.closed {
+		return
+	}
+
+	if c, ok := eventCapabilities[m.event.Type]; ok && !u.supports(c) {
+		return // the client does not know the event
 	}
 
-	err = u.conn.WriteMessage(websocket.TextMessage, data)
+	f, err := m.frame(u.codec)
 	if err != nil {
-		log.Printf("user: send message: %v", err)
+		log.Printf("user: encode message: %v", err)
+		return
+	}
+
+	u.enqueue(f)
+}
+
+// sendClose queues a close frame, the connection is closed once it is written
+func (u *user) sendClose(code int, reason string) {
+	if u.closed {
+		return
+	}
+
+	u.enqueue(closeFrame(code, reason))
+	u.close()
+}
+
+// closeWith makes the writer close the connection with the close frame right away, it is called
+// by the connection goroutine so dropping a misbehaving connection costs no work to the room
+func (u *user) closeWith(code int, reason string) {
+	select {
+	case u.closing <- closeFrame(code, reason):
+	default: // already closing
+	}
+}
+
+func (u *user) enqueue(f *frame) {
+	select {
+	case u.out <- f:
+	default:
+		// the client does not keep up with the room, it may resume with a new connection
+		log.Printf("user: send queue of user %d(%s) is full", u.userID, u.innerID)
+		u.close()
+		_ = u.conn.Close()
+	}
+}
+
+// close stops the writer once the queued frames are written
+func (u *user) close() {
+	if !u.closed {
+		u.closed = true
+		close(u.out)
+	}
+}
+
+// preparedWriter is implemented by the websocket connections
+type preparedWriter interface {
+	WritePreparedMessage(pm *websocket.PreparedMessage) error
+}
+
+// write writes the queued frames and pings the client until the queue is closed, a close
+// frame is handed to it or a write fails, it closes the connection on return
+func (u *user) write() {
+	defer func() { _ = u.conn.Close() }()
+
+	ticker := time.NewTicker(websocketPingInterval)
+	defer ticker.Stop()
+
+	pw, canPrepare := u.conn.(preparedWriter)
+
+	for {
+		var err error
+		select {
+		case f, ok := <-u.out:
+			if !ok {
+				return
+			}
+			if f.prepared != nil && canPrepare {
+				err = pw.WritePreparedMessage(f.prepared)
+			} else {
+				err = u.conn.WriteMessage(f.messageType, f.data)
+			}
+		case f := <-u.closing:
+			_ = u.conn.WriteMessage(f.messageType, f.data)
+			return
+		case <-ticker.C:
+			err = u.conn.WriteMessage(websocket.PingMessage, nil)
+		}
+
+		if err != nil {
+			log.Printf("user: write to user %d(%s): %v", u.userID, u.innerID, err)
+			return
+		}
 	}
 }
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/google/uuid"
+)
+
+// accessTokenPrefix marks the personal access tokens so they are told apart from the jwt
+// and recognized by secret scanners
+const accessTokenPrefix = "vgp_"
+
+// CreateAccessToken creates a personal access token of the user granted the scopes,
+// the returned token is the only time it is shown
+func (s *Service) CreateAccessToken(ctx context.Context, userID int64, name string, scopes []string, ttl time.Duration) (*domain.AccessToken, string, error) {
+	if len(scopes) == 0 {
+		return nil, "", domain.ErrAccessTokenInvalidScope
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(domain.Scopes, scope) {
+			return nil, "", domain.ErrAccessTokenInvalidScope
+		}
+	}
+	if ttl <= 0 || (s.cfg.AccessTokenMaxTTL > 0 && ttl > s.cfg.AccessTokenMaxTTL) {
+		return nil, "", domain.ErrAccessTokenInvalidTTL
+	}
+
+	scopes = slices.Clone(scopes)
+	slices.Sort(scopes)
+
+	secret := accessTokenPrefix + rand.Text()
+	now := time.Now()
+	token := &domain.AccessToken{
+		TokenID:   uuid.NewString(),
+		UserID:    userID,
+		Name:      name,
+		TokenHash: hashToken(secret),
+		Scopes:    slices.Compact(scopes),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := s.db.CreateAccessToken(ctx, token); err != nil {
+		return nil, "", err
+	}
+
+	return token, secret, nil
+}
+
+// ListAccessTokens returns the personal access tokens of the user, expired ones included
+func (s *Service) ListAccessTokens(ctx context.Context, userID int64) ([]domain.AccessToken, error) {
+	return s.db.GetUserAccessTokens(ctx, userID)
+}
+
+// RevokeAccessToken deletes the personal access token of the user
+func (s *Service) RevokeAccessToken(ctx context.Context, userID int64, tokenID string) error {
+	return s.db.DeleteAccessToken(ctx, userID, tokenID)
+}
+
+// AuthenticateAccessToken returns the user of the personal access token and the scopes it was granted
+func (s *Service) AuthenticateAccessToken(ctx context.Context, secret string) (*domain.User, []string, error) {
+	if !strings.HasPrefix(secret, accessTokenPrefix) {
+		return nil, nil, domain.ErrTokenInvalid
+	}
+
+	token, err := s.db.GetAccessTokenByHash(ctx, hashToken(secret))
+	if err != nil {
+		if errors.Is(err, domain.ErrDBAccessTokenNotFound) {
+			return nil, nil, domain.ErrTokenInvalid
+		}
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if !now.Before(token.ExpiresAt) {
+		return nil, nil, domain.ErrTokenExpired
+	}
+
+	if now.Sub(token.LastUsedAt) >= sessionTouchInterval {
+		if err := s.db.TouchAccessToken(ctx, token.TokenID, now); err != nil {
+			log.Printf("service.AuthenticateAccessToken: touch token %s: %v", token.TokenID, err)
+		}
+	}
+
+	user, err := s.db.GetUser(ctx, token.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, token.Scopes, nil
+}
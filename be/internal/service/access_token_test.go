@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/escalopa/vego/internal/service/mock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_CreateAccessToken(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		scopes     []string
+		ttl        time.Duration
+		wantScopes []string
+		wantErr    error
+	}{
+		{"valid_token", []string{domain.ScopeRoomsWrite, domain.ScopeRecordingsRead, domain.ScopeRoomsWrite}, time.Hour, []string{domain.ScopeRecordingsRead, domain.ScopeRoomsWrite}, nil},
+		{"no_scopes", nil, time.Hour, nil, domain.ErrAccessTokenInvalidScope},
+		{"unknown_scope", []string{"users:write"}, time.Hour, nil, domain.ErrAccessTokenInvalidScope},
+		{"no_ttl", []string{domain.ScopeRoomsRead}, 0, nil, domain.ErrAccessTokenInvalidTTL},
+		{"ttl_over_max", []string{domain.ScopeRoomsRead}, 48 * time.Hour, nil, domain.ErrAccessTokenInvalidTTL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			db := mock.NewMockdatabase(ctrl)
+			svc := New(Config{AccessTokenMaxTTL: 24 * time.Hour}, db, nil, nil, nil, nil, nil, nil)
+
+			if tt.wantErr == nil {
+				db.EXPECT().CreateAccessToken(gomock.Any(), gomock.Any()).Return(nil)
+			}
+			token, secret, err := svc.CreateAccessToken(context.Background(), 1, "ci", tt.scopes, tt.ttl)
+			require.Equal(t, tt.wantErr, err)
+			if tt.wantErr == nil {
+				require.True(t, strings.HasPrefix(secret, accessTokenPrefix))
+				require.Equal(t, hashToken(secret), token.TokenHash)
+				require.Equal(t, tt.wantScopes, token.Scopes)
+				require.Equal(t, token.CreatedAt.Add(tt.ttl), token.ExpiresAt)
+			}
+		})
+	}
+}
+
+func TestService_AuthenticateAccessToken(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		secret    string
+		expiresIn time.Duration
+		lastUsed  time.Duration // ago
+		found     bool
+		wantTouch bool
+		wantErr   error
+	}{
+		{"valid_token", "vgp_secret", time.Hour, time.Hour, true, true, nil},
+		{"recently_used", "vgp_secret", time.Hour, time.Second, true, false, nil},
+		{"expired_token", "vgp_secret", -time.Second, time.Hour, true, false, domain.ErrTokenExpired},
+		{"unknown_token", "vgp_secret", time.Hour, time.Hour, false, false, domain.ErrTokenInvalid},
+		{"not_access_token", "secret", time.Hour, time.Hour, false, false, domain.ErrTokenInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			db := mock.NewMockdatabase(ctrl)
+			svc := New(Config{}, db, nil, nil, nil, nil, nil, nil)
+
+			now := time.Now()
+			token := &domain.AccessToken{
+				TokenID:    "token1",
+				UserID:     1,
+				Scopes:     []string{domain.ScopeRoomsRead},
+				ExpiresAt:  now.Add(tt.expiresIn),
+				LastUsedAt: now.Add(-tt.lastUsed),
+			}
+			if strings.HasPrefix(tt.secret, accessTokenPrefix) {
+				if tt.found {
+					db.EXPECT().GetAccessTokenByHash(gomock.Any(), hashToken(tt.secret)).Return(token, nil)
+				} else {
+					db.EXPECT().GetAccessTokenByHash(gomock.Any(), hashToken(tt.secret)).Return(nil, domain.ErrDBAccessTokenNotFound)
+				}
+			}
+			if tt.wantTouch {
+				db.EXPECT().TouchAccessToken(gomock.Any(), "token1", gomock.Any()).Return(nil)
+			}
+			if tt.wantErr == nil {
+				db.EXPECT().GetUser(gomock.Any(), int64(1)).Return(&domain.User{UserID: 1}, nil)
+			}
+
+			user, scopes, err := svc.AuthenticateAccessToken(context.Background(), tt.secret)
+			require.Equal(t, tt.wantErr, err)
+			if tt.wantErr == nil {
+				require.Equal(t, int64(1), user.UserID)
+				require.Equal(t, token.Scopes, scopes)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+)
+
+// ListIdentities returns the login providers linked to the user
+func (s *Service) ListIdentities(ctx context.Context, userID int64) ([]domain.Identity, error) {
+	return s.db.GetUserIdentities(ctx, userID)
+}
+
+// LinkIdentity starts a login with the provider that links it to the user once completed,
+// the returned state must be kept by the browser as for GetOAuthRedirectURL
+func (s *Service) LinkIdentity(ctx context.Context, userID int64, provider string) (string, string, error) {
+	return s.startOAuth(ctx, provider, userID)
+}
+
+// UnlinkIdentity removes the provider from the logins of the user, the last one is kept
+func (s *Service) UnlinkIdentity(ctx context.Context, userID int64, provider string) error {
+	identities, err := s.db.GetUserIdentities(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	linked := false
+	for _, identity := range identities {
+		linked = linked || identity.Provider == provider
+	}
+
+	switch {
+	case !linked:
+		return domain.ErrDBIdentityNotFound
+	case len(identities) == 1:
+		return domain.ErrIdentityLastLogin
+	}
+
+	return s.db.DeleteIdentity(ctx, userID, provider)
+}
+
+// loginIdentity returns the user of the identity, the user is created on the first login
+func (s *Service) loginIdentity(ctx context.Context, user *domain.User, identity *domain.Identity) (int64, error) {
+	existing, err := s.db.GetIdentity(ctx, identity.Provider, identity.Subject)
+	if err == nil {
+		return existing.UserID, nil
+	}
+	if !errors.Is(err, domain.ErrDBIdentityNotFound) {
+		return 0, err
+	}
+
+	// users created before identities are found by their email at the provider
+	userID, err := s.db.GetUserIDByEmail(ctx, identity.Email, identity.Provider)
+	// unverified emails are only displayed, they cannot log in to the account of another provider
+	if errors.Is(err, domain.ErrDBUserNotFound) && s.cfg.OAuthAutoLink && identity.EmailVerified {
+		userID, err = s.db.GetUserIDByEmail(ctx, identity.Email, "")
+	}
+	if errors.Is(err, domain.ErrDBUserNotFound) {
+		userID, err = s.db.CreateUser(ctx, user, identity.Provider)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	identity.UserID, identity.CreatedAt = userID, time.Now()
+	err = s.db.CreateIdentity(ctx, identity)
+	if errors.Is(err, domain.ErrDBIdentityExists) {
+		// linked by a concurrent login meanwhile
+		existing, err := s.db.GetIdentity(ctx, identity.Provider, identity.Subject)
+		if err != nil {
+			if errors.Is(err, domain.ErrDBIdentityNotFound) {
+				return 0, domain.ErrIdentityProviderLinked
+			}
+			return 0, err
+		}
+		return existing.UserID, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// linkIdentity links the identity to the user, the account the identity logged in to so far
+// is merged into the user so its rooms and recordings follow
+func (s *Service) linkIdentity(ctx context.Context, userID int64, identity *domain.Identity) error {
+	var fromUserID int64
+
+	existing, err := s.db.GetIdentity(ctx, identity.Provider, identity.Subject)
+	switch {
+	case err == nil:
+		fromUserID = existing.UserID
+	case errors.Is(err, domain.ErrDBIdentityNotFound):
+		// a user created before identities is merged as well
+		fromUserID, err = s.db.GetUserIDByEmail(ctx, identity.Email, identity.Provider)
+		if err != nil && !errors.Is(err, domain.ErrDBUserNotFound) {
+			return err
+		}
+	default:
+		return err
+	}
+
+	if fromUserID != 0 && fromUserID != userID {
+		if err := s.mergeUsers(ctx, fromUserID, userID); err != nil {
+			return err
+		}
+	}
+	if existing != nil {
+		return nil // linked to the user, now or already
+	}
+
+	identity.UserID, identity.CreatedAt = userID, time.Now()
+	if err := s.db.CreateIdentity(ctx, identity); err != nil {
+		if errors.Is(err, domain.ErrDBIdentityExists) {
+			return domain.ErrIdentityProviderLinked
+		}
+		return err
+	}
+
+	return nil
+}
+
+// mergeUsers merges the user fromUserID into toUserID and disconnects its sessions
+func (s *Service) mergeUsers(ctx context.Context, fromUserID int64, toUserID int64) error {
+	sessionIDs, err := s.db.MergeUsers(ctx, fromUserID, toUserID, time.Now())
+	if err != nil {
+		if errors.Is(err, domain.ErrDBIdentityExists) {
+			return domain.ErrIdentityProviderLinked
+		}
+		return err
+	}
+
+	for _, sessionID := range sessionIDs {
+		s.hub.DisconnectSession(fromUserID, sessionID)
+	}
+	return nil
+}
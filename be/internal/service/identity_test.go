@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/escalopa/vego/internal/service/mock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_LoginIdentity(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		autoLink   bool
+		verified   bool  // email verified by the provider
+		identityID int64 // user of the identity
+		legacyID   int64 // user created before identities
+		emailID    int64 // user with the email at another provider
+		wantUserID int64
+	}{
+		{"existing_identity", false, true, 2, 0, 0, 2},
+		{"legacy_user", false, true, 0, 3, 0, 3},
+		{"auto_link", true, true, 0, 0, 4, 4},
+		{"auto_link_disabled", false, true, 0, 0, 4, 1},
+		{"auto_link_unverified_email", true, false, 0, 0, 4, 1},
+		{"new_user", true, true, 0, 0, 0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			db := mock.NewMockdatabase(ctrl)
+			svc := New(Config{OAuthAutoLink: tt.autoLink}, db, nil, nil, nil, nil, nil, nil)
+
+			user := &domain.User{Email: "test@example.com"}
+			identity := &domain.Identity{Provider: "google", Subject: "subject1", Email: user.Email, EmailVerified: tt.verified}
+
+			if tt.identityID != 0 {
+				db.EXPECT().GetIdentity(gomock.Any(), "google", "subject1").Return(&domain.Identity{UserID: tt.identityID}, nil)
+			} else {
+				db.EXPECT().GetIdentity(gomock.Any(), "google", "subject1").Return(nil, domain.ErrDBIdentityNotFound)
+				if tt.legacyID != 0 {
+					db.EXPECT().GetUserIDByEmail(gomock.Any(), user.Email, "google").Return(tt.legacyID, nil)
+				} else {
+					db.EXPECT().GetUserIDByEmail(gomock.Any(), user.Email, "google").Return(int64(0), domain.ErrDBUserNotFound)
+				}
+				if tt.legacyID == 0 && tt.autoLink && tt.verified {
+					if tt.emailID != 0 {
+						db.EXPECT().GetUserIDByEmail(gomock.Any(), user.Email, "").Return(tt.emailID, nil)
+					} else {
+						db.EXPECT().GetUserIDByEmail(gomock.Any(), user.Email, "").Return(int64(0), domain.ErrDBUserNotFound)
+					}
+				}
+				if tt.wantUserID == 1 {
+					db.EXPECT().CreateUser(gomock.Any(), user, "google").Return(int64(1), nil)
+				}
+				db.EXPECT().CreateIdentity(gomock.Any(), identity).DoAndReturn(func(_ context.Context, identity *domain.Identity) error {
+					require.Equal(t, tt.wantUserID, identity.UserID)
+					return nil
+				})
+			}
+
+			userID, err := svc.loginIdentity(context.Background(), user, identity)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantUserID, userID)
+		})
+	}
+}
+
+func TestService_LinkIdentity(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		ownerID    int64 // user of the identity
+		legacyID   int64 // user created before identities
+		mergeErr   error
+		createErr  error
+		wantMerged bool
+		wantErr    error
+	}{
+		{"new_identity", 0, 0, nil, nil, false, nil},
+		{"already_linked", 1, 0, nil, nil, false, nil},
+		{"other_user", 2, 0, nil, nil, true, nil},
+		{"legacy_user", 0, 2, nil, nil, true, nil},
+		{"provider_linked", 2, 0, domain.ErrDBIdentityExists, nil, true, domain.ErrIdentityProviderLinked},
+		{"provider_linked_concurrently", 0, 0, nil, domain.ErrDBIdentityExists, false, domain.ErrIdentityProviderLinked},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			db := mock.NewMockdatabase(ctrl)
+			h := mock.NewMockhub(ctrl)
+			svc := New(Config{}, db, nil, h, nil, nil, nil, nil)
+
+			identity := &domain.Identity{Provider: "github", Subject: "subject1", Email: "test@example.com"}
+
+			if tt.ownerID != 0 {
+				db.EXPECT().GetIdentity(gomock.Any(), "github", "subject1").Return(&domain.Identity{UserID: tt.ownerID}, nil)
+			} else {
+				db.EXPECT().GetIdentity(gomock.Any(), "github", "subject1").Return(nil, domain.ErrDBIdentityNotFound)
+				if tt.legacyID != 0 {
+					db.EXPECT().GetUserIDByEmail(gomock.Any(), identity.Email, "github").Return(tt.legacyID, nil)
+				} else {
+					db.EXPECT().GetUserIDByEmail(gomock.Any(), identity.Email, "github").Return(int64(0), domain.ErrDBUserNotFound)
+				}
+			}
+			if tt.wantMerged {
+				if tt.mergeErr != nil {
+					db.EXPECT().MergeUsers(gomock.Any(), int64(2), int64(1), gomock.Any()).Return(nil, tt.mergeErr)
+				} else {
+					db.EXPECT().MergeUsers(gomock.Any(), int64(2), int64(1), gomock.Any()).Return([]string{"session2"}, nil)
+					h.EXPECT().DisconnectSession(int64(2), "session2")
+				}
+			}
+			if tt.ownerID == 0 && tt.mergeErr == nil {
+				db.EXPECT().CreateIdentity(gomock.Any(), identity).DoAndReturn(func(_ context.Context, identity *domain.Identity) error {
+					require.Equal(t, int64(1), identity.UserID)
+					return tt.createErr
+				})
+			}
+
+			err := svc.linkIdentity(context.Background(), 1, identity)
+			require.Equal(t, tt.wantErr, err)
+		})
+	}
+}
+
+func TestService_UnlinkIdentity(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		provider   string
+		identities []domain.Identity
+		wantErr    error
+	}{
+		{"linked", "github", []domain.Identity{{Provider: "google"}, {Provider: "github"}}, nil},
+		{"last_login", "google", []domain.Identity{{Provider: "google"}}, domain.ErrIdentityLastLogin},
+		{"not_linked", "github", []domain.Identity{{Provider: "google"}}, domain.ErrDBIdentityNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			db := mock.NewMockdatabase(ctrl)
+			svc := New(Config{}, db, nil, nil, nil, nil, nil, nil)
+
+			db.EXPECT().GetUserIdentities(gomock.Any(), int64(1)).Return(tt.identities, nil)
+			if tt.wantErr == nil {
+				db.EXPECT().DeleteIdentity(gomock.Any(), int64(1), tt.provider).Return(nil)
+			}
+			err := svc.UnlinkIdentity(context.Background(), 1, tt.provider)
+			require.Equal(t, tt.wantErr, err)
+		})
+	}
+}
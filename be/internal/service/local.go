@@ -0,0 +1,324 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/escalopa/vego/internal/domain"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// argon2id parameters of the new passwords (RFC 9106 second recommended option),
+	// the parameters of a password are kept in its hash
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024 // KiB
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+
+	defaultMinPasswordLength = 12
+	maxPasswordLength        = 256 // bounds the work of hashing a password
+
+	maxConcurrentHashes = 4 // bounds the memory of hashing passwords to 4 * argon2Memory
+
+	// password attempts of logins and registrations, per client ip and per username
+	ipAttemptRate        = rate.Limit(1.0 / 6) // 10 a minute
+	ipAttemptBurst       = 20
+	usernameAttemptRate  = rate.Limit(1.0 / 30) // 2 a minute
+	usernameAttemptBurst = 10
+	maxAttemptKeys       = 10000 // idle keys are dropped past it
+)
+
+var (
+	usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{2,31}$`)
+
+	// dummyPasswordHash is verified against when the username is unknown, so the response
+	// time does not tell which usernames exist
+	dummyPasswordHash = sync.OnceValue(func() string {
+		hash, _ := hashPassword("")
+		return hash
+	})
+)
+
+// RegisterLocalUser creates a local user and logs it in on the device
+func (s *Service) RegisterLocalUser(ctx context.Context, username string, password string, user *domain.User, device domain.Device) (*domain.Token, error) {
+	if !s.ipAttempts.allow(device.IP, time.Now()) {
+		return nil, domain.ErrLocalTooManyAttempts
+	}
+
+	userID, err := s.CreateLocalUser(ctx, username, password, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.createSession(ctx, userID, user.Email, device)
+}
+
+// CreateLocalUser creates a user logging in with the username and password, usernames are case insensitive
+func (s *Service) CreateLocalUser(ctx context.Context, username string, password string, user *domain.User) (int64, error) {
+	username = normalizeUsername(username)
+	if !usernamePattern.MatchString(username) {
+		return 0, domain.ErrLocalInvalidUsername
+	}
+	if err := s.checkPassword(password); err != nil {
+		return 0, err
+	}
+
+	hash, err := s.hashPassword(ctx, password)
+	if err != nil {
+		return 0, err
+	}
+
+	if user.Name == "" {
+		user.Name = username
+	}
+	cred := &domain.LocalCredential{Username: username, PasswordHash: hash, UpdatedAt: time.Now()}
+	return s.db.CreateLocalUser(ctx, user, cred)
+}
+
+// LoginLocalUser logs the local user in on the device, the attempts are limited per ip and per username
+func (s *Service) LoginLocalUser(ctx context.Context, username string, password string, device domain.Device) (*domain.Token, error) {
+	username = normalizeUsername(username)
+
+	now := time.Now()
+	if !s.ipAttempts.allow(device.IP, now) || !s.usernameAttempts.allow(username, now) {
+		return nil, domain.ErrLocalTooManyAttempts
+	}
+
+	cred, err := s.db.GetLocalCredential(ctx, username)
+	if err != nil {
+		if errors.Is(err, domain.ErrDBLocalUserNotFound) {
+			if _, err := s.verifyPassword(ctx, dummyPasswordHash(), password); err != nil {
+				return nil, err
+			}
+			return nil, domain.ErrLocalInvalidCredentials
+		}
+		return nil, err
+	}
+
+	ok, err := s.verifyPassword(ctx, cred.PasswordHash, password)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, domain.ErrLocalInvalidCredentials
+	}
+
+	user, err := s.db.GetUser(ctx, cred.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.createSession(ctx, user.UserID, user.Email, device)
+}
+
+// ChangePassword replaces the password of the local user, the sessions other than the current one
+// and the personal access tokens are revoked
+func (s *Service) ChangePassword(ctx context.Context, userID int64, sessionID string, currentPassword string, password string) error {
+	cred, err := s.db.GetUserLocalCredential(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	ok, err := s.verifyPassword(ctx, cred.PasswordHash, currentPassword)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return domain.ErrLocalInvalidCredentials
+	}
+
+	if err := s.setPassword(ctx, cred.Username, password); err != nil {
+		return err
+	}
+
+	if err := s.db.DeleteUserAccessTokens(ctx, userID); err != nil {
+		return err
+	}
+
+	return s.RevokeOtherSessions(ctx, userID, sessionID)
+}
+
+// ResetPassword replaces the password of the local user on behalf of an operator, all its sessions
+// and personal access tokens are revoked
+func (s *Service) ResetPassword(ctx context.Context, username string, password string) error {
+	cred, err := s.db.GetLocalCredential(ctx, normalizeUsername(username))
+	if err != nil {
+		return err
+	}
+
+	if err := s.setPassword(ctx, cred.Username, password); err != nil {
+		return err
+	}
+
+	if err := s.db.DeleteUserAccessTokens(ctx, cred.UserID); err != nil {
+		return err
+	}
+
+	return s.RevokeOtherSessions(ctx, cred.UserID, "")
+}
+
+func (s *Service) setPassword(ctx context.Context, username string, password string) error {
+	if err := s.checkPassword(password); err != nil {
+		return err
+	}
+
+	hash, err := s.hashPassword(ctx, password)
+	if err != nil {
+		return err
+	}
+
+	return s.db.SetLocalPassword(ctx, username, hash, time.Now())
+}
+
+// hashPassword hashes the password once a hash slot is free
+func (s *Service) hashPassword(ctx context.Context, password string) (string, error) {
+	if err := s.acquireHashSlot(ctx); err != nil {
+		return "", err
+	}
+	defer s.releaseHashSlot()
+
+	return hashPassword(password)
+}
+
+// verifyPassword verifies the password once a hash slot is free
+func (s *Service) verifyPassword(ctx context.Context, hash string, password string) (bool, error) {
+	if err := s.acquireHashSlot(ctx); err != nil {
+		return false, err
+	}
+	defer s.releaseHashSlot()
+
+	return verifyPassword(hash, password), nil
+}
+
+func (s *Service) acquireHashSlot(ctx context.Context) error {
+	select {
+	case s.hashSlots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Service) releaseHashSlot() {
+	<-s.hashSlots
+}
+
+func (s *Service) checkPassword(password string) error {
+	minLength := s.cfg.LocalMinPasswordLength
+	if minLength <= 0 {
+		minLength = defaultMinPasswordLength
+	}
+
+	if n := utf8.RuneCountInString(password); n < minLength || len(password) > maxPasswordLength {
+		return domain.ErrLocalWeakPassword
+	}
+	return nil
+}
+
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// hashPassword returns the argon2id hash of the password in the PHC string format
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// verifyPassword reports whether the password matches the hash, with the parameters of the hash
+func verifyPassword(hash string, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false
+	}
+
+	var (
+		version            int
+		memory, iterations uint32
+		threads            uint8
+	)
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil || iterations == 0 || threads == 0 {
+		return false
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return false
+	}
+
+	other := argon2.IDKey([]byte(password), salt, iterations, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
+
+// attemptLimiter is a token bucket per key, the buckets refilled since their last attempt are
+// dropped once there are too many keys so the limiter does not grow with every client
+type attemptLimiter struct {
+	mu      sync.Mutex
+	limit   rate.Limit
+	burst   int
+	buckets map[string]*attemptBucket
+}
+
+type attemptBucket struct {
+	limiter *rate.Limiter
+	last    time.Time
+}
+
+func newAttemptLimiter(limit rate.Limit, burst int) *attemptLimiter {
+	return &attemptLimiter{limit: limit, burst: burst, buckets: make(map[string]*attemptBucket)}
+}
+
+// allow reports whether the key may attempt at now
+func (l *attemptLimiter) allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxAttemptKeys {
+			l.dropRefilled(now)
+		}
+		b = &attemptBucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.buckets[key] = b
+	}
+
+	b.last = now
+	return b.limiter.AllowN(now, 1)
+}
+
+func (l *attemptLimiter) dropRefilled(now time.Time) {
+	refill := time.Duration(float64(l.burst) / float64(l.limit) * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= refill {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/escalopa/vego/internal/service/mock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+const testPassword = "correct horse battery"
+
+func TestHashPassword(t *testing.T) {
+	t.Parallel()
+
+	hash, err := hashPassword(testPassword)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=3,p=4$"))
+
+	other, err := hashPassword(testPassword)
+	require.NoError(t, err)
+	require.NotEqual(t, hash, other) // salted
+
+	require.True(t, verifyPassword(hash, testPassword))
+	require.False(t, verifyPassword(hash, "wrong horse battery"))
+	require.False(t, verifyPassword("", testPassword))
+	require.False(t, verifyPassword(strings.Replace(hash, "argon2id", "argon2i", 1), testPassword))
+	require.False(t, verifyPassword(strings.Replace(hash, "p=4", "p=0", 1), testPassword))
+
+	// the parameters of the hash are used rather than the current ones
+	weaker := "$argon2id$v=19$m=8,t=1,p=1$c2FsdHNhbHQ$" + strings.Split(hash, "$")[5]
+	require.False(t, verifyPassword(weaker, testPassword))
+}
+
+func TestService_CreateLocalUser(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantErr  error
+	}{
+		{"valid_user", " Alice ", testPassword, nil},
+		{"short_username", "al", testPassword, domain.ErrLocalInvalidUsername},
+		{"invalid_username", "alice smith", testPassword, domain.ErrLocalInvalidUsername},
+		{"short_password", "alice", "short", domain.ErrLocalWeakPassword},
+		{"long_password", "alice", strings.Repeat("a", maxPasswordLength+1), domain.ErrLocalWeakPassword},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			db := mock.NewMockdatabase(ctrl)
+			svc := New(Config{}, db, nil, nil, nil, nil, nil, nil)
+
+			user := &domain.User{Email: "alice@example.com"}
+			if tt.wantErr == nil {
+				db.EXPECT().CreateLocalUser(gomock.Any(), user, gomock.Any()).DoAndReturn(func(_ context.Context, user *domain.User, cred *domain.LocalCredential) (int64, error) {
+					require.Equal(t, "alice", user.Name)
+					require.Equal(t, "alice", cred.Username)
+					require.True(t, verifyPassword(cred.PasswordHash, tt.password))
+					return 1, nil
+				})
+			}
+			userID, err := svc.CreateLocalUser(context.Background(), tt.username, tt.password, user)
+			require.Equal(t, tt.wantErr, err)
+			if tt.wantErr == nil {
+				require.Equal(t, int64(1), userID)
+			}
+		})
+	}
+}
+
+func TestService_LoginLocalUser(t *testing.T) {
+	t.Parallel()
+
+	hash, err := hashPassword(testPassword)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		found    bool
+		wantErr  error
+	}{
+		{"valid_login", "Alice", testPassword, true, nil},
+		{"wrong_password", "alice", "wrong horse battery", true, domain.ErrLocalInvalidCredentials},
+		{"unknown_user", "bob", testPassword, false, domain.ErrLocalInvalidCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			db := mock.NewMockdatabase(ctrl)
+			up := mock.NewMockuserTokenProvider(ctrl)
+			svc := New(Config{}, db, nil, nil, nil, up, nil, nil)
+
+			username := strings.ToLower(tt.username)
+			if tt.found {
+				db.EXPECT().GetLocalCredential(gomock.Any(), username).Return(&domain.LocalCredential{Username: username, UserID: 1, PasswordHash: hash}, nil)
+			} else {
+				db.EXPECT().GetLocalCredential(gomock.Any(), username).Return(nil, domain.ErrDBLocalUserNotFound)
+			}
+			if tt.wantErr == nil {
+				db.EXPECT().GetUser(gomock.Any(), int64(1)).Return(&domain.User{UserID: 1, Email: "alice@example.com"}, nil)
+				up.EXPECT().CreateToken(int64(1), "alice@example.com", gomock.Any(), gomock.Any()).Return(&domain.Token{Refresh: "refresh"}, nil)
+				db.EXPECT().CreateSession(gomock.Any(), gomock.Any()).Return(nil)
+			}
+			token, err := svc.LoginLocalUser(context.Background(), tt.username, tt.password, domain.Device{})
+			require.Equal(t, tt.wantErr, err)
+			if tt.wantErr == nil {
+				require.Equal(t, "refresh", token.Refresh)
+			}
+		})
+	}
+}
+
+func TestService_LoginLocalUser_TooManyAttempts(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := mock.NewMockdatabase(ctrl)
+	svc := New(Config{}, db, nil, nil, nil, nil, nil, nil)
+
+	// the username is limited whatever the ip and its case
+	db.EXPECT().GetLocalCredential(gomock.Any(), "alice").Return(nil, domain.ErrDBLocalUserNotFound).Times(usernameAttemptBurst)
+	for i := range usernameAttemptBurst {
+		_, err := svc.LoginLocalUser(context.Background(), "alice", "wrong horse battery", domain.Device{IP: fmt.Sprintf("10.0.0.%d", i)})
+		require.Equal(t, domain.ErrLocalInvalidCredentials, err)
+	}
+	_, err := svc.LoginLocalUser(context.Background(), "Alice", "wrong horse battery", domain.Device{IP: "10.0.1.1"})
+	require.Equal(t, domain.ErrLocalTooManyAttempts, err)
+
+	// the ip is limited whatever the username
+	svc = New(Config{}, db, nil, nil, nil, nil, nil, nil)
+	db.EXPECT().GetLocalCredential(gomock.Any(), gomock.Any()).Return(nil, domain.ErrDBLocalUserNotFound).Times(ipAttemptBurst)
+	for i := range ipAttemptBurst {
+		_, err := svc.LoginLocalUser(context.Background(), fmt.Sprintf("user%d", i), "wrong horse battery", domain.Device{IP: "10.0.0.1"})
+		require.Equal(t, domain.ErrLocalInvalidCredentials, err)
+	}
+	_, err = svc.LoginLocalUser(context.Background(), "bob", "wrong horse battery", domain.Device{IP: "10.0.0.1"})
+	require.Equal(t, domain.ErrLocalTooManyAttempts, err)
+}
+
+func TestAttemptLimiter(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	l := newAttemptLimiter(rate.Every(time.Minute), 1)
+
+	require.True(t, l.allow("a", now))
+	require.False(t, l.allow("a", now))
+	require.True(t, l.allow("b", now))
+	require.True(t, l.allow("a", now.Add(time.Minute)))
+
+	// the refilled keys are dropped once the limiter is full
+	for i := len(l.buckets); i < maxAttemptKeys; i++ {
+		l.allow(fmt.Sprintf("key%d", i), now)
+	}
+	require.True(t, l.allow("c", now.Add(time.Minute)))
+	require.Len(t, l.buckets, 2) // a was used a minute after the others
+}
+
+func TestService_ChangePassword(t *testing.T) {
+	t.Parallel()
+
+	hash, err := hashPassword(testPassword)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name            string
+		currentPassword string
+		password        string
+		wantErr         error
+	}{
+		{"valid_change", testPassword, "staple horse battery", nil},
+		{"wrong_password", "wrong horse battery", "staple horse battery", domain.ErrLocalInvalidCredentials},
+		{"weak_password", testPassword, "staple", domain.ErrLocalWeakPassword},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			db := mock.NewMockdatabase(ctrl)
+			h := mock.NewMockhub(ctrl)
+			svc := New(Config{}, db, nil, h, nil, nil, nil, nil)
+
+			db.EXPECT().GetUserLocalCredential(gomock.Any(), int64(1)).Return(&domain.LocalCredential{Username: "alice", UserID: 1, PasswordHash: hash}, nil)
+			if tt.wantErr == nil {
+				db.EXPECT().SetLocalPassword(gomock.Any(), "alice", gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, hash string, _ any) error {
+					require.True(t, verifyPassword(hash, tt.password))
+					return nil
+				})
+				db.EXPECT().DeleteUserAccessTokens(gomock.Any(), int64(1)).Return(nil)
+				db.EXPECT().RevokeUserSessions(gomock.Any(), int64(1), "session1", gomock.Any()).Return([]string{"session2"}, nil)
+				h.EXPECT().DisconnectSession(int64(1), "session2")
+			}
+			err := svc.ChangePassword(context.Background(), 1, "session1", tt.currentPassword, tt.password)
+			require.Equal(t, tt.wantErr, err)
+		})
+	}
+}
+
+func TestService_ResetPassword(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := mock.NewMockdatabase(ctrl)
+	h := mock.NewMockhub(ctrl)
+	svc := New(Config{}, db, nil, h, nil, nil, nil, nil)
+
+	db.EXPECT().GetLocalCredential(gomock.Any(), "alice").Return(&domain.LocalCredential{Username: "alice", UserID: 1}, nil)
+	db.EXPECT().SetLocalPassword(gomock.Any(), "alice", gomock.Any(), gomock.Any()).Return(nil)
+	db.EXPECT().DeleteUserAccessTokens(gomock.Any(), int64(1)).Return(nil)
+	db.EXPECT().RevokeUserSessions(gomock.Any(), int64(1), "", gomock.Any()).Return([]string{"session1"}, nil)
+	h.EXPECT().DisconnectSession(int64(1), "session1")
+	require.NoError(t, svc.ResetPassword(context.Background(), "Alice", testPassword))
+}
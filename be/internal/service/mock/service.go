@@ -6,11 +6,12 @@ package mock
 
 import (
 	context "context"
+	io "io"
 	reflect "reflect"
+	time "time"
 
 	domain "github.com/escalopa/vego/internal/domain"
 	gomock "github.com/golang/mock/gomock"
-	websocket "github.com/gorilla/websocket"
 )
 
 // Mockdatabase is a mock of database interface.
@@ -36,6 +37,134 @@ func (m *Mockdatabase) EXPECT() *MockdatabaseMockRecorder {
 	return m.recorder
 }
 
+// AddRoomMember mocks base method.
+func (m *Mockdatabase) AddRoomMember(ctx context.Context, roomID string, userID int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddRoomMember", ctx, roomID, userID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddRoomMember indicates an expected call of AddRoomMember.
+func (mr *MockdatabaseMockRecorder) AddRoomMember(ctx, roomID, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddRoomMember", reflect.TypeOf((*Mockdatabase)(nil).AddRoomMember), ctx, roomID, userID)
+}
+
+// CompleteUpload mocks base method.
+func (m *Mockdatabase) CompleteUpload(ctx context.Context, uploadID string, rec *domain.Recording) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CompleteUpload", ctx, uploadID, rec)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CompleteUpload indicates an expected call of CompleteUpload.
+func (mr *MockdatabaseMockRecorder) CompleteUpload(ctx, uploadID, rec interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompleteUpload", reflect.TypeOf((*Mockdatabase)(nil).CompleteUpload), ctx, uploadID, rec)
+}
+
+// ConsumeOAuthState mocks base method.
+func (m *Mockdatabase) ConsumeOAuthState(ctx context.Context, state string, now time.Time) (*domain.OAuthState, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConsumeOAuthState", ctx, state, now)
+	ret0, _ := ret[0].(*domain.OAuthState)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ConsumeOAuthState indicates an expected call of ConsumeOAuthState.
+func (mr *MockdatabaseMockRecorder) ConsumeOAuthState(ctx, state, now interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConsumeOAuthState", reflect.TypeOf((*Mockdatabase)(nil).ConsumeOAuthState), ctx, state, now)
+}
+
+// CreateAccessToken mocks base method.
+func (m *Mockdatabase) CreateAccessToken(ctx context.Context, token *domain.AccessToken) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateAccessToken", ctx, token)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateAccessToken indicates an expected call of CreateAccessToken.
+func (mr *MockdatabaseMockRecorder) CreateAccessToken(ctx, token interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateAccessToken", reflect.TypeOf((*Mockdatabase)(nil).CreateAccessToken), ctx, token)
+}
+
+// CreateIdentity mocks base method.
+func (m *Mockdatabase) CreateIdentity(ctx context.Context, identity *domain.Identity) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateIdentity", ctx, identity)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateIdentity indicates an expected call of CreateIdentity.
+func (mr *MockdatabaseMockRecorder) CreateIdentity(ctx, identity interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateIdentity", reflect.TypeOf((*Mockdatabase)(nil).CreateIdentity), ctx, identity)
+}
+
+// CreateLocalUser mocks base method.
+func (m *Mockdatabase) CreateLocalUser(ctx context.Context, user *domain.User, cred *domain.LocalCredential) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateLocalUser", ctx, user, cred)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateLocalUser indicates an expected call of CreateLocalUser.
+func (mr *MockdatabaseMockRecorder) CreateLocalUser(ctx, user, cred interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateLocalUser", reflect.TypeOf((*Mockdatabase)(nil).CreateLocalUser), ctx, user, cred)
+}
+
+// CreateOAuthState mocks base method.
+func (m *Mockdatabase) CreateOAuthState(ctx context.Context, state *domain.OAuthState, now time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateOAuthState", ctx, state, now)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateOAuthState indicates an expected call of CreateOAuthState.
+func (mr *MockdatabaseMockRecorder) CreateOAuthState(ctx, state, now interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateOAuthState", reflect.TypeOf((*Mockdatabase)(nil).CreateOAuthState), ctx, state, now)
+}
+
+// CreateSession mocks base method.
+func (m *Mockdatabase) CreateSession(ctx context.Context, session *domain.Session) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateSession", ctx, session)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateSession indicates an expected call of CreateSession.
+func (mr *MockdatabaseMockRecorder) CreateSession(ctx, session interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateSession", reflect.TypeOf((*Mockdatabase)(nil).CreateSession), ctx, session)
+}
+
+// CreateUpload mocks base method.
+func (m *Mockdatabase) CreateUpload(ctx context.Context, upload *domain.Upload) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateUpload", ctx, upload)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateUpload indicates an expected call of CreateUpload.
+func (mr *MockdatabaseMockRecorder) CreateUpload(ctx, upload interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateUpload", reflect.TypeOf((*Mockdatabase)(nil).CreateUpload), ctx, upload)
+}
+
 // CreateUser mocks base method.
 func (m *Mockdatabase) CreateUser(ctx context.Context, user *domain.User, provider string) (int64, error) {
 	m.ctrl.T.Helper()
@@ -51,6 +180,211 @@ func (mr *MockdatabaseMockRecorder) CreateUser(ctx, user, provider interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateUser", reflect.TypeOf((*Mockdatabase)(nil).CreateUser), ctx, user, provider)
 }
 
+// DeleteAccessToken mocks base method.
+func (m *Mockdatabase) DeleteAccessToken(ctx context.Context, userID int64, tokenID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteAccessToken", ctx, userID, tokenID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteAccessToken indicates an expected call of DeleteAccessToken.
+func (mr *MockdatabaseMockRecorder) DeleteAccessToken(ctx, userID, tokenID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteAccessToken", reflect.TypeOf((*Mockdatabase)(nil).DeleteAccessToken), ctx, userID, tokenID)
+}
+
+// DeleteIdentity mocks base method.
+func (m *Mockdatabase) DeleteIdentity(ctx context.Context, userID int64, provider string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteIdentity", ctx, userID, provider)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteIdentity indicates an expected call of DeleteIdentity.
+func (mr *MockdatabaseMockRecorder) DeleteIdentity(ctx, userID, provider interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteIdentity", reflect.TypeOf((*Mockdatabase)(nil).DeleteIdentity), ctx, userID, provider)
+}
+
+// DeleteRecording mocks base method.
+func (m *Mockdatabase) DeleteRecording(ctx context.Context, recordingID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteRecording", ctx, recordingID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteRecording indicates an expected call of DeleteRecording.
+func (mr *MockdatabaseMockRecorder) DeleteRecording(ctx, recordingID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteRecording", reflect.TypeOf((*Mockdatabase)(nil).DeleteRecording), ctx, recordingID)
+}
+
+// DeleteUpload mocks base method.
+func (m *Mockdatabase) DeleteUpload(ctx context.Context, uploadID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteUpload", ctx, uploadID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteUpload indicates an expected call of DeleteUpload.
+func (mr *MockdatabaseMockRecorder) DeleteUpload(ctx, uploadID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUpload", reflect.TypeOf((*Mockdatabase)(nil).DeleteUpload), ctx, uploadID)
+}
+
+// DeleteUserAccessTokens mocks base method.
+func (m *Mockdatabase) DeleteUserAccessTokens(ctx context.Context, userID int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteUserAccessTokens", ctx, userID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteUserAccessTokens indicates an expected call of DeleteUserAccessTokens.
+func (mr *MockdatabaseMockRecorder) DeleteUserAccessTokens(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUserAccessTokens", reflect.TypeOf((*Mockdatabase)(nil).DeleteUserAccessTokens), ctx, userID)
+}
+
+// GetAccessTokenByHash mocks base method.
+func (m *Mockdatabase) GetAccessTokenByHash(ctx context.Context, tokenHash string) (*domain.AccessToken, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAccessTokenByHash", ctx, tokenHash)
+	ret0, _ := ret[0].(*domain.AccessToken)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAccessTokenByHash indicates an expected call of GetAccessTokenByHash.
+func (mr *MockdatabaseMockRecorder) GetAccessTokenByHash(ctx, tokenHash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAccessTokenByHash", reflect.TypeOf((*Mockdatabase)(nil).GetAccessTokenByHash), ctx, tokenHash)
+}
+
+// GetExpiredRecordings mocks base method.
+func (m *Mockdatabase) GetExpiredRecordings(ctx context.Context, now time.Time, defaultRetention time.Duration) ([]domain.Recording, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetExpiredRecordings", ctx, now, defaultRetention)
+	ret0, _ := ret[0].([]domain.Recording)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetExpiredRecordings indicates an expected call of GetExpiredRecordings.
+func (mr *MockdatabaseMockRecorder) GetExpiredRecordings(ctx, now, defaultRetention interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetExpiredRecordings", reflect.TypeOf((*Mockdatabase)(nil).GetExpiredRecordings), ctx, now, defaultRetention)
+}
+
+// GetIdentity mocks base method.
+func (m *Mockdatabase) GetIdentity(ctx context.Context, provider, subject string) (*domain.Identity, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetIdentity", ctx, provider, subject)
+	ret0, _ := ret[0].(*domain.Identity)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetIdentity indicates an expected call of GetIdentity.
+func (mr *MockdatabaseMockRecorder) GetIdentity(ctx, provider, subject interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetIdentity", reflect.TypeOf((*Mockdatabase)(nil).GetIdentity), ctx, provider, subject)
+}
+
+// GetLocalCredential mocks base method.
+func (m *Mockdatabase) GetLocalCredential(ctx context.Context, username string) (*domain.LocalCredential, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetLocalCredential", ctx, username)
+	ret0, _ := ret[0].(*domain.LocalCredential)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetLocalCredential indicates an expected call of GetLocalCredential.
+func (mr *MockdatabaseMockRecorder) GetLocalCredential(ctx, username interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLocalCredential", reflect.TypeOf((*Mockdatabase)(nil).GetLocalCredential), ctx, username)
+}
+
+// GetRecording mocks base method.
+func (m *Mockdatabase) GetRecording(ctx context.Context, recordingID string) (*domain.Recording, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRecording", ctx, recordingID)
+	ret0, _ := ret[0].(*domain.Recording)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRecording indicates an expected call of GetRecording.
+func (mr *MockdatabaseMockRecorder) GetRecording(ctx, recordingID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRecording", reflect.TypeOf((*Mockdatabase)(nil).GetRecording), ctx, recordingID)
+}
+
+// GetRoom mocks base method.
+func (m *Mockdatabase) GetRoom(ctx context.Context, roomID string) (*domain.Room, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRoom", ctx, roomID)
+	ret0, _ := ret[0].(*domain.Room)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRoom indicates an expected call of GetRoom.
+func (mr *MockdatabaseMockRecorder) GetRoom(ctx, roomID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRoom", reflect.TypeOf((*Mockdatabase)(nil).GetRoom), ctx, roomID)
+}
+
+// GetSession mocks base method.
+func (m *Mockdatabase) GetSession(ctx context.Context, sessionID string) (*domain.Session, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSession", ctx, sessionID)
+	ret0, _ := ret[0].(*domain.Session)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSession indicates an expected call of GetSession.
+func (mr *MockdatabaseMockRecorder) GetSession(ctx, sessionID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSession", reflect.TypeOf((*Mockdatabase)(nil).GetSession), ctx, sessionID)
+}
+
+// GetStaleUploads mocks base method.
+func (m *Mockdatabase) GetStaleUploads(ctx context.Context, before time.Time) ([]domain.Upload, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetStaleUploads", ctx, before)
+	ret0, _ := ret[0].([]domain.Upload)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetStaleUploads indicates an expected call of GetStaleUploads.
+func (mr *MockdatabaseMockRecorder) GetStaleUploads(ctx, before interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetStaleUploads", reflect.TypeOf((*Mockdatabase)(nil).GetStaleUploads), ctx, before)
+}
+
+// GetUpload mocks base method.
+func (m *Mockdatabase) GetUpload(ctx context.Context, uploadID string) (*domain.Upload, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUpload", ctx, uploadID)
+	ret0, _ := ret[0].(*domain.Upload)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUpload indicates an expected call of GetUpload.
+func (mr *MockdatabaseMockRecorder) GetUpload(ctx, uploadID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUpload", reflect.TypeOf((*Mockdatabase)(nil).GetUpload), ctx, uploadID)
+}
+
 // GetUser mocks base method.
 func (m *Mockdatabase) GetUser(ctx context.Context, userID int64) (*domain.User, error) {
 	m.ctrl.T.Helper()
@@ -66,6 +400,336 @@ func (mr *MockdatabaseMockRecorder) GetUser(ctx, userID interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUser", reflect.TypeOf((*Mockdatabase)(nil).GetUser), ctx, userID)
 }
 
+// GetUserAccessTokens mocks base method.
+func (m *Mockdatabase) GetUserAccessTokens(ctx context.Context, userID int64) ([]domain.AccessToken, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserAccessTokens", ctx, userID)
+	ret0, _ := ret[0].([]domain.AccessToken)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserAccessTokens indicates an expected call of GetUserAccessTokens.
+func (mr *MockdatabaseMockRecorder) GetUserAccessTokens(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserAccessTokens", reflect.TypeOf((*Mockdatabase)(nil).GetUserAccessTokens), ctx, userID)
+}
+
+// GetUserIDByEmail mocks base method.
+func (m *Mockdatabase) GetUserIDByEmail(ctx context.Context, email, provider string) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserIDByEmail", ctx, email, provider)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserIDByEmail indicates an expected call of GetUserIDByEmail.
+func (mr *MockdatabaseMockRecorder) GetUserIDByEmail(ctx, email, provider interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserIDByEmail", reflect.TypeOf((*Mockdatabase)(nil).GetUserIDByEmail), ctx, email, provider)
+}
+
+// GetUserIdentities mocks base method.
+func (m *Mockdatabase) GetUserIdentities(ctx context.Context, userID int64) ([]domain.Identity, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserIdentities", ctx, userID)
+	ret0, _ := ret[0].([]domain.Identity)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserIdentities indicates an expected call of GetUserIdentities.
+func (mr *MockdatabaseMockRecorder) GetUserIdentities(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserIdentities", reflect.TypeOf((*Mockdatabase)(nil).GetUserIdentities), ctx, userID)
+}
+
+// GetUserLocalCredential mocks base method.
+func (m *Mockdatabase) GetUserLocalCredential(ctx context.Context, userID int64) (*domain.LocalCredential, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserLocalCredential", ctx, userID)
+	ret0, _ := ret[0].(*domain.LocalCredential)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserLocalCredential indicates an expected call of GetUserLocalCredential.
+func (mr *MockdatabaseMockRecorder) GetUserLocalCredential(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserLocalCredential", reflect.TypeOf((*Mockdatabase)(nil).GetUserLocalCredential), ctx, userID)
+}
+
+// GetUserRecordings mocks base method.
+func (m *Mockdatabase) GetUserRecordings(ctx context.Context, userID int64) ([]domain.Recording, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserRecordings", ctx, userID)
+	ret0, _ := ret[0].([]domain.Recording)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserRecordings indicates an expected call of GetUserRecordings.
+func (mr *MockdatabaseMockRecorder) GetUserRecordings(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserRecordings", reflect.TypeOf((*Mockdatabase)(nil).GetUserRecordings), ctx, userID)
+}
+
+// GetUserSessions mocks base method.
+func (m *Mockdatabase) GetUserSessions(ctx context.Context, userID int64, now time.Time) ([]domain.Session, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserSessions", ctx, userID, now)
+	ret0, _ := ret[0].([]domain.Session)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserSessions indicates an expected call of GetUserSessions.
+func (mr *MockdatabaseMockRecorder) GetUserSessions(ctx, userID, now interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserSessions", reflect.TypeOf((*Mockdatabase)(nil).GetUserSessions), ctx, userID, now)
+}
+
+// GetUserUploads mocks base method.
+func (m *Mockdatabase) GetUserUploads(ctx context.Context, userID int64) ([]domain.Upload, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserUploads", ctx, userID)
+	ret0, _ := ret[0].([]domain.Upload)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserUploads indicates an expected call of GetUserUploads.
+func (mr *MockdatabaseMockRecorder) GetUserUploads(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserUploads", reflect.TypeOf((*Mockdatabase)(nil).GetUserUploads), ctx, userID)
+}
+
+// IsRoomAttendee mocks base method.
+func (m *Mockdatabase) IsRoomAttendee(ctx context.Context, roomID string, userID int64, at time.Time) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsRoomAttendee", ctx, roomID, userID, at)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsRoomAttendee indicates an expected call of IsRoomAttendee.
+func (mr *MockdatabaseMockRecorder) IsRoomAttendee(ctx, roomID, userID, at interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsRoomAttendee", reflect.TypeOf((*Mockdatabase)(nil).IsRoomAttendee), ctx, roomID, userID, at)
+}
+
+// IsRoomMember mocks base method.
+func (m *Mockdatabase) IsRoomMember(ctx context.Context, roomID string, userID int64) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsRoomMember", ctx, roomID, userID)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsRoomMember indicates an expected call of IsRoomMember.
+func (mr *MockdatabaseMockRecorder) IsRoomMember(ctx, roomID, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsRoomMember", reflect.TypeOf((*Mockdatabase)(nil).IsRoomMember), ctx, roomID, userID)
+}
+
+// MergeUsers mocks base method.
+func (m *Mockdatabase) MergeUsers(ctx context.Context, fromUserID, toUserID int64, at time.Time) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MergeUsers", ctx, fromUserID, toUserID, at)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MergeUsers indicates an expected call of MergeUsers.
+func (mr *MockdatabaseMockRecorder) MergeUsers(ctx, fromUserID, toUserID, at interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MergeUsers", reflect.TypeOf((*Mockdatabase)(nil).MergeUsers), ctx, fromUserID, toUserID, at)
+}
+
+// RevokeSession mocks base method.
+func (m *Mockdatabase) RevokeSession(ctx context.Context, userID int64, sessionID string, at time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeSession", ctx, userID, sessionID, at)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeSession indicates an expected call of RevokeSession.
+func (mr *MockdatabaseMockRecorder) RevokeSession(ctx, userID, sessionID, at interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeSession", reflect.TypeOf((*Mockdatabase)(nil).RevokeSession), ctx, userID, sessionID, at)
+}
+
+// RevokeUserSessions mocks base method.
+func (m *Mockdatabase) RevokeUserSessions(ctx context.Context, userID int64, keepSessionID string, at time.Time) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeUserSessions", ctx, userID, keepSessionID, at)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RevokeUserSessions indicates an expected call of RevokeUserSessions.
+func (mr *MockdatabaseMockRecorder) RevokeUserSessions(ctx, userID, keepSessionID, at interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeUserSessions", reflect.TypeOf((*Mockdatabase)(nil).RevokeUserSessions), ctx, userID, keepSessionID, at)
+}
+
+// RotateSession mocks base method.
+func (m *Mockdatabase) RotateSession(ctx context.Context, session *domain.Session) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RotateSession", ctx, session)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RotateSession indicates an expected call of RotateSession.
+func (mr *MockdatabaseMockRecorder) RotateSession(ctx, session interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RotateSession", reflect.TypeOf((*Mockdatabase)(nil).RotateSession), ctx, session)
+}
+
+// SetLocalPassword mocks base method.
+func (m *Mockdatabase) SetLocalPassword(ctx context.Context, username, passwordHash string, at time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetLocalPassword", ctx, username, passwordHash, at)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetLocalPassword indicates an expected call of SetLocalPassword.
+func (mr *MockdatabaseMockRecorder) SetLocalPassword(ctx, username, passwordHash, at interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetLocalPassword", reflect.TypeOf((*Mockdatabase)(nil).SetLocalPassword), ctx, username, passwordHash, at)
+}
+
+// SetRoomRetention mocks base method.
+func (m *Mockdatabase) SetRoomRetention(ctx context.Context, roomID string, retention time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetRoomRetention", ctx, roomID, retention)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetRoomRetention indicates an expected call of SetRoomRetention.
+func (mr *MockdatabaseMockRecorder) SetRoomRetention(ctx, roomID, retention interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetRoomRetention", reflect.TypeOf((*Mockdatabase)(nil).SetRoomRetention), ctx, roomID, retention)
+}
+
+// SetUploadOffset mocks base method.
+func (m *Mockdatabase) SetUploadOffset(ctx context.Context, uploadID string, offset int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetUploadOffset", ctx, uploadID, offset)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetUploadOffset indicates an expected call of SetUploadOffset.
+func (mr *MockdatabaseMockRecorder) SetUploadOffset(ctx, uploadID, offset interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetUploadOffset", reflect.TypeOf((*Mockdatabase)(nil).SetUploadOffset), ctx, uploadID, offset)
+}
+
+// TouchAccessToken mocks base method.
+func (m *Mockdatabase) TouchAccessToken(ctx context.Context, tokenID string, at time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TouchAccessToken", ctx, tokenID, at)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TouchAccessToken indicates an expected call of TouchAccessToken.
+func (mr *MockdatabaseMockRecorder) TouchAccessToken(ctx, tokenID, at interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TouchAccessToken", reflect.TypeOf((*Mockdatabase)(nil).TouchAccessToken), ctx, tokenID, at)
+}
+
+// TouchSession mocks base method.
+func (m *Mockdatabase) TouchSession(ctx context.Context, sessionID, ip string, at time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TouchSession", ctx, sessionID, ip, at)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TouchSession indicates an expected call of TouchSession.
+func (mr *MockdatabaseMockRecorder) TouchSession(ctx, sessionID, ip, at interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TouchSession", reflect.TypeOf((*Mockdatabase)(nil).TouchSession), ctx, sessionID, ip, at)
+}
+
+// Mockstorage is a mock of storage interface.
+type Mockstorage struct {
+	ctrl     *gomock.Controller
+	recorder *MockstorageMockRecorder
+}
+
+// MockstorageMockRecorder is the mock recorder for Mockstorage.
+type MockstorageMockRecorder struct {
+	mock *Mockstorage
+}
+
+// NewMockstorage creates a new mock instance.
+func NewMockstorage(ctrl *gomock.Controller) *Mockstorage {
+	mock := &Mockstorage{ctrl: ctrl}
+	mock.recorder = &MockstorageMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *Mockstorage) EXPECT() *MockstorageMockRecorder {
+	return m.recorder
+}
+
+// Append mocks base method.
+func (m *Mockstorage) Append(name string, offset int64, content io.Reader) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Append", name, offset, content)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Append indicates an expected call of Append.
+func (mr *MockstorageMockRecorder) Append(name, offset, content interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Append", reflect.TypeOf((*Mockstorage)(nil).Append), name, offset, content)
+}
+
+// Open mocks base method.
+func (m *Mockstorage) Open(name string) (io.ReadSeekCloser, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Open", name)
+	ret0, _ := ret[0].(io.ReadSeekCloser)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Open indicates an expected call of Open.
+func (mr *MockstorageMockRecorder) Open(name interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Open", reflect.TypeOf((*Mockstorage)(nil).Open), name)
+}
+
+// Remove mocks base method.
+func (m *Mockstorage) Remove(name string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Remove", name)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Remove indicates an expected call of Remove.
+func (mr *MockstorageMockRecorder) Remove(name interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Remove", reflect.TypeOf((*Mockstorage)(nil).Remove), name)
+}
+
 // MockuserTokenProvider is a mock of userTokenProvider interface.
 type MockuserTokenProvider struct {
 	ctrl     *gomock.Controller
@@ -90,18 +754,47 @@ func (m *MockuserTokenProvider) EXPECT() *MockuserTokenProviderMockRecorder {
 }
 
 // CreateToken mocks base method.
-func (m *MockuserTokenProvider) CreateToken(userID int64, email string) (*domain.Token, error) {
+func (m *MockuserTokenProvider) CreateToken(userID int64, email, sessionID, tokenID string) (*domain.Token, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateToken", userID, email)
+	ret := m.ctrl.Call(m, "CreateToken", userID, email, sessionID, tokenID)
 	ret0, _ := ret[0].(*domain.Token)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // CreateToken indicates an expected call of CreateToken.
-func (mr *MockuserTokenProviderMockRecorder) CreateToken(userID, email interface{}) *gomock.Call {
+func (mr *MockuserTokenProviderMockRecorder) CreateToken(userID, email, sessionID, tokenID interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateToken", reflect.TypeOf((*MockuserTokenProvider)(nil).CreateToken), userID, email)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateToken", reflect.TypeOf((*MockuserTokenProvider)(nil).CreateToken), userID, email, sessionID, tokenID)
+}
+
+// PublicKeys mocks base method.
+func (m *MockuserTokenProvider) PublicKeys() []domain.JWK {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PublicKeys")
+	ret0, _ := ret[0].([]domain.JWK)
+	return ret0
+}
+
+// PublicKeys indicates an expected call of PublicKeys.
+func (mr *MockuserTokenProviderMockRecorder) PublicKeys() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PublicKeys", reflect.TypeOf((*MockuserTokenProvider)(nil).PublicKeys))
+}
+
+// VerifyRefreshToken mocks base method.
+func (m *MockuserTokenProvider) VerifyRefreshToken(token string) (*domain.UserTokenPayload, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "VerifyRefreshToken", token)
+	ret0, _ := ret[0].(*domain.UserTokenPayload)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// VerifyRefreshToken indicates an expected call of VerifyRefreshToken.
+func (mr *MockuserTokenProviderMockRecorder) VerifyRefreshToken(token interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VerifyRefreshToken", reflect.TypeOf((*MockuserTokenProvider)(nil).VerifyRefreshToken), token)
 }
 
 // VerifyToken mocks base method.
@@ -143,18 +836,18 @@ func (m *MockroomTokenProvider) EXPECT() *MockroomTokenProviderMockRecorder {
 }
 
 // CreateToken mocks base method.
-func (m *MockroomTokenProvider) CreateToken(userID int64, roomID string) (string, error) {
+func (m *MockroomTokenProvider) CreateToken(userID int64, roomID, sessionID string) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateToken", userID, roomID)
+	ret := m.ctrl.Call(m, "CreateToken", userID, roomID, sessionID)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // CreateToken indicates an expected call of CreateToken.
-func (mr *MockroomTokenProviderMockRecorder) CreateToken(userID, roomID interface{}) *gomock.Call {
+func (mr *MockroomTokenProviderMockRecorder) CreateToken(userID, roomID, sessionID interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateToken", reflect.TypeOf((*MockroomTokenProvider)(nil).CreateToken), userID, roomID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateToken", reflect.TypeOf((*MockroomTokenProvider)(nil).CreateToken), userID, roomID, sessionID)
 }
 
 // VerifyToken mocks base method.
@@ -195,16 +888,122 @@ func (m *Mockhub) EXPECT() *MockhubMockRecorder {
 	return m.recorder
 }
 
+// Admit mocks base method.
+func (m *Mockhub) Admit(userID int64, roomID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Admit", userID, roomID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Admit indicates an expected call of Admit.
+func (mr *MockhubMockRecorder) Admit(userID, roomID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Admit", reflect.TypeOf((*Mockhub)(nil).Admit), userID, roomID)
+}
+
+// DisconnectSession mocks base method.
+func (m *Mockhub) DisconnectSession(userID int64, sessionID string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "DisconnectSession", userID, sessionID)
+}
+
+// DisconnectSession indicates an expected call of DisconnectSession.
+func (mr *MockhubMockRecorder) DisconnectSession(userID, sessionID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DisconnectSession", reflect.TypeOf((*Mockhub)(nil).DisconnectSession), userID, sessionID)
+}
+
 // Handle mocks base method.
-func (m *Mockhub) Handle(user *domain.User, roomID string, conn *websocket.Conn) {
+func (m *Mockhub) Handle(user *domain.User, roomID string, client domain.Client, conn domain.Conn, iceServers []domain.ICEServer) {
 	m.ctrl.T.Helper()
-	m.ctrl.Call(m, "Handle", user, roomID, conn)
+	m.ctrl.Call(m, "Handle", user, roomID, client, conn, iceServers)
 }
 
 // Handle indicates an expected call of Handle.
-func (mr *MockhubMockRecorder) Handle(user, roomID, conn interface{}) *gomock.Call {
+func (mr *MockhubMockRecorder) Handle(user, roomID, client, conn, iceServers interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Handle", reflect.TypeOf((*Mockhub)(nil).Handle), user, roomID, client, conn, iceServers)
+}
+
+// Schema mocks base method.
+func (m *Mockhub) Schema() map[string]any {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Schema")
+	ret0, _ := ret[0].(map[string]any)
+	return ret0
+}
+
+// Schema indicates an expected call of Schema.
+func (mr *MockhubMockRecorder) Schema() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Schema", reflect.TypeOf((*Mockhub)(nil).Schema))
+}
+
+// Stats mocks base method.
+func (m *Mockhub) Stats() domain.RoomStats {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Stats")
+	ret0, _ := ret[0].(domain.RoomStats)
+	return ret0
+}
+
+// Stats indicates an expected call of Stats.
+func (mr *MockhubMockRecorder) Stats() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stats", reflect.TypeOf((*Mockhub)(nil).Stats))
+}
+
+// Subprotocols mocks base method.
+func (m *Mockhub) Subprotocols() []string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Subprotocols")
+	ret0, _ := ret[0].([]string)
+	return ret0
+}
+
+// Subprotocols indicates an expected call of Subprotocols.
+func (mr *MockhubMockRecorder) Subprotocols() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Subprotocols", reflect.TypeOf((*Mockhub)(nil).Subprotocols))
+}
+
+// MockiceProvider is a mock of iceProvider interface.
+type MockiceProvider struct {
+	ctrl     *gomock.Controller
+	recorder *MockiceProviderMockRecorder
+}
+
+// MockiceProviderMockRecorder is the mock recorder for MockiceProvider.
+type MockiceProviderMockRecorder struct {
+	mock *MockiceProvider
+}
+
+// NewMockiceProvider creates a new mock instance.
+func NewMockiceProvider(ctrl *gomock.Controller) *MockiceProvider {
+	mock := &MockiceProvider{ctrl: ctrl}
+	mock.recorder = &MockiceProviderMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockiceProvider) EXPECT() *MockiceProviderMockRecorder {
+	return m.recorder
+}
+
+// CreateICEServers mocks base method.
+func (m *MockiceProvider) CreateICEServers(userID int64) ([]domain.ICEServer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateICEServers", userID)
+	ret0, _ := ret[0].([]domain.ICEServer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateICEServers indicates an expected call of CreateICEServers.
+func (mr *MockiceProviderMockRecorder) CreateICEServers(userID interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Handle", reflect.TypeOf((*Mockhub)(nil).Handle), user, roomID, conn)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateICEServers", reflect.TypeOf((*MockiceProvider)(nil).CreateICEServers), userID)
 }
 
 // MockoauthProvider is a mock of oauthProvider interface.
@@ -231,12 +1030,13 @@ func (m *MockoauthProvider) EXPECT() *MockoauthProviderMockRecorder {
 }
 
 // GetRedirectURL mocks base method.
-func (m *MockoauthProvider) GetRedirectURL(provider string) (string, error) {
+func (m *MockoauthProvider) GetRedirectURL(provider string) (string, *domain.OAuthState, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GetRedirectURL", provider)
 	ret0, _ := ret[0].(string)
-	ret1, _ := ret[1].(error)
-	return ret0, ret1
+	ret1, _ := ret[1].(*domain.OAuthState)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
 }
 
 // GetRedirectURL indicates an expected call of GetRedirectURL.
@@ -246,16 +1046,31 @@ func (mr *MockoauthProviderMockRecorder) GetRedirectURL(provider interface{}) *g
 }
 
 // HandleCallback mocks base method.
-func (m *MockoauthProvider) HandleCallback(ctx context.Context, provider, code string) (*domain.User, error) {
+func (m *MockoauthProvider) HandleCallback(ctx context.Context, provider, code, verifier string) (*domain.User, *domain.Identity, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "HandleCallback", ctx, provider, code)
+	ret := m.ctrl.Call(m, "HandleCallback", ctx, provider, code, verifier)
 	ret0, _ := ret[0].(*domain.User)
-	ret1, _ := ret[1].(error)
-	return ret0, ret1
+	ret1, _ := ret[1].(*domain.Identity)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
 }
 
 // HandleCallback indicates an expected call of HandleCallback.
-func (mr *MockoauthProviderMockRecorder) HandleCallback(ctx, provider, code interface{}) *gomock.Call {
+func (mr *MockoauthProviderMockRecorder) HandleCallback(ctx, provider, code, verifier interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HandleCallback", reflect.TypeOf((*MockoauthProvider)(nil).HandleCallback), ctx, provider, code, verifier)
+}
+
+// Providers mocks base method.
+func (m *MockoauthProvider) Providers() []domain.OAuthProvider {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Providers")
+	ret0, _ := ret[0].([]domain.OAuthProvider)
+	return ret0
+}
+
+// Providers indicates an expected call of Providers.
+func (mr *MockoauthProviderMockRecorder) Providers() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HandleCallback", reflect.TypeOf((*MockoauthProvider)(nil).HandleCallback), ctx, provider, code)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Providers", reflect.TypeOf((*MockoauthProvider)(nil).Providers))
 }
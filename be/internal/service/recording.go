@@ -0,0 +1,162 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+)
+
+func (s *Service) SetRoomRetention(ctx context.Context, userID int64, roomID string, retention time.Duration) error {
+	room, err := s.db.GetRoom(ctx, roomID)
+	if err != nil {
+		return err
+	}
+
+	if room.OwnerID != userID {
+		return domain.ErrRoomAccessDenied
+	}
+
+	return s.db.SetRoomRetention(ctx, roomID, retention)
+}
+
+func (s *Service) ListRecordings(ctx context.Context, userID int64) ([]domain.Recording, error) {
+	return s.db.GetUserRecordings(ctx, userID)
+}
+
+// GetRecording returns the recording if the user uploaded it, owns the room it was recorded in
+// or attended it
+func (s *Service) GetRecording(ctx context.Context, userID int64, recordingID string) (*domain.Recording, error) {
+	rec, err := s.db.GetRecording(ctx, recordingID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkRecordingAccess(ctx, userID, rec, true); err != nil {
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+// OpenRecording returns the recording along with its content, the caller must close the content
+func (s *Service) OpenRecording(ctx context.Context, userID int64, recordingID string) (*domain.Recording, io.ReadSeekCloser, error) {
+	rec, err := s.GetRecording(ctx, userID, recordingID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	content, err := s.storage.Open(rec.File)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return rec, content, nil
+}
+
+// DeleteRecording removes the recording, only the room owner and the uploader are allowed to
+func (s *Service) DeleteRecording(ctx context.Context, userID int64, recordingID string) error {
+	rec, err := s.db.GetRecording(ctx, recordingID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkRecordingAccess(ctx, userID, rec, false); err != nil {
+		return err
+	}
+
+	return s.deleteRecording(ctx, rec)
+}
+
+// checkRecordingAccess allows the uploader of the recording, the owner of its room and, with attendees,
+// the members who joined the room before the recording was made. Joining the room afterwards is not
+// enough as any user knowing the room id can join it
+func (s *Service) checkRecordingAccess(ctx context.Context, userID int64, rec *domain.Recording, attendees bool) error {
+	if rec.UserID == userID {
+		return nil
+	}
+
+	room, err := s.db.GetRoom(ctx, rec.RoomID)
+	if err != nil {
+		if errors.Is(err, domain.ErrDBRoomNotFound) {
+			return domain.ErrRecordingAccessDenied
+		}
+		return err
+	}
+
+	if room.OwnerID == userID {
+		return nil
+	}
+
+	if !attendees {
+		return domain.ErrRecordingAccessDenied
+	}
+
+	ok, err := s.db.IsRoomAttendee(ctx, rec.RoomID, userID, rec.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return domain.ErrRecordingAccessDenied
+	}
+
+	return nil
+}
+
+// PurgeRecordings deletes all recordings that outlived their room retention
+func (s *Service) PurgeRecordings(ctx context.Context) error {
+	recs, err := s.db.GetExpiredRecordings(ctx, time.Now(), s.cfg.RecordingRetention)
+	if err != nil {
+		return err
+	}
+
+	for i := range recs {
+		if err := s.deleteRecording(ctx, &recs[i]); err != nil {
+			log.Printf("service.PurgeRecordings: delete recording %s: %v", recs[i].RecordingID, err)
+		}
+	}
+
+	return nil
+}
+
+// RunRecordingsPurge purges expired recordings and stale uploads periodically until ctx is done
+func (s *Service) RunRecordingsPurge(ctx context.Context) {
+	if s.cfg.RecordingPurgeInterval <= 0 {
+		log.Printf("service.RunRecordingsPurge: purge interval is not set, recordings are kept forever")
+		return
+	}
+
+	ticker := time.NewTicker(s.cfg.RecordingPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.PurgeRecordings(ctx); err != nil {
+				log.Printf("service.RunRecordingsPurge: %v", err)
+			}
+			if err := s.PurgeUploads(ctx); err != nil {
+				log.Printf("service.RunRecordingsPurge: uploads: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// deleteRecording removes the row before the file, a failed removal leaves an orphan
+// file rather than a recording without content
+func (s *Service) deleteRecording(ctx context.Context, rec *domain.Recording) error {
+	if err := s.db.DeleteRecording(ctx, rec.RecordingID); err != nil {
+		return err
+	}
+
+	if err := s.storage.Remove(rec.File); err != nil {
+		log.Printf("service.deleteRecording: remove file %s: %v", rec.File, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/escalopa/vego/internal/service/mock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_GetRecording(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		userID   int64
+		ownerID  int64
+		attended bool
+		wantErr  error
+	}{
+		{"uploader", 2, 1, false, nil},
+		{"room_owner", 1, 1, false, nil},
+		{"attendee", 3, 1, true, nil},
+		{"joined_after", 3, 1, false, domain.ErrRecordingAccessDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			db := mock.NewMockdatabase(ctrl)
+			svc := New(Config{}, db, nil, nil, nil, nil, nil, nil)
+
+			rec := &domain.Recording{RecordingID: "rec1", RoomID: "room1", UserID: 2, CreatedAt: time.Now()}
+			db.EXPECT().GetRecording(gomock.Any(), rec.RecordingID).Return(rec, nil)
+			if tt.userID != rec.UserID {
+				db.EXPECT().GetRoom(gomock.Any(), rec.RoomID).Return(&domain.Room{RoomID: rec.RoomID, OwnerID: tt.ownerID}, nil)
+			}
+			if tt.userID != rec.UserID && tt.userID != tt.ownerID {
+				db.EXPECT().IsRoomAttendee(gomock.Any(), rec.RoomID, tt.userID, rec.CreatedAt).Return(tt.attended, nil)
+			}
+
+			_, err := svc.GetRecording(context.Background(), tt.userID, rec.RecordingID)
+			require.Equal(t, tt.wantErr, err)
+		})
+	}
+}
+
+func TestService_DeleteRecording(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		userID  int64
+		ownerID int64
+		wantErr error
+	}{
+		{"uploader", 2, 1, nil},
+		{"room_owner", 1, 1, nil},
+		{"attendee", 3, 1, domain.ErrRecordingAccessDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			db := mock.NewMockdatabase(ctrl)
+			st := mock.NewMockstorage(ctrl)
+			svc := New(Config{}, db, st, nil, nil, nil, nil, nil)
+
+			rec := &domain.Recording{RecordingID: "rec1", RoomID: "room1", UserID: 2, File: "rec1.webm"}
+			db.EXPECT().GetRecording(gomock.Any(), rec.RecordingID).Return(rec, nil)
+			if tt.userID != rec.UserID {
+				db.EXPECT().GetRoom(gomock.Any(), rec.RoomID).Return(&domain.Room{RoomID: rec.RoomID, OwnerID: tt.ownerID}, nil)
+			}
+			if tt.wantErr == nil {
+				gomock.InOrder(
+					db.EXPECT().DeleteRecording(gomock.Any(), rec.RecordingID).Return(nil),
+					st.EXPECT().Remove(rec.File).Return(nil),
+				)
+			}
+
+			err := svc.DeleteRecording(context.Background(), tt.userID, rec.RecordingID)
+			require.Equal(t, tt.wantErr, err)
+		})
+	}
+}
+
+func TestService_PurgeRecordings(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := mock.NewMockdatabase(ctrl)
+	st := mock.NewMockstorage(ctrl)
+	svc := New(Config{RecordingRetention: 1}, db, st, nil, nil, nil, nil, nil)
+
+	recs := []domain.Recording{
+		{RecordingID: "rec1", File: "rec1.webm"},
+		{RecordingID: "rec2", File: "rec2.webm"},
+	}
+	db.EXPECT().GetExpiredRecordings(gomock.Any(), gomock.Any(), svc.cfg.RecordingRetention).Return(recs, nil)
+	for _, rec := range recs {
+		db.EXPECT().DeleteRecording(gomock.Any(), rec.RecordingID).Return(nil)
+		st.EXPECT().Remove(rec.File).Return(nil)
+	}
+
+	require.NoError(t, svc.PurgeRecordings(context.Background()))
+}
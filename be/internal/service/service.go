@@ -2,145 +2,354 @@ package service
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
 
 	"github.com/escalopa/vego/internal/domain"
-	"github.com/gorilla/websocket"
+	"github.com/google/uuid"
 )
 
 type (
 	database interface {
 		GetUser(ctx context.Context, userID int64) (*domain.User, error)
 		CreateUser(ctx context.Context, user *domain.User, provider string) (int64, error)
+
+		CreateSession(ctx context.Context, session *domain.Session) error
+		GetSession(ctx context.Context, sessionID string) (*domain.Session, error)
+		GetUserSessions(ctx context.Context, userID int64, now time.Time) ([]domain.Session, error)
+		RotateSession(ctx context.Context, session *domain.Session) error
+		TouchSession(ctx context.Context, sessionID string, ip string, at time.Time) error
+		RevokeSession(ctx context.Context, userID int64, sessionID string, at time.Time) error
+		RevokeUserSessions(ctx context.Context, userID int64, keepSessionID string, at time.Time) ([]string, error)
+
+		CreateAccessToken(ctx context.Context, token *domain.AccessToken) error
+		GetAccessTokenByHash(ctx context.Context, tokenHash string) (*domain.AccessToken, error)
+		GetUserAccessTokens(ctx context.Context, userID int64) ([]domain.AccessToken, error)
+		TouchAccessToken(ctx context.Context, tokenID string, at time.Time) error
+		DeleteAccessToken(ctx context.Context, userID int64, tokenID string) error
+		DeleteUserAccessTokens(ctx context.Context, userID int64) error
+
+		CreateLocalUser(ctx context.Context, user *domain.User, cred *domain.LocalCredential) (int64, error)
+		GetLocalCredential(ctx context.Context, username string) (*domain.LocalCredential, error)
+		GetUserLocalCredential(ctx context.Context, userID int64) (*domain.LocalCredential, error)
+		SetLocalPassword(ctx context.Context, username string, passwordHash string, at time.Time) error
+
+		GetIdentity(ctx context.Context, provider string, subject string) (*domain.Identity, error)
+		GetUserIdentities(ctx context.Context, userID int64) ([]domain.Identity, error)
+		GetUserIDByEmail(ctx context.Context, email string, provider string) (int64, error)
+		CreateIdentity(ctx context.Context, identity *domain.Identity) error
+		DeleteIdentity(ctx context.Context, userID int64, provider string) error
+		MergeUsers(ctx context.Context, fromUserID int64, toUserID int64, at time.Time) ([]string, error)
+
+		CreateOAuthState(ctx context.Context, state *domain.OAuthState, now time.Time) error
+		ConsumeOAuthState(ctx context.Context, state string, now time.Time) (*domain.OAuthState, error)
+
+		AddRoomMember(ctx context.Context, roomID string, userID int64) error
+		GetRoom(ctx context.Context, roomID string) (*domain.Room, error)
+		IsRoomMember(ctx context.Context, roomID string, userID int64) (bool, error)
+		IsRoomAttendee(ctx context.Context, roomID string, userID int64, at time.Time) (bool, error)
+		SetRoomRetention(ctx context.Context, roomID string, retention time.Duration) error
+
+		GetRecording(ctx context.Context, recordingID string) (*domain.Recording, error)
+		GetUserRecordings(ctx context.Context, userID int64) ([]domain.Recording, error)
+		GetExpiredRecordings(ctx context.Context, now time.Time, defaultRetention time.Duration) ([]domain.Recording, error)
+		DeleteRecording(ctx context.Context, recordingID string) error
+
+		CreateUpload(ctx context.Context, upload *domain.Upload) error
+		GetUpload(ctx context.Context, uploadID string) (*domain.Upload, error)
+		GetUserUploads(ctx context.Context, userID int64) ([]domain.Upload, error)
+		GetStaleUploads(ctx context.Context, before time.Time) ([]domain.Upload, error)
+		SetUploadOffset(ctx context.Context, uploadID string, offset int64) error
+		CompleteUpload(ctx context.Context, uploadID string, rec *domain.Recording) error
+		DeleteUpload(ctx context.Context, uploadID string) error
+	}
+
+	storage interface {
+		Open(name string) (io.ReadSeekCloser, error)
+		Append(name string, offset int64, content io.Reader) (int64, error)
+		Remove(name string) error
 	}
 
 	userTokenProvider interface {
-		CreateToken(userID int64, email string) (*domain.Token, error)
+		CreateToken(userID int64, email string, sessionID string, tokenID string) (*domain.Token, error)
 		VerifyToken(token string) (*domain.UserTokenPayload, error)
+		VerifyRefreshToken(token string) (*domain.UserTokenPayload, error)
+		PublicKeys() []domain.JWK
 	}
 
 	roomTokenProvider interface {
-		CreateToken(userID int64, roomID string) (string, error)
+		CreateToken(userID int64, roomID string, sessionID string) (string, error)
 		VerifyToken(token string) (*domain.RoomTokenPayload, error)
 	}
 
 	hub interface {
-		Handle(user *domain.User, roomID string, conn *websocket.Conn)
+		Handle(user *domain.User, roomID string, client domain.Client, conn domain.Conn, iceServers []domain.ICEServer)
+		Admit(userID int64, roomID string) error
+		DisconnectSession(userID int64, sessionID string)
+		Stats() domain.RoomStats
+		Schema() map[string]any
+		Subprotocols() []string
+	}
+
+	iceProvider interface {
+		CreateICEServers(userID int64) ([]domain.ICEServer, error)
 	}
 
 	oauthProvider interface {
-		GetRedirectURL(provider string) (string, error)
-		HandleCallback(ctx context.Context, provider string, code string) (*domain.User, error)
+		Providers() []domain.OAuthProvider
+		GetRedirectURL(provider string) (string, *domain.OAuthState, error)
+		HandleCallback(ctx context.Context, provider string, code string, verifier string) (*domain.User, *domain.Identity, error)
 	}
 )
 
+type Config struct {
+	RefreshTokenTTL time.Duration
+	OAuthStateTTL   time.Duration // how long a login started with an oauth provider can be completed
+	OAuthAutoLink   bool          // log in new identities to the user with the same verified email
+
+	AccessTokenMaxTTL time.Duration // longest lifetime a personal access token can be created with
+
+	LocalMinPasswordLength int // shortest password of the local users, 0 means 12
+
+	RecordingRetention     time.Duration
+	RecordingPurgeInterval time.Duration
+	RecordingMaxUploadSize int64
+	RecordingUploadTTL     time.Duration
+}
+
 type Service struct {
+	cfg Config
+
 	db                database
+	storage           storage
 	hub               hub
 	oauthProvider     oauthProvider
 	userTokenProvider userTokenProvider
 	roomTokenProvider roomTokenProvider
+	iceProvider       iceProvider
+
+	uploadLocks [64]sync.Mutex
+
+	// local passwords, the attempts are limited and so are the concurrent argon2id hashes
+	// as each one takes argon2Memory
+	hashSlots        chan struct{}
+	ipAttempts       *attemptLimiter
+	usernameAttempts *attemptLimiter
 }
 
 func New(
+	cfg Config,
 	db database,
+	storage storage,
 	hub hub,
 	oauthProvider oauthProvider,
 	userTokenProvider userTokenProvider,
 	roomTokenProvider roomTokenProvider,
+	iceProvider iceProvider,
 ) *Service {
 	return &Service{
+		cfg:               cfg,
 		db:                db,
+		storage:           storage,
 		hub:               hub,
 		oauthProvider:     oauthProvider,
 		userTokenProvider: userTokenProvider,
 		roomTokenProvider: roomTokenProvider,
+		iceProvider:       iceProvider,
+
+		hashSlots:        make(chan struct{}, maxConcurrentHashes),
+		ipAttempts:       newAttemptLimiter(ipAttemptRate, ipAttemptBurst),
+		usernameAttempts: newAttemptLimiter(usernameAttemptRate, usernameAttemptBurst),
 	}
 }
 
-func (s *Service) GetOAuthRedirectURL(provider string) (string, error) {
-	return s.oauthProvider.GetRedirectURL(provider)
+// GetJWKS returns the public keys verifying the tokens signed by the server
+func (s *Service) GetJWKS() []domain.JWK {
+	return s.userTokenProvider.PublicKeys()
 }
 
-func (s *Service) RegisterUser(ctx context.Context, provider string, code string) (*domain.Token, error) {
-	user, err := s.oauthProvider.HandleCallback(ctx, provider, code)
+// GetOAuthProviders returns the providers the users can log in with
+func (s *Service) GetOAuthProviders() []domain.OAuthProvider {
+	return s.oauthProvider.Providers()
+}
+
+// GetOAuthRedirectURL starts a login with the provider, the returned state must be kept
+// by the browser the login was started from and given back with the code of the callback
+func (s *Service) GetOAuthRedirectURL(ctx context.Context, provider string) (string, string, error) {
+	return s.startOAuth(ctx, provider, 0)
+}
+
+// RegisterUser completes the login of the callback, state is the one the provider redirected
+// back with and browserState the one kept by the browser, both must match the login started.
+// No tokens are returned when the login was started to link the provider to a user
+func (s *Service) RegisterUser(ctx context.Context, provider string, code string, state string, browserState string, device domain.Device) (*domain.Token, error) {
+	login, err := s.consumeOAuthState(ctx, provider, state, browserState)
 	if err != nil {
 		return nil, err
 	}
 
-	userID, err := s.db.CreateUser(ctx, user, provider)
+	user, identity, err := s.oauthProvider.HandleCallback(ctx, provider, code, login.Verifier)
 	if err != nil {
 		return nil, err
 	}
 
-	token, err := s.userTokenProvider.CreateToken(userID, user.Email)
+	if login.UserID != 0 {
+		return nil, s.linkIdentity(ctx, login.UserID, identity)
+	}
+
+	userID, err := s.loginIdentity(ctx, user, identity)
 	if err != nil {
 		return nil, err
 	}
 
-	return token, nil
+	return s.createSession(ctx, userID, user.Email, device)
 }
 
-func (s *Service) AuthenticateUser(ctx context.Context, token *domain.Token) (*domain.User, *domain.Token, error) {
-	userID, token, err := s.verifyUserToken(token)
+// startOAuth stores the state of a login with the provider, userID is set when linking
+func (s *Service) startOAuth(ctx context.Context, provider string, userID int64) (string, string, error) {
+	url, state, err := s.oauthProvider.GetRedirectURL(provider)
 	if err != nil {
-		return nil, nil, err
+		return "", "", err
 	}
 
-	user, err := s.db.GetUser(ctx, userID)
-	if err != nil {
-		return nil, nil, err
+	now := time.Now()
+	state.UserID = userID
+	state.ExpiresAt = now.Add(s.cfg.OAuthStateTTL)
+	if err := s.db.CreateOAuthState(ctx, state, now); err != nil {
+		return "", "", err
 	}
 
-	return user, token, nil
+	return url, state.State, nil
 }
 
-func (s *Service) verifyUserToken(token *domain.Token) (int64, *domain.Token, error) {
-	payload, err := s.userTokenProvider.VerifyToken(token.Access)
-	if err != nil && !errors.Is(err, domain.ErrTokenExpired) {
-		return 0, nil, err
+// consumeOAuthState returns the login the state was created for
+func (s *Service) consumeOAuthState(ctx context.Context, provider string, state string, browserState string) (*domain.OAuthState, error) {
+	// a state missing from the browser means the callback was forged from another one
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(browserState)) != 1 {
+		return nil, domain.ErrOAuthInvalidState
 	}
 
-	// refresh token if access token is expired
-	if errors.Is(err, domain.ErrTokenExpired) {
-		payload, err = s.userTokenProvider.VerifyToken(token.Refresh)
-		if err != nil {
-			return 0, nil, err
+	login, err := s.db.ConsumeOAuthState(ctx, state, time.Now())
+	if err != nil {
+		if errors.Is(err, domain.ErrDBOAuthStateNotFound) {
+			return nil, domain.ErrOAuthInvalidState
 		}
+		return nil, err
+	}
 
-		token, err = s.userTokenProvider.CreateToken(payload.UserID, payload.Email)
-		if err != nil {
-			return 0, nil, err
-		}
+	if login.Provider != provider {
+		return nil, domain.ErrOAuthInvalidState
+	}
+
+	return login, nil
+}
 
-		return payload.UserID, token, nil
+// AuthenticateUser returns the user and the session of the tokens used from the device,
+// the tokens are returned if they were refreshed
+func (s *Service) AuthenticateUser(ctx context.Context, token *domain.Token, device domain.Device) (*domain.User, string, *domain.Token, error) {
+	payload, token, err := s.verifyUserToken(ctx, token, device)
+	if err != nil {
+		return nil, "", nil, err
 	}
 
-	return payload.UserID, nil, nil
+	user, err := s.db.GetUser(ctx, payload.UserID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return user, payload.SessionID, token, nil
 }
 
-func (s *Service) CreateRoomToken(userID int64, roomID string) (string, error) {
-	return s.roomTokenProvider.CreateToken(userID, roomID)
+// CreateRoomToken creates the token of a room connection opened from the session of the user
+// CreateRoom creates a room owned by the user, the participants join it by its id
+func (s *Service) CreateRoom(ctx context.Context, userID int64) (string, error) {
+	roomID := uuid.NewString()
+	if err := s.db.AddRoomMember(ctx, roomID, userID); err != nil {
+		return "", err
+	}
+
+	return roomID, nil
+}
+
+func (s *Service) CreateRoomToken(ctx context.Context, userID int64, roomID string, sessionID string) (string, error) {
+	if err := s.db.AddRoomMember(ctx, roomID, userID); err != nil {
+		return "", err
+	}
+
+	return s.roomTokenProvider.CreateToken(userID, roomID, sessionID)
+}
+
+// CreateICEServers returns the ICE servers the user connects to the room peers through
+func (s *Service) CreateICEServers(userID int64) ([]domain.ICEServer, error) {
+	return s.iceProvider.CreateICEServers(userID)
+}
+
+// AuthenticateWS returns the user of the room token and the session the token was created from,
+// it refuses the connection when the room cannot admit it
+func (s *Service) AuthenticateWS(ctx context.Context, token string, roomID string) (*domain.User, string, error) {
+	user, sessionID, err := s.AuthenticateRoom(ctx, token, roomID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// refuse the connection before the upgrade when there is no room for it
+	if err := s.hub.Admit(user.UserID, roomID); err != nil {
+		return nil, "", err
+	}
+
+	return user, sessionID, nil
 }
 
-func (s *Service) AuthenticateWS(ctx context.Context, token string, roomID string) (*domain.User, error) {
+// AuthenticateRoom returns the user of the room token and the session the token was created from
+// without admitting a connection, for the requests made on behalf of an already open one
+func (s *Service) AuthenticateRoom(ctx context.Context, token string, roomID string) (*domain.User, string, error) {
 	payload, err := s.roomTokenProvider.VerifyToken(token)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	if payload.RoomID != roomID {
-		return nil, domain.ErrRoomIDTokenMismatch
+		return nil, "", domain.ErrRoomIDTokenMismatch
+	}
+
+	// the token may outlive its session by its ttl
+	if _, err := s.getActiveSession(ctx, payload.UserID, payload.SessionID); err != nil {
+		return nil, "", err
 	}
 
 	user, err := s.db.GetUser(ctx, payload.UserID)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	return user, nil
+	return user, payload.SessionID, nil
+}
+
+func (s *Service) HandleConn(user *domain.User, roomID string, client domain.Client, conn domain.Conn) {
+	iceServers, err := s.iceProvider.CreateICEServers(user.UserID)
+	if err != nil {
+		// the user can still join, clients fall back to their default ice servers
+		log.Printf("service.HandleConn: create ice servers for user %d: %v", user.UserID, err)
+	}
+
+	s.hub.Handle(user, roomID, client, conn, iceServers)
+}
+
+// GetRoomStats returns the current counts of the rooms handled by the server
+func (s *Service) GetRoomStats() domain.RoomStats {
+	return s.hub.Stats()
+}
+
+// GetSubprotocols returns the websocket subprotocols of the encodings supported by the rooms
+func (s *Service) GetSubprotocols() []string {
+	return s.hub.Subprotocols()
 }
 
-func (s *Service) HandleWS(user *domain.User, roomID string, conn *websocket.Conn) {
-	s.hub.Handle(user, roomID, conn)
+// GetEventSchema returns the JSON Schema of the room events
+func (s *Service) GetEventSchema() map[string]any {
+	return s.hub.Schema()
 }
@@ -1,13 +1,16 @@
 package service
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/escalopa/vego/internal/domain"
 	"github.com/escalopa/vego/internal/service/mock"
 	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/require"
 )
@@ -33,12 +36,24 @@ func TestService_GetOAuthRedirectURL(t *testing.T) {
 			defer ctrl.Finish()
 
 			op := mock.NewMockoauthProvider(ctrl)
-			svc := New(nil, nil, op, nil, nil)
+			db := mock.NewMockdatabase(ctrl)
+			svc := New(Config{OAuthStateTTL: time.Minute}, db, nil, nil, op, nil, nil, nil)
 
-			op.EXPECT().GetRedirectURL(tt.provider).Return(tt.wantURL, tt.wantErr)
-			url, err := svc.GetOAuthRedirectURL(tt.provider)
+			var state *domain.OAuthState
+			if tt.wantErr == nil {
+				state = &domain.OAuthState{State: "state1", Provider: tt.provider, Verifier: "verifier1"}
+				db.EXPECT().CreateOAuthState(gomock.Any(), state, gomock.Any()).DoAndReturn(func(_ context.Context, state *domain.OAuthState, now time.Time) error {
+					require.Equal(t, now.Add(time.Minute), state.ExpiresAt)
+					return nil
+				})
+			}
+			op.EXPECT().GetRedirectURL(tt.provider).Return(tt.wantURL, state, tt.wantErr)
+			url, browserState, err := svc.GetOAuthRedirectURL(context.Background(), tt.provider)
 			require.Equal(t, tt.wantURL, url)
 			require.Equal(t, tt.wantErr, err)
+			if tt.wantErr == nil {
+				require.Equal(t, "state1", browserState)
+			}
 		})
 	}
 }
@@ -46,14 +61,25 @@ func TestService_GetOAuthRedirectURL(t *testing.T) {
 func TestService_RegisterUser(t *testing.T) {
 	t.Parallel()
 
+	login := &domain.OAuthState{State: "state1", Provider: "google", Verifier: "verifier1"}
+
 	tests := []struct {
-		name     string
-		provider string
-		code     string
-		wantErr  error
+		name         string
+		provider     string
+		code         string
+		state        string
+		browserState string
+		consumeErr   error
+		callbackErr  error
+		wantErr      error
 	}{
-		{"valid_registration", "google", "valid_code", nil},
-		{"invalid_registration", "google", "invalid_code", errors.New("invalid code")},
+		{"valid_registration", "google", "valid_code", "state1", "state1", nil, nil, nil},
+		{"invalid_registration", "google", "invalid_code", "state1", "state1", nil, errors.New("invalid code"), errors.New("invalid code")},
+		{"missing_state", "google", "valid_code", "", "", nil, nil, domain.ErrOAuthInvalidState},
+		{"missing_browser_state", "google", "valid_code", "state1", "", nil, nil, domain.ErrOAuthInvalidState},
+		{"forged_state", "google", "valid_code", "state1", "state2", nil, nil, domain.ErrOAuthInvalidState},
+		{"unknown_state", "google", "valid_code", "state1", "state1", domain.ErrDBOAuthStateNotFound, nil, domain.ErrOAuthInvalidState},
+		{"provider_mismatch", "github", "valid_code", "state1", "state1", nil, nil, domain.ErrOAuthInvalidState},
 	}
 
 	for _, tt := range tests {
@@ -66,15 +92,36 @@ func TestService_RegisterUser(t *testing.T) {
 			op := mock.NewMockoauthProvider(ctrl)
 			db := mock.NewMockdatabase(ctrl)
 			up := mock.NewMockuserTokenProvider(ctrl)
-			svc := New(db, nil, op, up, nil)
+			svc := New(Config{}, db, nil, nil, op, up, nil, nil)
+
+			stateMatch := tt.state != "" && tt.state == tt.browserState
+			if stateMatch {
+				if tt.consumeErr != nil {
+					db.EXPECT().ConsumeOAuthState(gomock.Any(), tt.state, gomock.Any()).Return(nil, tt.consumeErr)
+				} else {
+					db.EXPECT().ConsumeOAuthState(gomock.Any(), tt.state, gomock.Any()).Return(login, nil)
+				}
+			}
 
 			user := &domain.User{Email: "test@example.com"}
-			op.EXPECT().HandleCallback(gomock.Any(), tt.provider, tt.code).Return(user, tt.wantErr)
+			identity := &domain.Identity{Provider: tt.provider, Subject: "subject1", Email: user.Email}
+			if stateMatch && tt.consumeErr == nil && tt.provider == login.Provider {
+				op.EXPECT().HandleCallback(gomock.Any(), tt.provider, tt.code, login.Verifier).Return(user, identity, tt.callbackErr)
+			}
 			if tt.wantErr == nil {
+				db.EXPECT().GetIdentity(gomock.Any(), tt.provider, "subject1").Return(nil, domain.ErrDBIdentityNotFound)
+				db.EXPECT().GetUserIDByEmail(gomock.Any(), user.Email, tt.provider).Return(int64(0), domain.ErrDBUserNotFound)
 				db.EXPECT().CreateUser(gomock.Any(), user, tt.provider).Return(int64(1), nil)
-				up.EXPECT().CreateToken(int64(1), user.Email).Return(&domain.Token{}, nil)
+				db.EXPECT().CreateIdentity(gomock.Any(), identity).Return(nil)
+				up.EXPECT().CreateToken(int64(1), user.Email, gomock.Any(), gomock.Any()).Return(&domain.Token{Refresh: "refresh"}, nil)
+				db.EXPECT().CreateSession(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, session *domain.Session) error {
+					require.Equal(t, int64(1), session.UserID)
+					require.Equal(t, hashToken("refresh"), session.TokenHash)
+					require.Equal(t, domain.Device{UserAgent: "Firefox", IP: "10.0.0.1"}, session.Device)
+					return nil
+				})
 			}
-			_, err := svc.RegisterUser(context.Background(), tt.provider, tt.code)
+			_, err := svc.RegisterUser(context.Background(), tt.provider, tt.code, tt.state, tt.browserState, domain.Device{UserAgent: "Firefox", IP: "10.0.0.1"})
 			require.Equal(t, tt.wantErr, err)
 		})
 	}
@@ -101,20 +148,47 @@ func TestService_AuthenticateUser(t *testing.T) {
 
 			db := mock.NewMockdatabase(ctrl)
 			utp := mock.NewMockuserTokenProvider(ctrl)
-			svc := New(db, nil, nil, utp, nil)
+			svc := New(Config{}, db, nil, nil, nil, utp, nil, nil)
 
-			payload := &domain.UserTokenPayload{UserID: 1}
+			payload := &domain.UserTokenPayload{UserID: 1, SessionID: "session1"}
 			user := &domain.User{}
 			utp.EXPECT().VerifyToken(tt.token.Access).Return(payload, tt.wantErr)
 			if tt.wantErr == nil {
+				session := &domain.Session{SessionID: "session1", UserID: 1, ExpiresAt: time.Now().Add(time.Hour), LastUsedAt: time.Now()}
+				db.EXPECT().GetSession(gomock.Any(), payload.SessionID).Return(session, nil)
 				db.EXPECT().GetUser(gomock.Any(), payload.UserID).Return(user, nil)
 			}
-			_, _, err := svc.AuthenticateUser(context.Background(), tt.token)
+			_, sessionID, _, err := svc.AuthenticateUser(context.Background(), tt.token, domain.Device{})
 			require.Equal(t, tt.wantErr, err)
+			if tt.wantErr == nil {
+				require.Equal(t, "session1", sessionID)
+			}
 		})
 	}
 }
 
+func TestService_CreateRoom(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := mock.NewMockdatabase(ctrl)
+	svc := New(Config{}, db, nil, nil, nil, nil, nil, nil)
+
+	// the creator joins first so it owns the room
+	var joinedID string
+	db.EXPECT().AddRoomMember(gomock.Any(), gomock.Any(), int64(1)).DoAndReturn(func(_ context.Context, roomID string, _ int64) error {
+		joinedID = roomID
+		return nil
+	})
+
+	roomID, err := svc.CreateRoom(context.Background(), 1)
+	require.NoError(t, err)
+	require.Equal(t, joinedID, roomID)
+	require.NoError(t, uuid.Validate(roomID))
+}
+
 func TestService_CreateRoomToken(t *testing.T) {
 	t.Parallel()
 
@@ -135,11 +209,13 @@ func TestService_CreateRoomToken(t *testing.T) {
 			ctrl := gomock.NewController(t)
 			defer ctrl.Finish()
 
+			db := mock.NewMockdatabase(ctrl)
 			rtp := mock.NewMockroomTokenProvider(ctrl)
-			svc := New(nil, nil, nil, nil, rtp)
+			svc := New(Config{}, db, nil, nil, nil, nil, rtp, nil)
 
-			rtp.EXPECT().CreateToken(tt.userID, tt.roomID).Return("token", tt.wantErr)
-			_, err := svc.CreateRoomToken(tt.userID, tt.roomID)
+			db.EXPECT().AddRoomMember(gomock.Any(), tt.roomID, tt.userID).Return(nil)
+			rtp.EXPECT().CreateToken(tt.userID, tt.roomID, "session1").Return("token", tt.wantErr)
+			_, err := svc.CreateRoomToken(context.Background(), tt.userID, tt.roomID, "session1")
 			require.Equal(t, tt.wantErr, err)
 		})
 	}
@@ -149,13 +225,17 @@ func TestService_AuthenticateWS(t *testing.T) {
 	t.Parallel()
 
 	tests := []struct {
-		name    string
-		token   string
-		roomID  string
-		wantErr error
+		name       string
+		token      string
+		roomID     string
+		tokenErr   error
+		sessionErr error
+		admitErr   error
 	}{
-		{"valid_token", "valid_token", "room1", nil},
-		{"invalid_token", "invalid_token", "room1", errors.New("invalid token")},
+		{"valid_token", "valid_token", "room1", nil, nil, nil},
+		{"invalid_token", "invalid_token", "room1", errors.New("invalid token"), nil, nil},
+		{"revoked_session", "valid_token", "room1", nil, domain.ErrSessionRevoked, nil},
+		{"room_full", "valid_token", "room1", nil, nil, domain.ErrRoomFull},
 	}
 
 	for _, tt := range tests {
@@ -166,34 +246,73 @@ func TestService_AuthenticateWS(t *testing.T) {
 			defer ctrl.Finish()
 
 			db := mock.NewMockdatabase(ctrl)
+			h := mock.NewMockhub(ctrl)
 			rtp := mock.NewMockroomTokenProvider(ctrl)
-			svc := New(db, nil, nil, nil, rtp)
+			svc := New(Config{}, db, nil, h, nil, nil, rtp, nil)
 
-			payload := &domain.RoomTokenPayload{UserID: 1, RoomID: tt.roomID}
-			user := &domain.User{}
-			rtp.EXPECT().VerifyToken(tt.token).Return(payload, tt.wantErr)
-			if tt.wantErr == nil {
+			payload := &domain.RoomTokenPayload{UserID: 1, RoomID: tt.roomID, SessionID: "session1"}
+			session := &domain.Session{SessionID: "session1", UserID: 1, ExpiresAt: time.Now().Add(time.Hour)}
+			if tt.sessionErr != nil {
+				session.RevokedAt = time.Now()
+			}
+			user := &domain.User{UserID: 1}
+			rtp.EXPECT().VerifyToken(tt.token).Return(payload, tt.tokenErr)
+			if tt.tokenErr == nil {
+				db.EXPECT().GetSession(gomock.Any(), "session1").Return(session, nil)
+			}
+			if tt.tokenErr == nil && tt.sessionErr == nil {
 				db.EXPECT().GetUser(gomock.Any(), payload.UserID).Return(user, nil)
+				h.EXPECT().Admit(payload.UserID, tt.roomID).Return(tt.admitErr)
+			}
+			_, sessionID, err := svc.AuthenticateWS(context.Background(), tt.token, tt.roomID)
+			require.Equal(t, cmp.Or(tt.tokenErr, tt.sessionErr, tt.admitErr), err)
+			if err == nil {
+				require.Equal(t, "session1", sessionID)
 			}
-			_, err := svc.AuthenticateWS(context.Background(), tt.token, tt.roomID)
-			require.Equal(t, tt.wantErr, err)
 		})
 	}
 }
 
-func TestService_HandleWS(t *testing.T) {
+func TestService_AuthenticateRoom(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := mock.NewMockdatabase(ctrl)
+	h := mock.NewMockhub(ctrl) // no admission expected
+	rtp := mock.NewMockroomTokenProvider(ctrl)
+	svc := New(Config{}, db, nil, h, nil, nil, rtp, nil)
+
+	payload := &domain.RoomTokenPayload{UserID: 1, RoomID: "room1", SessionID: "session1"}
+	session := &domain.Session{SessionID: "session1", UserID: 1, ExpiresAt: time.Now().Add(time.Hour)}
+	rtp.EXPECT().VerifyToken("valid_token").Return(payload, nil)
+	db.EXPECT().GetSession(gomock.Any(), "session1").Return(session, nil)
+	db.EXPECT().GetUser(gomock.Any(), int64(1)).Return(&domain.User{UserID: 1}, nil)
+
+	user, sessionID, err := svc.AuthenticateRoom(context.Background(), "valid_token", "room1")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), user.UserID)
+	require.Equal(t, "session1", sessionID)
+}
+
+func TestService_HandleConn(t *testing.T) {
 	t.Parallel()
 
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
 	h := mock.NewMockhub(ctrl)
-	svc := New(nil, h, nil, nil, nil)
+	ip := mock.NewMockiceProvider(ctrl)
+	svc := New(Config{}, nil, nil, h, nil, nil, nil, ip)
 
-	user := &domain.User{}
+	user := &domain.User{UserID: 1}
 	conn := &websocket.Conn{}
 	roomID := "room1"
+	client := domain.Client{Version: 2, Capabilities: []string{"resume"}}
+	iceServers := []domain.ICEServer{{URLs: []string{"stun:stun.example.com:3478"}}}
 
-	h.EXPECT().Handle(user, roomID, conn).Times(1)
-	svc.HandleWS(user, roomID, conn)
+	ip.EXPECT().CreateICEServers(user.UserID).Return(iceServers, nil)
+	h.EXPECT().Handle(user, roomID, client, conn, iceServers).Times(1)
+	svc.HandleConn(user, roomID, client, conn)
 }
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/google/uuid"
+)
+
+const (
+	// sessionRotationGracePeriod is how long the refresh token replaced by a rotation is still
+	// accepted, without being rotated again, for the concurrent requests of the same client
+	sessionRotationGracePeriod = 10 * time.Second
+
+	// sessionTouchInterval limits how often the last use of a session is recorded
+	sessionTouchInterval = time.Minute
+)
+
+// Logout revokes the session of the user so its refresh token cannot be used anymore
+func (s *Service) Logout(ctx context.Context, userID int64, sessionID string) error {
+	err := s.RevokeSession(ctx, userID, sessionID)
+	if err != nil && !errors.Is(err, domain.ErrDBSessionNotFound) {
+		return err
+	}
+	return nil
+}
+
+// ListSessions returns the active sessions of the user
+func (s *Service) ListSessions(ctx context.Context, userID int64) ([]domain.Session, error) {
+	return s.db.GetUserSessions(ctx, userID, time.Now())
+}
+
+// RevokeSession revokes the session of the user and closes the room connections opened from it
+func (s *Service) RevokeSession(ctx context.Context, userID int64, sessionID string) error {
+	if err := s.db.RevokeSession(ctx, userID, sessionID, time.Now()); err != nil {
+		return err
+	}
+
+	s.hub.DisconnectSession(userID, sessionID)
+	return nil
+}
+
+// RevokeOtherSessions revokes the sessions of the user but the current one, it logs
+// the user out of every other device
+func (s *Service) RevokeOtherSessions(ctx context.Context, userID int64, currentSessionID string) error {
+	sessionIDs, err := s.db.RevokeUserSessions(ctx, userID, currentSessionID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	for _, sessionID := range sessionIDs {
+		s.hub.DisconnectSession(userID, sessionID)
+	}
+	return nil
+}
+
+// createSession starts a session of the user on the device and creates its first tokens
+func (s *Service) createSession(ctx context.Context, userID int64, email string, device domain.Device) (*domain.Token, error) {
+	sessionID, tokenID := uuid.NewString(), uuid.NewString()
+	token, err := s.userTokenProvider.CreateToken(userID, email, sessionID, tokenID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &domain.Session{
+		SessionID:  sessionID,
+		UserID:     userID,
+		TokenID:    tokenID,
+		TokenHash:  hashToken(token.Refresh),
+		CreatedAt:  now,
+		RotatedAt:  now,
+		ExpiresAt:  now.Add(s.cfg.RefreshTokenTTL),
+		LastUsedAt: now,
+		Device:     device,
+	}
+	if err := s.db.CreateSession(ctx, session); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// verifyUserToken returns the payload of the access token, the refresh token is rotated
+// if the access token is expired and the new tokens are returned
+func (s *Service) verifyUserToken(ctx context.Context, token *domain.Token, device domain.Device) (*domain.UserTokenPayload, *domain.Token, error) {
+	payload, err := s.userTokenProvider.VerifyToken(token.Access)
+	if err != nil && !errors.Is(err, domain.ErrTokenExpired) {
+		return nil, nil, err
+	}
+
+	// refresh token if access token is expired
+	if errors.Is(err, domain.ErrTokenExpired) {
+		payload, err = s.userTokenProvider.VerifyRefreshToken(token.Refresh)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		token, err = s.rotateSession(ctx, payload, token.Refresh, device)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return payload, token, nil
+	}
+
+	// the access token is valid as long as its session is not revoked
+	session, err := s.getActiveSession(ctx, payload.UserID, payload.SessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if now := time.Now(); now.Sub(session.LastUsedAt) >= sessionTouchInterval || session.IP != device.IP {
+		if err := s.db.TouchSession(ctx, session.SessionID, device.IP, now); err != nil {
+			log.Printf("service.verifyUserToken: touch session %s: %v", session.SessionID, err)
+		}
+	}
+
+	return payload, nil, nil
+}
+
+// rotateSession replaces the refresh token of the session, a refresh token replaced earlier
+// is being replayed by someone who stole it so the whole session is revoked
+func (s *Service) rotateSession(ctx context.Context, payload *domain.UserTokenPayload, refreshToken string, device domain.Device) (*domain.Token, error) {
+	session, err := s.getActiveSession(ctx, payload.UserID, payload.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if payload.TokenID != session.TokenID || hashToken(refreshToken) != session.TokenHash {
+		// concurrent requests of the client refresh with the same token
+		if payload.TokenID == session.PrevTokenID && now.Sub(session.RotatedAt) < sessionRotationGracePeriod {
+			return nil, nil
+		}
+
+		log.Printf("service.rotateSession: refresh token reused, revoking session %s of user %d", session.SessionID, session.UserID)
+		err := s.RevokeSession(ctx, session.UserID, session.SessionID)
+		if err != nil && !errors.Is(err, domain.ErrDBSessionNotFound) {
+			return nil, err
+		}
+		return nil, domain.ErrTokenReused
+	}
+
+	tokenID := uuid.NewString()
+	token, err := s.userTokenProvider.CreateToken(payload.UserID, payload.Email, session.SessionID, tokenID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.RotateSession(ctx, &domain.Session{
+		SessionID:   session.SessionID,
+		TokenID:     tokenID,
+		TokenHash:   hashToken(token.Refresh),
+		PrevTokenID: session.TokenID,
+		RotatedAt:   now,
+		ExpiresAt:   now.Add(s.cfg.RefreshTokenTTL),
+		Device:      device, // only the ip of the last use is updated
+	})
+	if errors.Is(err, domain.ErrDBSessionNotFound) {
+		// rotated or revoked meanwhile, check the token against the updated session
+		return s.rotateSession(ctx, payload, refreshToken, device)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (s *Service) getActiveSession(ctx context.Context, userID int64, sessionID string) (*domain.Session, error) {
+	session, err := s.db.GetSession(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, domain.ErrDBSessionNotFound) {
+			return nil, domain.ErrSessionRevoked
+		}
+		return nil, err
+	}
+
+	switch {
+	case session.UserID != userID || !session.RevokedAt.IsZero():
+		return nil, domain.ErrSessionRevoked
+	case !time.Now().Before(session.ExpiresAt):
+		return nil, domain.ErrTokenExpired
+	}
+
+	return session, nil
+}
+
+// hashToken returns the hash of the refresh or access token stored in the database
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
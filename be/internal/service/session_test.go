@@ -0,0 +1,252 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/escalopa/vego/internal/service/mock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_VerifyUserToken(t *testing.T) {
+	t.Parallel()
+
+	var (
+		now     = time.Now()
+		token   = &domain.Token{Access: "access1", Refresh: "refresh1"}
+		access  = &domain.UserTokenPayload{UserID: 1, SessionID: "session1"}
+		refresh = &domain.UserTokenPayload{UserID: 1, SessionID: "session1", TokenID: "token1"}
+		rotated = &domain.Token{Access: "access2", Refresh: "refresh2"}
+		device  = domain.Device{UserAgent: "Firefox", IP: "10.0.0.1"}
+	)
+
+	activeSession := func() *domain.Session {
+		return &domain.Session{
+			SessionID:  "session1",
+			UserID:     1,
+			TokenID:    "token1",
+			TokenHash:  hashToken("refresh1"),
+			RotatedAt:  now.Add(-time.Hour),
+			ExpiresAt:  now.Add(time.Hour),
+			LastUsedAt: now,
+			Device:     device,
+		}
+	}
+
+	// rotatedSession is the session once refresh1 was replaced at rotatedAt
+	rotatedSession := func(rotatedAt time.Time) *domain.Session {
+		session := activeSession()
+		session.TokenID, session.TokenHash, session.PrevTokenID = "token2", hashToken("refresh2"), "token1"
+		session.RotatedAt = rotatedAt
+		return session
+	}
+
+	expireAccess := func(utp *mock.MockuserTokenProvider) {
+		utp.EXPECT().VerifyToken(token.Access).Return(nil, domain.ErrTokenExpired)
+		utp.EXPECT().VerifyRefreshToken(token.Refresh).Return(refresh, nil)
+	}
+
+	tests := []struct {
+		name      string
+		setup     func(db *mock.Mockdatabase, utp *mock.MockuserTokenProvider, h *mock.Mockhub)
+		wantToken *domain.Token
+		wantErr   error
+	}{
+		{
+			name: "valid_access_token",
+			setup: func(db *mock.Mockdatabase, utp *mock.MockuserTokenProvider, _ *mock.Mockhub) {
+				utp.EXPECT().VerifyToken(token.Access).Return(access, nil)
+				db.EXPECT().GetSession(gomock.Any(), "session1").Return(activeSession(), nil)
+			},
+		},
+		{
+			name: "touch_session",
+			setup: func(db *mock.Mockdatabase, utp *mock.MockuserTokenProvider, _ *mock.Mockhub) {
+				session := activeSession()
+				session.LastUsedAt = now.Add(-sessionTouchInterval)
+				utp.EXPECT().VerifyToken(token.Access).Return(access, nil)
+				db.EXPECT().GetSession(gomock.Any(), "session1").Return(session, nil)
+				db.EXPECT().TouchSession(gomock.Any(), "session1", device.IP, gomock.Any()).Return(nil)
+			},
+		},
+		{
+			name: "touch_session_from_another_ip",
+			setup: func(db *mock.Mockdatabase, utp *mock.MockuserTokenProvider, _ *mock.Mockhub) {
+				session := activeSession()
+				session.IP = "10.0.0.2"
+				utp.EXPECT().VerifyToken(token.Access).Return(access, nil)
+				db.EXPECT().GetSession(gomock.Any(), "session1").Return(session, nil)
+				db.EXPECT().TouchSession(gomock.Any(), "session1", device.IP, gomock.Any()).Return(nil)
+			},
+		},
+		{
+			name: "revoked_session",
+			setup: func(db *mock.Mockdatabase, utp *mock.MockuserTokenProvider, _ *mock.Mockhub) {
+				session := activeSession()
+				session.RevokedAt = now
+				utp.EXPECT().VerifyToken(token.Access).Return(access, nil)
+				db.EXPECT().GetSession(gomock.Any(), "session1").Return(session, nil)
+			},
+			wantErr: domain.ErrSessionRevoked,
+		},
+		{
+			name: "unknown_session",
+			setup: func(db *mock.Mockdatabase, utp *mock.MockuserTokenProvider, _ *mock.Mockhub) {
+				utp.EXPECT().VerifyToken(token.Access).Return(access, nil)
+				db.EXPECT().GetSession(gomock.Any(), "session1").Return(nil, domain.ErrDBSessionNotFound)
+			},
+			wantErr: domain.ErrSessionRevoked,
+		},
+		{
+			name: "expired_session",
+			setup: func(db *mock.Mockdatabase, utp *mock.MockuserTokenProvider, _ *mock.Mockhub) {
+				session := activeSession()
+				session.ExpiresAt = now.Add(-time.Minute)
+				utp.EXPECT().VerifyToken(token.Access).Return(access, nil)
+				db.EXPECT().GetSession(gomock.Any(), "session1").Return(session, nil)
+			},
+			wantErr: domain.ErrTokenExpired,
+		},
+		{
+			name: "invalid_refresh_token",
+			setup: func(_ *mock.Mockdatabase, utp *mock.MockuserTokenProvider, _ *mock.Mockhub) {
+				utp.EXPECT().VerifyToken(token.Access).Return(nil, domain.ErrTokenExpired)
+				utp.EXPECT().VerifyRefreshToken(token.Refresh).Return(nil, domain.ErrTokenInvalid)
+			},
+			wantErr: domain.ErrTokenInvalid,
+		},
+		{
+			name: "rotate_refresh_token",
+			setup: func(db *mock.Mockdatabase, utp *mock.MockuserTokenProvider, _ *mock.Mockhub) {
+				expireAccess(utp)
+				db.EXPECT().GetSession(gomock.Any(), "session1").Return(activeSession(), nil)
+				utp.EXPECT().CreateToken(int64(1), "", "session1", gomock.Any()).Return(rotated, nil)
+				db.EXPECT().RotateSession(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, session *domain.Session) error {
+					require.Equal(t, "session1", session.SessionID)
+					require.Equal(t, device.IP, session.IP)
+					require.Equal(t, "token1", session.PrevTokenID)
+					require.NotEqual(t, "token1", session.TokenID)
+					require.Equal(t, hashToken("refresh2"), session.TokenHash)
+					return nil
+				})
+			},
+			wantToken: rotated,
+		},
+		{
+			name: "reused_refresh_token",
+			setup: func(db *mock.Mockdatabase, utp *mock.MockuserTokenProvider, h *mock.Mockhub) {
+				expireAccess(utp)
+				db.EXPECT().GetSession(gomock.Any(), "session1").Return(rotatedSession(now.Add(-time.Minute)), nil)
+				db.EXPECT().RevokeSession(gomock.Any(), int64(1), "session1", gomock.Any()).Return(nil)
+				h.EXPECT().DisconnectSession(int64(1), "session1")
+			},
+			wantErr: domain.ErrTokenReused,
+		},
+		{
+			name: "forged_refresh_token",
+			setup: func(db *mock.Mockdatabase, utp *mock.MockuserTokenProvider, h *mock.Mockhub) {
+				session := activeSession()
+				session.TokenHash = hashToken("other")
+				expireAccess(utp)
+				db.EXPECT().GetSession(gomock.Any(), "session1").Return(session, nil)
+				db.EXPECT().RevokeSession(gomock.Any(), int64(1), "session1", gomock.Any()).Return(nil)
+				h.EXPECT().DisconnectSession(int64(1), "session1")
+			},
+			wantErr: domain.ErrTokenReused,
+		},
+		{
+			name: "refresh_token_rotated_within_grace_period",
+			setup: func(db *mock.Mockdatabase, utp *mock.MockuserTokenProvider, _ *mock.Mockhub) {
+				expireAccess(utp)
+				db.EXPECT().GetSession(gomock.Any(), "session1").Return(rotatedSession(now), nil)
+			},
+		},
+		{
+			name: "refresh_token_rotated_concurrently",
+			setup: func(db *mock.Mockdatabase, utp *mock.MockuserTokenProvider, _ *mock.Mockhub) {
+				expireAccess(utp)
+				gomock.InOrder(
+					db.EXPECT().GetSession(gomock.Any(), "session1").Return(activeSession(), nil),
+					db.EXPECT().GetSession(gomock.Any(), "session1").Return(rotatedSession(now), nil),
+				)
+				utp.EXPECT().CreateToken(int64(1), "", "session1", gomock.Any()).Return(rotated, nil)
+				db.EXPECT().RotateSession(gomock.Any(), gomock.Any()).Return(domain.ErrDBSessionNotFound)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			db := mock.NewMockdatabase(ctrl)
+			utp := mock.NewMockuserTokenProvider(ctrl)
+			h := mock.NewMockhub(ctrl)
+			svc := New(Config{RefreshTokenTTL: time.Hour}, db, nil, h, nil, utp, nil, nil)
+
+			tt.setup(db, utp, h)
+			payload, got, err := svc.verifyUserToken(context.Background(), token, device)
+			require.Equal(t, tt.wantErr, err)
+			require.Equal(t, tt.wantToken, got)
+			if tt.wantErr == nil {
+				require.Equal(t, int64(1), payload.UserID)
+			}
+		})
+	}
+}
+
+func TestService_Logout(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		revokeErr error
+		wantErr   error
+	}{
+		{"active_session", nil, nil},
+		{"revoked_session", domain.ErrDBSessionNotFound, nil},
+		{"db_error", domain.ErrDBQuery, domain.ErrDBQuery},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			db := mock.NewMockdatabase(ctrl)
+			h := mock.NewMockhub(ctrl)
+			svc := New(Config{}, db, nil, h, nil, nil, nil, nil)
+
+			db.EXPECT().RevokeSession(gomock.Any(), int64(1), "session1", gomock.Any()).Return(tt.revokeErr)
+			if tt.revokeErr == nil {
+				h.EXPECT().DisconnectSession(int64(1), "session1")
+			}
+			err := svc.Logout(context.Background(), 1, "session1")
+			require.Equal(t, tt.wantErr, err)
+		})
+	}
+}
+
+func TestService_RevokeOtherSessions(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := mock.NewMockdatabase(ctrl)
+	h := mock.NewMockhub(ctrl)
+	svc := New(Config{}, db, nil, h, nil, nil, nil, nil)
+
+	db.EXPECT().RevokeUserSessions(gomock.Any(), int64(1), "session1", gomock.Any()).Return([]string{"session2", "session3"}, nil)
+	h.EXPECT().DisconnectSession(int64(1), "session2")
+	h.EXPECT().DisconnectSession(int64(1), "session3")
+	require.NoError(t, svc.RevokeOtherSessions(context.Background(), 1, "session1"))
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"io"
+	"log"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/google/uuid"
+)
+
+// CreateUpload starts a resumable upload of a recording made by the user in the room
+func (s *Service) CreateUpload(ctx context.Context, userID int64, roomID string, contentType string, length int64) (*domain.Upload, error) {
+	if length <= 0 || (s.cfg.RecordingMaxUploadSize > 0 && length > s.cfg.RecordingMaxUploadSize) {
+		return nil, domain.ErrUploadInvalidLength
+	}
+
+	ok, err := s.db.IsRoomMember(ctx, roomID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, domain.ErrRoomAccessDenied
+	}
+
+	now := time.Now()
+	uploadID := uuid.NewString()
+	upload := &domain.Upload{
+		UploadID:    uploadID,
+		RoomID:      roomID,
+		UserID:      userID,
+		File:        uploadID,
+		ContentType: contentType,
+		Length:      length,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	if err = s.db.CreateUpload(ctx, upload); err != nil {
+		return nil, err
+	}
+
+	return upload, nil
+}
+
+// GetUpload returns the upload if it was created by the user
+func (s *Service) GetUpload(ctx context.Context, userID int64, uploadID string) (*domain.Upload, error) {
+	upload, err := s.db.GetUpload(ctx, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	if upload.UserID != userID {
+		return nil, domain.ErrUploadAccessDenied
+	}
+
+	return upload, nil
+}
+
+func (s *Service) ListUploads(ctx context.Context, userID int64) ([]domain.Upload, error) {
+	return s.db.GetUserUploads(ctx, userID)
+}
+
+// WriteUpload appends the chunk of size bytes (-1 when unknown) to the upload at offset, once
+// the last byte is received the upload is turned into a recording which is returned along with
+// the upload. Chunks going past the declared length are refused with ErrUploadChunkTooLarge
+func (s *Service) WriteUpload(
+	ctx context.Context,
+	userID int64,
+	uploadID string,
+	offset int64,
+	chunk io.Reader,
+	size int64,
+) (*domain.Upload, *domain.Recording, error) {
+	unlock := s.lockUpload(uploadID)
+	defer unlock()
+
+	upload, err := s.GetUpload(ctx, userID, uploadID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if upload.Offset != offset {
+		return upload, nil, domain.ErrUploadOffsetMismatch
+	}
+
+	remaining := upload.Length - upload.Offset
+	if size > remaining {
+		return upload, nil, domain.ErrUploadChunkTooLarge
+	}
+
+	// never accept more than the declared length, a chunk of unknown size is cut
+	// at the declared length and refused once more bytes are read
+	chunk = &chunkReader{r: chunk, n: remaining}
+
+	written, writeErr := s.storage.Append(upload.File, offset, chunk)
+	if written > upload.Offset {
+		// persist whatever was received so the client can resume from there
+		if err = s.db.SetUploadOffset(ctx, upload.UploadID, written); err != nil {
+			return nil, nil, err
+		}
+		upload.Offset = written
+	}
+
+	if writeErr != nil {
+		if errors.Is(writeErr, domain.ErrUploadOffsetMismatch) || errors.Is(writeErr, domain.ErrUploadChunkTooLarge) {
+			return upload, nil, writeErr
+		}
+		log.Printf("service.WriteUpload: append upload %s: %v", upload.UploadID, writeErr)
+		return nil, nil, writeErr
+	}
+
+	if upload.Offset < upload.Length {
+		return upload, nil, nil
+	}
+
+	rec := &domain.Recording{
+		RecordingID: upload.UploadID,
+		RoomID:      upload.RoomID,
+		UserID:      upload.UserID,
+		File:        upload.File,
+		ContentType: upload.ContentType,
+		Size:        upload.Length,
+		CreatedAt:   time.Now(),
+	}
+
+	if err = s.db.CompleteUpload(ctx, upload.UploadID, rec); err != nil {
+		return nil, nil, err
+	}
+
+	return upload, rec, nil
+}
+
+// DeleteUpload aborts the upload and discards the received data
+func (s *Service) DeleteUpload(ctx context.Context, userID int64, uploadID string) error {
+	unlock := s.lockUpload(uploadID)
+	defer unlock()
+
+	upload, err := s.GetUpload(ctx, userID, uploadID)
+	if err != nil {
+		return err
+	}
+
+	return s.deleteUpload(ctx, upload)
+}
+
+// PurgeUploads deletes the uploads that were abandoned for longer than the upload ttl
+func (s *Service) PurgeUploads(ctx context.Context) error {
+	if s.cfg.RecordingUploadTTL <= 0 {
+		return nil
+	}
+
+	before := time.Now().Add(-s.cfg.RecordingUploadTTL)
+	uploads, err := s.db.GetStaleUploads(ctx, before)
+	if err != nil {
+		return err
+	}
+
+	for i := range uploads {
+		if err := s.purgeUpload(ctx, uploads[i].UploadID, before); err != nil {
+			log.Printf("service.PurgeUploads: delete upload %s: %v", uploads[i].UploadID, err)
+		}
+	}
+
+	return nil
+}
+
+// purgeUpload deletes the upload if it is still stale, a write may have resumed
+// or completed it since it was listed
+func (s *Service) purgeUpload(ctx context.Context, uploadID string, before time.Time) error {
+	unlock := s.lockUpload(uploadID)
+	defer unlock()
+
+	upload, err := s.db.GetUpload(ctx, uploadID)
+	if err != nil {
+		if errors.Is(err, domain.ErrDBUploadNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if !upload.UpdatedAt.Before(before) {
+		return nil
+	}
+
+	return s.deleteUpload(ctx, upload)
+}
+
+// deleteUpload removes the row before the file, a failed removal leaves an orphan
+// file rather than an upload without its received data
+func (s *Service) deleteUpload(ctx context.Context, upload *domain.Upload) error {
+	if err := s.db.DeleteUpload(ctx, upload.UploadID); err != nil {
+		return err
+	}
+
+	if err := s.storage.Remove(upload.File); err != nil {
+		log.Printf("service.deleteUpload: remove file %s: %v", upload.File, err)
+	}
+
+	return nil
+}
+
+// lockUpload serializes the writes and the deletion of the upload, the uploads share a fixed set of locks
+func (s *Service) lockUpload(uploadID string) func() {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(uploadID))
+
+	mu := &s.uploadLocks[h.Sum32()%uint32(len(s.uploadLocks))]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// chunkReader reads up to n bytes from r and fails with ErrUploadChunkTooLarge if r has more
+type chunkReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if c.n <= 0 {
+		var b [1]byte
+		if n, err := c.r.Read(b[:]); n > 0 {
+			return 0, domain.ErrUploadChunkTooLarge
+		} else if err != nil {
+			return 0, err
+		}
+		return 0, nil
+	}
+
+	if int64(len(p)) > c.n {
+		p = p[:c.n]
+	}
+	n, err := c.r.Read(p)
+	c.n -= int64(n)
+	return n, err
+}
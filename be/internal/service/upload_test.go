@@ -0,0 +1,298 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/escalopa/vego/internal/service/mock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_CreateUpload(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		length   int64
+		isMember bool
+		wantErr  error
+	}{
+		{"valid_upload", 10, true, nil},
+		{"empty_upload", 0, true, domain.ErrUploadInvalidLength},
+		{"too_large_upload", 101, true, domain.ErrUploadInvalidLength},
+		{"not_room_member", 10, false, domain.ErrRoomAccessDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			db := mock.NewMockdatabase(ctrl)
+			svc := New(Config{RecordingMaxUploadSize: 100}, db, nil, nil, nil, nil, nil, nil)
+
+			if tt.length > 0 && tt.length <= 100 {
+				db.EXPECT().IsRoomMember(gomock.Any(), "room1", int64(1)).Return(tt.isMember, nil)
+			}
+			if tt.wantErr == nil {
+				db.EXPECT().CreateUpload(gomock.Any(), gomock.Any()).Return(nil)
+			}
+
+			upload, err := svc.CreateUpload(context.Background(), 1, "room1", "video/webm", tt.length)
+			require.Equal(t, tt.wantErr, err)
+			if tt.wantErr == nil {
+				require.Equal(t, tt.length, upload.Length)
+				require.Zero(t, upload.Offset)
+			}
+		})
+	}
+}
+
+func TestService_WriteUpload(t *testing.T) {
+	t.Parallel()
+
+	errDisk := errors.New("disk failure")
+
+	tests := []struct {
+		name         string
+		offset       int64
+		chunk        string
+		size         int64
+		appendErr    error
+		wantOffset   int64
+		wantComplete bool
+		wantErr      error
+	}{
+		{"partial_chunk", 4, "5678", 4, nil, 8, false, nil},
+		{"last_chunk", 4, "567890", 6, nil, 10, true, nil},
+		{"unknown_size_chunk", 4, "567890", -1, nil, 10, true, nil},
+		{"oversized_chunk", 4, "567890abc", 9, nil, 4, false, domain.ErrUploadChunkTooLarge},
+		{"oversized_unknown_size_chunk", 4, "567890abc", -1, nil, 10, false, domain.ErrUploadChunkTooLarge},
+		{"offset_mismatch", 2, "3456", 4, nil, 4, false, domain.ErrUploadOffsetMismatch},
+		{"append_failure", 4, "5678", 4, errDisk, 0, false, errDisk},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			db := mock.NewMockdatabase(ctrl)
+			st := mock.NewMockstorage(ctrl)
+			svc := New(Config{}, db, st, nil, nil, nil, nil, nil)
+
+			upload := &domain.Upload{UploadID: "up1", RoomID: "room1", UserID: 1, File: "up1", Length: 10, Offset: 4}
+			db.EXPECT().GetUpload(gomock.Any(), upload.UploadID).Return(upload, nil)
+			if tt.offset == upload.Offset && tt.size <= upload.Length-upload.Offset {
+				st.EXPECT().Append(upload.File, tt.offset, gomock.Any()).DoAndReturn(func(_ string, offset int64, content io.Reader) (int64, error) {
+					if tt.appendErr != nil {
+						return 0, tt.appendErr // a failure before anything is written
+					}
+					n, err := io.Copy(io.Discard, content)
+					return offset + n, err
+				})
+			}
+			if tt.wantOffset > upload.Offset {
+				db.EXPECT().SetUploadOffset(gomock.Any(), upload.UploadID, tt.wantOffset).Return(nil)
+			}
+			if tt.wantComplete {
+				db.EXPECT().CompleteUpload(gomock.Any(), upload.UploadID, gomock.Any()).Return(nil)
+			}
+
+			got, rec, err := svc.WriteUpload(context.Background(), 1, upload.UploadID, tt.offset, strings.NewReader(tt.chunk), tt.size)
+			require.Equal(t, tt.wantErr, err)
+			require.Equal(t, tt.wantComplete, rec != nil)
+			if got != nil {
+				require.Equal(t, tt.wantOffset, got.Offset)
+			}
+		})
+	}
+}
+
+func TestService_WriteUpload_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := mock.NewMockdatabase(ctrl)
+	st := mock.NewMockstorage(ctrl)
+	svc := New(Config{}, db, st, nil, nil, nil, nil, nil)
+
+	// the upload as stored, the second write must see the offset of the first one
+	upload := domain.Upload{UploadID: "up1", RoomID: "room1", UserID: 1, File: "up1", Length: 10}
+	db.EXPECT().GetUpload(gomock.Any(), upload.UploadID).DoAndReturn(func(context.Context, string) (*domain.Upload, error) {
+		got := upload
+		return &got, nil
+	}).Times(2)
+	st.EXPECT().Append(upload.File, int64(0), gomock.Any()).DoAndReturn(func(_ string, offset int64, content io.Reader) (int64, error) {
+		n, err := io.Copy(io.Discard, content)
+		return offset + n, err
+	})
+	db.EXPECT().SetUploadOffset(gomock.Any(), upload.UploadID, int64(4)).DoAndReturn(func(_ context.Context, _ string, offset int64) error {
+		upload.Offset = offset
+		return nil
+	})
+
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, 2)
+	)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, errs[i] = svc.WriteUpload(context.Background(), 1, upload.UploadID, 0, strings.NewReader("1234"), 4)
+		}()
+	}
+	wg.Wait()
+
+	require.ElementsMatch(t, []error{nil, domain.ErrUploadOffsetMismatch}, errs)
+}
+
+func TestService_GetUpload(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := mock.NewMockdatabase(ctrl)
+	svc := New(Config{}, db, nil, nil, nil, nil, nil, nil)
+
+	upload := &domain.Upload{UploadID: "up1", UserID: 1}
+	db.EXPECT().GetUpload(gomock.Any(), upload.UploadID).Return(upload, nil)
+
+	_, err := svc.GetUpload(context.Background(), 2, upload.UploadID)
+	require.Equal(t, domain.ErrUploadAccessDenied, err)
+}
+
+func TestService_DeleteUpload(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		deleteErr error
+		removeErr error
+		wantErr   error
+	}{
+		{"deleted", nil, nil, nil},
+		{"file_not_removed", nil, errors.New("remove failed"), nil},
+		{"row_not_deleted", domain.ErrDBQuery, nil, domain.ErrDBQuery},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			db := mock.NewMockdatabase(ctrl)
+			st := mock.NewMockstorage(ctrl)
+			svc := New(Config{}, db, st, nil, nil, nil, nil, nil)
+
+			upload := &domain.Upload{UploadID: "up1", UserID: 1, File: "up1"}
+			db.EXPECT().GetUpload(gomock.Any(), upload.UploadID).Return(upload, nil)
+			deleteCall := db.EXPECT().DeleteUpload(gomock.Any(), upload.UploadID).Return(tt.deleteErr)
+			if tt.deleteErr == nil {
+				// the file is only removed once the row is gone
+				st.EXPECT().Remove(upload.File).Return(tt.removeErr).After(deleteCall)
+			}
+
+			require.Equal(t, tt.wantErr, svc.DeleteUpload(context.Background(), 1, upload.UploadID))
+		})
+	}
+}
+
+func TestService_PurgeUploads(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := mock.NewMockdatabase(ctrl)
+	st := mock.NewMockstorage(ctrl)
+	svc := New(Config{RecordingUploadTTL: time.Hour}, db, st, nil, nil, nil, nil, nil)
+
+	stale := time.Now().Add(-2 * time.Hour)
+	uploads := []domain.Upload{
+		{UploadID: "up1", File: "up1", UpdatedAt: stale},
+		{UploadID: "up2", File: "up2", UpdatedAt: stale},
+		{UploadID: "up3", File: "up3", UpdatedAt: stale},
+	}
+	db.EXPECT().GetStaleUploads(gomock.Any(), gomock.Any()).Return(uploads, nil)
+
+	// up1 is still stale, up2 was resumed and up3 completed since they were listed
+	db.EXPECT().GetUpload(gomock.Any(), "up1").Return(&uploads[0], nil)
+	db.EXPECT().GetUpload(gomock.Any(), "up2").Return(&domain.Upload{UploadID: "up2", File: "up2", UpdatedAt: time.Now()}, nil)
+	db.EXPECT().GetUpload(gomock.Any(), "up3").Return(nil, domain.ErrDBUploadNotFound)
+	db.EXPECT().DeleteUpload(gomock.Any(), "up1").Return(nil)
+	st.EXPECT().Remove("up1").Return(nil)
+
+	require.NoError(t, svc.PurgeUploads(context.Background()))
+}
+
+func TestService_DeleteUpload_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := mock.NewMockdatabase(ctrl)
+	st := mock.NewMockstorage(ctrl)
+	svc := New(Config{}, db, st, nil, nil, nil, nil, nil)
+
+	// a write waiting on the deletion must not append to the removed file
+	var deleted bool
+	upload := domain.Upload{UploadID: "up1", RoomID: "room1", UserID: 1, File: "up1", Length: 10}
+	db.EXPECT().GetUpload(gomock.Any(), upload.UploadID).DoAndReturn(func(context.Context, string) (*domain.Upload, error) {
+		if deleted {
+			return nil, domain.ErrDBUploadNotFound
+		}
+		got := upload
+		return &got, nil
+	}).Times(2)
+	db.EXPECT().DeleteUpload(gomock.Any(), upload.UploadID).DoAndReturn(func(context.Context, string) error {
+		deleted = true
+		return nil
+	})
+	st.EXPECT().Remove(upload.File).Return(nil)
+	st.EXPECT().Append(upload.File, int64(0), gomock.Any()).DoAndReturn(func(_ string, offset int64, content io.Reader) (int64, error) {
+		require.False(t, deleted)
+		n, err := io.Copy(io.Discard, content)
+		return offset + n, err
+	}).MaxTimes(1)
+	db.EXPECT().SetUploadOffset(gomock.Any(), upload.UploadID, int64(4)).Return(nil).MaxTimes(1)
+
+	var (
+		wg                  sync.WaitGroup
+		deleteErr, writeErr error
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		deleteErr = svc.DeleteUpload(context.Background(), 1, upload.UploadID)
+	}()
+	go func() {
+		defer wg.Done()
+		_, _, writeErr = svc.WriteUpload(context.Background(), 1, upload.UploadID, 0, strings.NewReader("1234"), 4)
+	}()
+	wg.Wait()
+
+	require.NoError(t, deleteErr)
+	// the write either ran before the deletion or found the upload gone
+	if writeErr != nil {
+		require.ErrorIs(t, writeErr, domain.ErrDBUploadNotFound)
+	}
+}
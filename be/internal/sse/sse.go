@@ -0,0 +1,211 @@
+package sse
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const inboxSize = 64 // max posted messages waiting to be read by the room
+
+// Registry keeps the open event streams so the events posted by the
+// clients reach the room through the stream they belong to
+type Registry struct {
+	streams map[string]*Conn
+	mutex   sync.RWMutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{streams: make(map[string]*Conn)}
+}
+
+// Open starts an event stream on w, the first event sent is the id of the
+// stream the client must post its events to
+func (r *Registry) Open(ctx context.Context, w http.ResponseWriter, userID int64, roomID string) (*Conn, error) {
+	c := &Conn{
+		streamID: uuid.NewString(),
+		userID:   userID,
+		roomID:   roomID,
+		registry: r,
+		w:        w,
+		rc:       http.NewResponseController(w),
+		ctx:      ctx,
+		inbox:    make(chan []byte, inboxSize),
+		left:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // disable proxy buffering
+	w.WriteHeader(http.StatusOK)
+
+	if err := c.write(fmt.Sprintf("event: stream\ndata: {\"stream_id\":%q}\n\n", c.streamID)); err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	r.streams[c.streamID] = c
+	r.mutex.Unlock()
+
+	return c, nil
+}
+
+// Get returns the stream of the user in the room
+func (r *Registry) Get(streamID string, userID int64, roomID string) (*Conn, error) {
+	r.mutex.RLock()
+	c, ok := r.streams[streamID]
+	r.mutex.RUnlock()
+
+	if !ok || c.userID != userID || c.roomID != roomID {
+		return nil, domain.ErrStreamNotFound
+	}
+	return c, nil
+}
+
+func (r *Registry) remove(streamID string) {
+	r.mutex.Lock()
+	delete(r.streams, streamID)
+	r.mutex.Unlock()
+}
+
+// Conn is a room connection where the server sends the events over Server-Sent
+// Events and the client posts its events, it behaves like a websocket connection
+type Conn struct {
+	streamID string
+	userID   int64
+	roomID   string
+	registry *Registry
+
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+	ctx    context.Context // done once the client drops the stream
+	closed bool
+	mutex  sync.Mutex // guards w and closed
+
+	readLimit atomic.Int64 // max size of a posted message, 0 means no limit
+	inbox     chan []byte
+	left      chan struct{} // closed once the client leaves the room
+	leaveOnce sync.Once
+	done      chan struct{} // closed once the connection is closed
+	closeOnce sync.Once
+}
+
+// Post queues a message sent by the client to be read by the room
+func (c *Conn) Post(ctx context.Context, message []byte) error {
+	if limit := c.readLimit.Load(); limit > 0 && int64(len(message)) > limit {
+		return domain.ErrStreamMessageTooLarge
+	}
+
+	if c.ended() {
+		return domain.ErrStreamClosed
+	}
+
+	select {
+	case c.inbox <- message:
+		return nil
+	case <-c.left:
+		return domain.ErrStreamClosed
+	case <-c.done:
+		return domain.ErrStreamClosed
+	case <-c.ctx.Done():
+		return domain.ErrStreamClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ended reports whether the client left or the stream is closed or dropped
+func (c *Conn) ended() bool {
+	select {
+	case <-c.left:
+		return true
+	case <-c.done:
+		return true
+	case <-c.ctx.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// Leave tells the room the client left on purpose, as a websocket normal closure does
+func (c *Conn) Leave() {
+	c.leaveOnce.Do(func() { close(c.left) })
+}
+
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	select {
+	case message := <-c.inbox:
+		return websocket.TextMessage, message, nil
+	case <-c.left:
+		return 0, nil, &websocket.CloseError{Code: websocket.CloseNormalClosure}
+	case <-c.done:
+		return 0, nil, domain.ErrStreamClosed
+	case <-c.ctx.Done():
+		return 0, nil, fmt.Errorf("sse: stream dropped: %w", c.ctx.Err())
+	}
+}
+
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	switch messageType {
+	case websocket.TextMessage:
+		return c.write("data: " + string(data) + "\n\n")
+	case websocket.PingMessage:
+		return c.write(": ping\n\n") // comments keep the stream alive through proxies
+	case websocket.CloseMessage:
+		code, reason := websocket.CloseNoStatusReceived, ""
+		if len(data) >= 2 {
+			code, reason = int(binary.BigEndian.Uint16(data)), string(data[2:])
+		}
+		return c.write(fmt.Sprintf("event: close\ndata: {\"code\":%d,\"reason\":%q}\n\n", code, reason))
+	default:
+		return errors.New("sse: only text messages are supported")
+	}
+}
+
+// SetReadLimit limits the size of the messages the client posts
+func (c *Conn) SetReadLimit(limit int64) {
+	c.readLimit.Store(limit)
+}
+
+// Subprotocol returns no subprotocol since event streams only carry json
+func (c *Conn) Subprotocol() string {
+	return ""
+}
+
+// Close stops reading and writing to the stream, the stream ends once its handler returns
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		c.mutex.Lock()
+		c.closed = true
+		c.mutex.Unlock()
+
+		close(c.done)
+		c.registry.remove(c.streamID)
+	})
+	return nil
+}
+
+func (c *Conn) write(event string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return domain.ErrStreamClosed
+	}
+
+	if _, err := c.w.Write([]byte(event)); err != nil {
+		return err
+	}
+	return c.rc.Flush()
+}
@@ -0,0 +1,72 @@
+package sse
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConn(t *testing.T) {
+	t.Parallel()
+
+	r := NewRegistry()
+	w := httptest.NewRecorder()
+
+	c, err := r.Open(context.Background(), w, 1, "room1")
+	require.NoError(t, err)
+	require.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	require.Equal(t, "event: stream\ndata: {\"stream_id\":\""+c.streamID+"\"}\n\n", w.Body.String())
+
+	// the stream belongs to the user in the room only
+	_, err = r.Get(c.streamID, 2, "room1")
+	require.ErrorIs(t, err, domain.ErrStreamNotFound)
+	_, err = r.Get(c.streamID, 1, "room2")
+	require.ErrorIs(t, err, domain.ErrStreamNotFound)
+
+	got, err := r.Get(c.streamID, 1, "room1")
+	require.NoError(t, err)
+	require.Same(t, c, got)
+
+	require.NoError(t, c.Post(context.Background(), []byte(`{"type":"chat-message"}`)))
+	messageType, message, err := c.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, websocket.TextMessage, messageType)
+	require.Equal(t, `{"type":"chat-message"}`, string(message))
+
+	w.Body.Reset()
+	require.NoError(t, c.WriteMessage(websocket.TextMessage, []byte(`{"type":"leave"}`)))
+	require.NoError(t, c.WriteMessage(websocket.PingMessage, nil))
+	require.Equal(t, "data: {\"type\":\"leave\"}\n\n: ping\n\n", w.Body.String())
+	require.Error(t, c.WriteMessage(websocket.BinaryMessage, []byte{0x80}))
+
+	c.Leave()
+	_, _, err = c.ReadMessage()
+	require.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure))
+	require.ErrorIs(t, c.Post(context.Background(), []byte(`{}`)), domain.ErrStreamClosed)
+
+	require.NoError(t, c.Close())
+	require.ErrorIs(t, c.WriteMessage(websocket.TextMessage, []byte(`{}`)), domain.ErrStreamClosed)
+	_, err = r.Get(c.streamID, 1, "room1")
+	require.ErrorIs(t, err, domain.ErrStreamNotFound)
+}
+
+func TestConn_Dropped(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c, err := NewRegistry().Open(ctx, httptest.NewRecorder(), 1, "room1")
+	require.NoError(t, err)
+
+	cancel()
+
+	// a dropped stream is not a clean leave so the client may resume
+	_, _, err = c.ReadMessage()
+	require.Error(t, err)
+	require.False(t, websocket.IsCloseError(err, websocket.CloseNormalClosure))
+	require.True(t, strings.Contains(err.Error(), "dropped"))
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/escalopa/vego/internal/domain"
+)
+
+// Local stores files in a directory on the local filesystem
+type Local struct {
+	dir string
+}
+
+func NewLocal(dir string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &Local{dir: dir}, nil
+}
+
+func (l *Local) Open(name string) (io.ReadSeekCloser, error) {
+	f, err := os.Open(l.path(name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, domain.ErrStorageFileNotFound
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// Append writes the content at offset of the named file creating it when missing,
+// it returns the file size after writing even when copying the content fails midway
+func (l *Local) Append(name string, offset int64, content io.Reader) (int64, error) {
+	f, err := os.OpenFile(l.path(name), os.O_WRONLY|os.O_CREATE, 0o640)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = f.Close() }()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	if info.Size() != offset {
+		return info.Size(), domain.ErrUploadOffsetMismatch
+	}
+
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+
+	n, err := io.Copy(f, content)
+	return offset + n, err
+}
+
+func (l *Local) Remove(name string) error {
+	err := os.Remove(l.path(name))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// path resolves the name inside the storage directory, any directory components are dropped
+func (l *Local) path(name string) string {
+	return filepath.Join(l.dir, filepath.Base(name))
+}
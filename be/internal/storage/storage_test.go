@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/escalopa/vego/internal/domain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocal_Append(t *testing.T) {
+	l, err := NewLocal(t.TempDir())
+	require.NoError(t, err)
+
+	const name = "upload"
+
+	size, err := l.Append(name, 0, strings.NewReader("hello "))
+	require.NoError(t, err)
+	require.Equal(t, int64(6), size)
+
+	// resuming from a stale offset must not overwrite received data
+	size, err = l.Append(name, 2, strings.NewReader("xx"))
+	require.ErrorIs(t, err, domain.ErrUploadOffsetMismatch)
+	require.Equal(t, int64(6), size)
+
+	size, err = l.Append(name, 6, strings.NewReader("world"))
+	require.NoError(t, err)
+	require.Equal(t, int64(11), size)
+
+	f, err := l.Open(name)
+	require.NoError(t, err)
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.Equal(t, "hello world", string(data))
+
+	require.NoError(t, l.Remove(name))
+	_, err = l.Open(name)
+	require.ErrorIs(t, err, domain.ErrStorageFileNotFound)
+}
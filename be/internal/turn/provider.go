@@ -0,0 +1,74 @@
+package turn
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/escalopa/vego/internal/config"
+	"github.com/escalopa/vego/internal/domain"
+	pionturn "github.com/pion/turn/v3"
+)
+
+// Provider issues the ICE servers clients connect through, credentials of the embedded
+// server and of servers configured with a secret key are time-limited following the
+// TURN REST API scheme, the username is "<expiry unix timestamp>:<user id>" and the
+// password is its HMAC-SHA1
+type Provider struct {
+	servers       []config.ICEServerConfig
+	credentialTTL time.Duration
+}
+
+// NewProvider creates a provider serving the configured ICE servers followed by the
+// embedded server when enabled, issued credentials are valid for credentialTTL
+func NewProvider(iceCfg config.ICEConfig, turnCfg config.TURNConfig, credentialTTL time.Duration) *Provider {
+	servers := slices.Clone(iceCfg.Servers) // appending must not write into the config
+	if turnCfg.Enabled {
+		addr := fmt.Sprintf("%s:%d", turnCfg.Host, turnCfg.Port)
+		servers = append(servers,
+			config.ICEServerConfig{
+				URLs: []string{"stun:" + addr},
+			},
+			config.ICEServerConfig{
+				URLs: []string{
+					"turn:" + addr + "?transport=udp",
+					"turn:" + addr + "?transport=tcp",
+				},
+				SecretKey: turnCfg.SecretKey,
+			},
+		)
+	}
+
+	return &Provider{
+		servers:       servers,
+		credentialTTL: credentialTTL,
+	}
+}
+
+// CreateICEServers returns the ICE servers along with credentials for the user
+func (p *Provider) CreateICEServers(userID int64) ([]domain.ICEServer, error) {
+	user := strconv.FormatInt(userID, 10)
+
+	servers := make([]domain.ICEServer, 0, len(p.servers))
+	for _, s := range p.servers {
+		server := domain.ICEServer{
+			URLs:       s.URLs,
+			Username:   s.Username,
+			Credential: s.Credential,
+		}
+
+		if s.SecretKey != "" {
+			username, password, err := pionturn.GenerateLongTermTURNRESTCredentials(s.SecretKey, user, p.credentialTTL)
+			if err != nil {
+				return nil, err
+			}
+			server.Username = username
+			server.Credential = password
+		}
+
+		servers = append(servers, server)
+	}
+
+	return servers, nil
+}
@@ -0,0 +1,103 @@
+package turn
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/escalopa/vego/internal/config"
+	"github.com/escalopa/vego/internal/domain"
+	pionturn "github.com/pion/turn/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvider_CreateICEServers(t *testing.T) {
+	t.Parallel()
+
+	iceCfg := config.ICEConfig{
+		Servers: []config.ICEServerConfig{
+			{
+				URLs: []string{"stun:stun.example.com:3478"},
+			},
+			{
+				URLs:       []string{"turn:static.example.com:3478"},
+				Username:   "static-user",
+				Credential: "static-credential",
+			},
+			{
+				URLs:      []string{"turn:rest.example.com:3478"},
+				SecretKey: "rest-secret",
+			},
+		},
+	}
+
+	turnCfg := config.TURNConfig{
+		Realm:     "vego",
+		Host:      "turn.example.com",
+		Port:      3478,
+		SecretKey: "test-secret",
+	}
+
+	tests := []struct {
+		name        string
+		turnEnabled bool
+		wantServers int
+	}{
+		{"embedded_server_enabled", true, 5},
+		{"embedded_server_disabled", false, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			turnCfg := turnCfg
+			turnCfg.Enabled = tt.turnEnabled
+			p := NewProvider(iceCfg, turnCfg, time.Minute)
+
+			servers, err := p.CreateICEServers(1)
+			require.NoError(t, err)
+			require.Len(t, servers, tt.wantServers)
+
+			require.Equal(t, domain.ICEServer{URLs: iceCfg.Servers[0].URLs}, servers[0])
+			require.Equal(t, domain.ICEServer{
+				URLs:       iceCfg.Servers[1].URLs,
+				Username:   "static-user",
+				Credential: "static-credential",
+			}, servers[1])
+			requireRESTCredentials(t, "rest-secret", servers[2])
+
+			if tt.turnEnabled {
+				require.Equal(t, []string{"stun:turn.example.com:3478"}, servers[3].URLs)
+				requireRESTCredentials(t, turnCfg.SecretKey, servers[4])
+			}
+		})
+	}
+}
+
+func TestNewProvider_ConfigUnchanged(t *testing.T) {
+	t.Parallel()
+
+	// spare capacity the embedded server would be appended into when shared
+	servers := make([]config.ICEServerConfig, 1, 4)
+	servers[0] = config.ICEServerConfig{URLs: []string{"stun:stun.example.com:3478"}}
+	iceCfg := config.ICEConfig{Servers: servers}
+
+	NewProvider(iceCfg, config.TURNConfig{Enabled: true, Host: "turn.example.com", Port: 3478}, time.Minute)
+	require.Empty(t, servers[:cap(servers)][1].URLs)
+}
+
+// requireRESTCredentials checks the credentials are accepted by a server using the secret key
+func requireRESTCredentials(t *testing.T, secretKey string, server domain.ICEServer) {
+	t.Helper()
+
+	const realm = "vego"
+
+	require.True(t, strings.HasSuffix(server.Username, ":1"))
+
+	auth := pionturn.LongTermTURNRESTAuthHandler(secretKey, nil)
+	key, ok := auth(server.Username, realm, &net.UDPAddr{})
+	require.True(t, ok)
+	require.Equal(t, pionturn.GenerateAuthKey(server.Username, realm, server.Credential), key)
+}
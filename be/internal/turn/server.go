@@ -0,0 +1,66 @@
+package turn
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/escalopa/vego/internal/config"
+	pionturn "github.com/pion/turn/v3"
+)
+
+// Server is an embedded TURN/STUN server authenticating clients
+// with the ephemeral credentials issued by the Provider
+type Server struct {
+	srv *pionturn.Server
+}
+
+func NewServer(cfg config.TURNConfig) (*Server, error) {
+	relayIP := net.ParseIP(cfg.RelayIP)
+	if relayIP == nil {
+		return nil, fmt.Errorf("invalid relay ip: %q", cfg.RelayIP)
+	}
+
+	listenAddr := fmt.Sprintf("%s:%d", cfg.ListenIP, cfg.Port)
+
+	udpListener, err := net.ListenPacket("udp4", listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("listen udp: %w", err)
+	}
+
+	tcpListener, err := net.Listen("tcp4", listenAddr)
+	if err != nil {
+		_ = udpListener.Close()
+		return nil, fmt.Errorf("listen tcp: %w", err)
+	}
+
+	relayGenerator := func() pionturn.RelayAddressGenerator {
+		return &pionturn.RelayAddressGeneratorPortRange{
+			RelayAddress: relayIP,
+			Address:      cfg.ListenIP,
+			MinPort:      cfg.RelayMinPort,
+			MaxPort:      cfg.RelayMaxPort,
+		}
+	}
+
+	srv, err := pionturn.NewServer(pionturn.ServerConfig{
+		Realm:       cfg.Realm,
+		AuthHandler: pionturn.LongTermTURNRESTAuthHandler(cfg.SecretKey, nil),
+		PacketConnConfigs: []pionturn.PacketConnConfig{
+			{PacketConn: udpListener, RelayAddressGenerator: relayGenerator()},
+		},
+		ListenerConfigs: []pionturn.ListenerConfig{
+			{Listener: tcpListener, RelayAddressGenerator: relayGenerator()},
+		},
+	})
+	if err != nil {
+		_ = udpListener.Close()
+		_ = tcpListener.Close()
+		return nil, err
+	}
+
+	return &Server{srv: srv}, nil
+}
+
+func (s *Server) Close() error {
+	return s.srv.Close()
+}
@@ -1,9 +1,11 @@
 "use client"
 
-import { useEffect } from "react"
+import { useEffect, useState, type FormEvent } from "react"
 import { useRouter } from "next/navigation"
+import { Button, TextField, Typography } from "@mui/material"
 import { useAuth } from "@/hooks/use-auth"
-import { getOAuthUrl } from "@/lib/api"
+import { getLoginOptions, getOAuthUrl, localLogin, localRegister } from "@/lib/api"
+import type { LoginOptions } from "@/lib/types"
 import {
   PageContainer,
   ContentCard,
@@ -17,9 +19,27 @@ import {
   LoadingSpinner,
 } from "@/components/styled"
 
+const providerIcons: Record<string, string> = {
+  google: "https://svgrepo.com/show/475656/google-color.svg",
+  github: "https://svgrepo.com/show/512317/github-142.svg",
+  yandex: "https://svgrepo.com/show/197976/yandex.svg",
+}
+
 export default function Login() {
   const router = useRouter()
-  const { isAuthenticated, isLoading } = useAuth()
+  const { isAuthenticated, isLoading, checkAuth } = useAuth()
+  const [options, setOptions] = useState<LoginOptions>({ providers: [], local: { enabled: false, registration: false } })
+  const [isRegistering, setIsRegistering] = useState(false)
+  const [username, setUsername] = useState("")
+  const [password, setPassword] = useState("")
+  const [email, setEmail] = useState("")
+  const [error, setError] = useState<string | null>(null)
+
+  useEffect(() => {
+    getLoginOptions()
+      .then(setOptions)
+      .catch((error) => console.error("Login options error:", error))
+  }, [])
 
   useEffect(() => {
     if (!isLoading && isAuthenticated) {
@@ -37,6 +57,23 @@ export default function Login() {
     }
   }
 
+  const handleLocalLogin = async (event: FormEvent) => {
+    event.preventDefault()
+    setError(null)
+    try {
+      if (isRegistering) {
+        await localRegister(username, password, email)
+      } else {
+        await localLogin(username, password)
+      }
+      if (await checkAuth()) {
+        router.push("/")
+      }
+    } catch (err: any) {
+      setError(err.message || "Failed to log in")
+    }
+  }
+
   if (isLoading) {
     return (
       <LoadingContainer>
@@ -57,21 +94,41 @@ export default function Login() {
           </div>
 
           <div>
-            <OAuthButton variant="outlined" color="primary" onClick={() => handleOAuthLogin("google")}>
-              <OAuthIcon src="https://svgrepo.com/show/475656/google-color.svg" alt="Google" />
-              Continue with Google
-            </OAuthButton>
-
-            <OAuthButton variant="outlined" onClick={() => handleOAuthLogin("github")}>
-              <OAuthIcon src="https://svgrepo.com/show/512317/github-142.svg" alt="GitHub" />
-              Continue with GitHub
-            </OAuthButton>
-
-            <OAuthButton variant="outlined" onClick={() => handleOAuthLogin("yandex")}>
-              <OAuthIcon src="https://svgrepo.com/show/197976/yandex.svg" alt="Yandex" />
-              Continue with Yandex
-            </OAuthButton>
+            {options.providers.map((provider) => (
+              <OAuthButton key={provider.name} variant="outlined" onClick={() => handleOAuthLogin(provider.name)}>
+                {providerIcons[provider.kind] && (
+                  <OAuthIcon src={providerIcons[provider.kind]} alt={provider.display_name} />
+                )}
+                Continue with {provider.display_name}
+              </OAuthButton>
+            ))}
           </div>
+
+          {options.local.enabled && (
+            <form onSubmit={handleLocalLogin} style={{ display: "flex", flexDirection: "column", gap: "12px" }}>
+              <TextField label="Username" value={username} onChange={(e) => setUsername(e.target.value)} required />
+              {isRegistering && (
+                <TextField label="Email" type="email" value={email} onChange={(e) => setEmail(e.target.value)} required />
+              )}
+              <TextField
+                label="Password"
+                type="password"
+                value={password}
+                onChange={(e) => setPassword(e.target.value)}
+                autoComplete={isRegistering ? "new-password" : "current-password"}
+                required
+              />
+              {error && <Typography color="error">{error}</Typography>}
+              <Button type="submit" variant="contained">
+                {isRegistering ? "Create account" : "Sign in"}
+              </Button>
+              {options.local.registration && (
+                <Button variant="text" onClick={() => setIsRegistering(!isRegistering)}>
+                  {isRegistering ? "I already have an account" : "Create an account"}
+                </Button>
+              )}
+            </form>
+          )}
         </CardContent>
       </ContentCard>
     </PageContainer>
@@ -12,6 +12,7 @@ export default function OAuthCallbackClient({ provider }: { provider: string })
   const searchParams = useSearchParams()
   const { checkAuth } = useAuth()
   const code = searchParams.get("code")
+  const state = searchParams.get("state") || ""
   const [error, setError] = useState<string | null>(null)
   const [isProcessing, setIsProcessing] = useState(true)
   const [hasAttemptedAuth, setHasAttemptedAuth] = useState(false)
@@ -28,7 +29,7 @@ export default function OAuthCallbackClient({ provider }: { provider: string })
     const processOAuthCallback = async () => {
       try {
         setHasAttemptedAuth(true)
-        await handleOAuthCallback(provider, code)
+        await handleOAuthCallback(provider, code, state)
         // Check if we're actually authenticated after setting tokens
         const isAuthenticated = await checkAuth()
         if (isAuthenticated) {
@@ -1,5 +1,5 @@
 import axios from "axios"
-import type { User } from "./types"
+import type { Identity, LoginOptions, User } from "./types"
 
 const api = axios.create({
   baseURL: process.env.BACKEND_URL ?? "http://localhost:8080/api",
@@ -43,6 +43,31 @@ export async function logout(): Promise<void> {
   }
 }
 
+export async function getLoginOptions(): Promise<LoginOptions> {
+  try {
+    const response = await api.get("/oauth/providers")
+    return response.data
+  } catch (error) {
+    throw error
+  }
+}
+
+export async function localLogin(username: string, password: string): Promise<void> {
+  try {
+    await api.post("/local/login", { username, password })
+  } catch (error) {
+    throw error
+  }
+}
+
+export async function localRegister(username: string, password: string, email: string): Promise<void> {
+  try {
+    await api.post("/local/register", { username, password, email })
+  } catch (error) {
+    throw error
+  }
+}
+
 export async function getOAuthUrl(provider: string): Promise<string> {
   try {
     const response = await api.get(`/oauth/${provider}`)
@@ -52,15 +77,41 @@ export async function getOAuthUrl(provider: string): Promise<string> {
   }
 }
 
-export async function handleOAuthCallback(provider: string, code: string): Promise<void> {
+export async function handleOAuthCallback(provider: string, code: string, state: string): Promise<void> {
   try {
-    await api.post(`/oauth/${provider}/callback`, { code })
+    await api.post(`/oauth/${provider}/callback`, { code, state })
     await new Promise((resolve) => setTimeout(resolve, 500))
   } catch (error) {
     throw error
   }
 }
 
+export async function getIdentities(): Promise<Identity[]> {
+  try {
+    const response = await api.get("/user/identities")
+    return response.data.identities
+  } catch (error) {
+    throw error
+  }
+}
+
+export async function linkIdentity(provider: string): Promise<string> {
+  try {
+    const response = await api.post(`/user/identities/${provider}`)
+    return response.data.url
+  } catch (error) {
+    throw error
+  }
+}
+
+export async function unlinkIdentity(provider: string): Promise<void> {
+  try {
+    await api.delete(`/user/identities/${provider}`)
+  } catch (error) {
+    throw error
+  }
+}
+
 export async function joinRoom(roomId: string): Promise<string> {
   try {
     const response = await api.post(`/room/join/${roomId}`)
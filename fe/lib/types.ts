@@ -5,6 +5,26 @@ export interface User {
   avatar: string
 }
 
+export interface OAuthProvider {
+  name: string
+  kind: string
+  display_name: string
+}
+
+export interface LoginOptions {
+  providers: OAuthProvider[]
+  local: {
+    enabled: boolean
+    registration: boolean
+  }
+}
+
+export interface Identity {
+  provider: string
+  email: string
+  created_at: string
+}
+
 export interface Participant {
   id: string
   name: string